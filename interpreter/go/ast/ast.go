@@ -1,10 +1,17 @@
 package ast
 
-import "kaze/token"
+import (
+	"kaze/token"
+	"math/big"
+	"strconv"
+	"strings"
+)
 
 type Node interface {
 	TokenLiteral() string
 	String() string
+	Pos() token.Position // position of the first character of the node
+	End() token.Position // position immediately after the node
 }
 
 type Statement interface {
@@ -29,6 +36,19 @@ func (p *Program) TokenLiteral() string {
 	}
 }
 
+func (p *Program) Pos() token.Position {
+	if len(p.Statements) > 0 {
+		return p.Statements[0].Pos()
+	}
+	return token.Position{}
+}
+func (p *Program) End() token.Position {
+	if len(p.Statements) > 0 {
+		return p.Statements[len(p.Statements)-1].End()
+	}
+	return token.Position{}
+}
+
 func (p *Program) String() string {
 	var out string
 
@@ -47,6 +67,16 @@ type VarStatement struct {
 
 func (vs *VarStatement) statementNode()       {}
 func (vs *VarStatement) TokenLiteral() string { return vs.Token.Literal }
+func (vs *VarStatement) Pos() token.Position  { return vs.Token.Pos }
+func (vs *VarStatement) End() token.Position {
+	if vs.Value != nil {
+		return vs.Value.End()
+	}
+	if vs.Name != nil {
+		return vs.Name.End()
+	}
+	return vs.Token.End
+}
 func (vs *VarStatement) String() string {
 	return vs.TokenLiteral() + " " + vs.Name.String() + " = " + vs.Value.String() + ";"
 }
@@ -58,19 +88,52 @@ type ReturnStatement struct {
 
 func (rs *ReturnStatement) statementNode()       {}
 func (rs *ReturnStatement) TokenLiteral() string { return rs.Token.Literal }
+func (rs *ReturnStatement) Pos() token.Position  { return rs.Token.Pos }
+func (rs *ReturnStatement) End() token.Position {
+	if rs.ReturnValue != nil {
+		return rs.ReturnValue.End()
+	}
+	return rs.Token.End
+}
 func (rs *ReturnStatement) String() string {
 	return rs.TokenLiteral() + " " + rs.ReturnValue.String() + ";"
 }
 
+type ThrowStatement struct {
+	Token token.Token
+	Value Expression
+}
+
+func (ts *ThrowStatement) statementNode()       {}
+func (ts *ThrowStatement) TokenLiteral() string { return ts.Token.Literal }
+func (ts *ThrowStatement) Pos() token.Position  { return ts.Token.Pos }
+func (ts *ThrowStatement) End() token.Position {
+	if ts.Value != nil {
+		return ts.Value.End()
+	}
+	return ts.Token.End
+}
+func (ts *ThrowStatement) String() string {
+	return ts.TokenLiteral() + " " + ts.Value.String() + ";"
+}
+
 type FunctionDefinitionStatement struct {
 	Token      token.Token
 	Name       *Identifier
-	Parameters []*Identifier
+	Parameters []*Parameter
 	Body       Expression
+	Slots      int // set by the resolver: the size of the scope of a call
 }
 
 func (fds *FunctionDefinitionStatement) statementNode()       {}
 func (fds *FunctionDefinitionStatement) TokenLiteral() string { return fds.Token.Literal }
+func (fds *FunctionDefinitionStatement) Pos() token.Position  { return fds.Token.Pos }
+func (fds *FunctionDefinitionStatement) End() token.Position {
+	if fds.Body != nil {
+		return fds.Body.End()
+	}
+	return fds.Token.End
+}
 func (fds *FunctionDefinitionStatement) String() string {
 	var out string
 
@@ -96,16 +159,53 @@ type WhileStatement struct {
 
 func (ws *WhileStatement) statementNode()       {}
 func (ws *WhileStatement) TokenLiteral() string { return ws.Token.Literal }
+func (ws *WhileStatement) Pos() token.Position  { return ws.Token.Pos }
+func (ws *WhileStatement) End() token.Position {
+	if ws.Body != nil {
+		return ws.Body.End()
+	}
+	return ws.Token.End
+}
 func (ws *WhileStatement) String() string {
 	return "while " + ws.Condition.String() + " " + ws.Body.String()
 }
 
+// ForStatement is a for-in loop. With one variable it binds the elements
+// (or the keys of a hash); with two it binds index and element (or key and
+// value).
+type ForStatement struct {
+	Token     token.Token
+	Variables []*Identifier
+	Iterable  Expression
+	Body      Expression
+	Slots     int // set by the resolver: the size of the scope of an iteration
+}
+
+func (fs *ForStatement) statementNode()       {}
+func (fs *ForStatement) TokenLiteral() string { return fs.Token.Literal }
+func (fs *ForStatement) Pos() token.Position  { return fs.Token.Pos }
+func (fs *ForStatement) End() token.Position {
+	if fs.Body != nil {
+		return fs.Body.End()
+	}
+	return fs.Token.End
+}
+func (fs *ForStatement) String() string {
+	var names []string
+	for _, v := range fs.Variables {
+		names = append(names, v.String())
+	}
+	return "for " + strings.Join(names, ", ") + " in " + fs.Iterable.String() + " " + fs.Body.String()
+}
+
 type BreakStatement struct {
 	Token token.Token
 }
 
 func (bs *BreakStatement) statementNode()       {}
 func (bs *BreakStatement) TokenLiteral() string { return bs.Token.Literal }
+func (bs *BreakStatement) Pos() token.Position  { return bs.Token.Pos }
+func (bs *BreakStatement) End() token.Position  { return bs.Token.End }
 func (bs *BreakStatement) String() string       { return bs.TokenLiteral() }
 
 type ContinueStatement struct {
@@ -114,6 +214,8 @@ type ContinueStatement struct {
 
 func (cs *ContinueStatement) statementNode()       {}
 func (cs *ContinueStatement) TokenLiteral() string { return cs.Token.Literal }
+func (cs *ContinueStatement) Pos() token.Position  { return cs.Token.Pos }
+func (cs *ContinueStatement) End() token.Position  { return cs.Token.End }
 func (cs *ContinueStatement) String() string       { return cs.TokenLiteral() }
 
 type ExpressionStatement struct {
@@ -123,6 +225,18 @@ type ExpressionStatement struct {
 
 func (es *ExpressionStatement) statementNode()       {}
 func (es *ExpressionStatement) TokenLiteral() string { return es.Token.Literal }
+func (es *ExpressionStatement) Pos() token.Position {
+	if es.Expression != nil {
+		return es.Expression.Pos()
+	}
+	return es.Token.Pos
+}
+func (es *ExpressionStatement) End() token.Position {
+	if es.Expression != nil {
+		return es.Expression.End()
+	}
+	return es.Token.End
+}
 func (es *ExpressionStatement) String() string {
 	if es.Expression != nil {
 		return es.Expression.String()
@@ -133,21 +247,44 @@ func (es *ExpressionStatement) String() string {
 type Identifier struct {
 	Token token.Token
 	Value string
+
+	// Set by the resolver: the variable is in Slot of the scope Depth
+	// scopes out. A negative Depth means a global, looked up by name, as
+	// are identifiers that aren't Resolved.
+	Resolved bool
+	Depth    int
+	Slot     int
 }
 
 func (i *Identifier) expressionNode()      {}
 func (i *Identifier) TokenLiteral() string { return i.Token.Literal }
+func (i *Identifier) Pos() token.Position  { return i.Token.Pos }
+func (i *Identifier) End() token.Position  { return i.Token.End }
 func (i *Identifier) String() string       { return i.Value }
 
 type IntegerLiteral struct {
 	Token token.Token
 	Value int64
+	Big   *big.Int // set instead of Value when the literal doesn't fit in int64
 }
 
 func (il *IntegerLiteral) expressionNode()      {}
 func (il *IntegerLiteral) TokenLiteral() string { return il.Token.Literal }
+func (il *IntegerLiteral) Pos() token.Position  { return il.Token.Pos }
+func (il *IntegerLiteral) End() token.Position  { return il.Token.End }
 func (il *IntegerLiteral) String() string       { return il.Token.Literal }
 
+type FloatLiteral struct {
+	Token token.Token
+	Value float64
+}
+
+func (fl *FloatLiteral) expressionNode()      {}
+func (fl *FloatLiteral) TokenLiteral() string { return fl.Token.Literal }
+func (fl *FloatLiteral) Pos() token.Position  { return fl.Token.Pos }
+func (fl *FloatLiteral) End() token.Position  { return fl.Token.End }
+func (fl *FloatLiteral) String() string       { return fl.Token.Literal }
+
 type Boolean struct {
 	Token token.Token
 	Value bool
@@ -155,6 +292,8 @@ type Boolean struct {
 
 func (b *Boolean) expressionNode()      {}
 func (b *Boolean) TokenLiteral() string { return b.Token.Literal }
+func (b *Boolean) Pos() token.Position  { return b.Token.Pos }
+func (b *Boolean) End() token.Position  { return b.Token.End }
 func (b *Boolean) String() string       { return b.Token.Literal }
 
 type PrefixExpression struct {
@@ -165,6 +304,13 @@ type PrefixExpression struct {
 
 func (pe *PrefixExpression) expressionNode()      {}
 func (pe *PrefixExpression) TokenLiteral() string { return pe.Token.Literal }
+func (pe *PrefixExpression) Pos() token.Position  { return pe.Token.Pos }
+func (pe *PrefixExpression) End() token.Position {
+	if pe.Right != nil {
+		return pe.Right.End()
+	}
+	return pe.Token.End
+}
 func (pe *PrefixExpression) String() string {
 	return "(" + pe.Operator + pe.Right.String() + ")"
 }
@@ -178,29 +324,51 @@ type InfixExpression struct {
 
 func (ie *InfixExpression) expressionNode()      {}
 func (ie *InfixExpression) TokenLiteral() string { return ie.Token.Literal }
+func (ie *InfixExpression) Pos() token.Position  { return ie.Left.Pos() }
+func (ie *InfixExpression) End() token.Position {
+	if ie.Right != nil {
+		return ie.Right.End()
+	}
+	return ie.Token.End
+}
 func (ie *InfixExpression) String() string {
 	return "(" + ie.Left.String() + " " + ie.Operator + " " + ie.Right.String() + ")"
 }
 
 type AssignExpression struct {
-	Token token.Token
-	Left  Expression
-	Value Expression
+	Token    token.Token
+	Operator string // "=", a compound operator such as "+=", or "++" or "--"
+	Left     Expression
+	Value    Expression // nil for x++ and x--, which add or subtract 1
 }
 
 func (ae *AssignExpression) expressionNode()      {}
 func (ae *AssignExpression) TokenLiteral() string { return ae.Token.Literal }
+func (ae *AssignExpression) Pos() token.Position  { return ae.Left.Pos() }
+func (ae *AssignExpression) End() token.Position {
+	if ae.Value != nil {
+		return ae.Value.End()
+	}
+	return ae.Token.End
+}
 func (ae *AssignExpression) String() string {
-	return ae.Left.String() + " = " + ae.Value.String()
+	if ae.Value == nil {
+		return ae.Left.String() + ae.Operator
+	}
+	return ae.Left.String() + " " + ae.Operator + " " + ae.Value.String()
 }
 
 type BlockExpression struct {
 	Token      token.Token
 	Statements []Statement
+	RBrace     token.Token
+	Slots      int // set by the resolver: 0 if the block needs no scope of its own
 }
 
 func (bs *BlockExpression) expressionNode()      {}
 func (bs *BlockExpression) TokenLiteral() string { return bs.Token.Literal }
+func (bs *BlockExpression) Pos() token.Position  { return bs.Token.Pos }
+func (bs *BlockExpression) End() token.Position  { return bs.RBrace.End }
 func (bs *BlockExpression) String() string {
 	var out string
 
@@ -211,14 +379,95 @@ func (bs *BlockExpression) String() string {
 	return out
 }
 
+// Parameter is a function parameter: a plain name, a name with a default
+// value (b = 10) or a rest parameter (...rest).
+type Parameter struct {
+	Token   token.Token // the '...' token for a rest parameter, else the name
+	Name    *Identifier
+	Default Expression // nil if the parameter has no default value
+	Rest    bool
+}
+
+func (p *Parameter) TokenLiteral() string { return p.Token.Literal }
+func (p *Parameter) Pos() token.Position  { return p.Token.Pos }
+func (p *Parameter) End() token.Position {
+	if p.Default != nil {
+		return p.Default.End()
+	}
+	return p.Name.End()
+}
+func (p *Parameter) String() string {
+	if p.Rest {
+		return "..." + p.Name.String()
+	}
+	if p.Default != nil {
+		return p.Name.String() + " = " + p.Default.String()
+	}
+	return p.Name.String()
+}
+
+// FunctionLiteral is an anonymous function, written either as
+// fun (a, b) { ... } or in the arrow form (a, b) => a + b.
+type FunctionLiteral struct {
+	Token      token.Token // the 'fun' token, or '(' for the arrow form
+	Parameters []*Parameter
+	Body       Expression
+	Slots      int // set by the resolver: the size of the scope of a call
+}
+
+func (fl *FunctionLiteral) expressionNode()      {}
+func (fl *FunctionLiteral) TokenLiteral() string { return fl.Token.Literal }
+func (fl *FunctionLiteral) Pos() token.Position  { return fl.Token.Pos }
+func (fl *FunctionLiteral) End() token.Position {
+	if fl.Body != nil {
+		return fl.Body.End()
+	}
+	return fl.Token.End
+}
+func (fl *FunctionLiteral) String() string {
+	var params []string
+	for _, p := range fl.Parameters {
+		params = append(params, p.String())
+	}
+
+	if fl.Token.Type == token.LPAREN {
+		return "(" + strings.Join(params, ", ") + ") => " + fl.Body.String()
+	}
+	return fl.TokenLiteral() + "(" + strings.Join(params, ", ") + ") {\n" + fl.Body.String() + "\n}"
+}
+
+// SpreadExpression expands an array into the surrounding call arguments or
+// array literal, as in f(...xs) or [0, ...xs].
+type SpreadExpression struct {
+	Token token.Token // the '...' token
+	Value Expression
+}
+
+func (se *SpreadExpression) expressionNode()      {}
+func (se *SpreadExpression) TokenLiteral() string { return se.Token.Literal }
+func (se *SpreadExpression) Pos() token.Position  { return se.Token.Pos }
+func (se *SpreadExpression) End() token.Position {
+	if se.Value != nil {
+		return se.Value.End()
+	}
+	return se.Token.End
+}
+func (se *SpreadExpression) String() string {
+	return "..." + se.Value.String()
+}
+
 type CallExpression struct {
 	Token     token.Token
 	Function  Expression
 	Arguments []Expression
+	RParen    token.Token
+	Tail      bool // the call is the last thing its function does
 }
 
 func (ce *CallExpression) expressionNode()      {}
 func (ce *CallExpression) TokenLiteral() string { return ce.Token.Literal }
+func (ce *CallExpression) Pos() token.Position  { return ce.Function.Pos() }
+func (ce *CallExpression) End() token.Position  { return ce.RParen.End }
 func (ce *CallExpression) String() string {
 	var out string
 
@@ -234,6 +483,46 @@ func (ce *CallExpression) String() string {
 	return out
 }
 
+// TryExpression is try { ... } catch (e) { ... } finally { ... }. Either
+// the catch or the finally clause may be omitted, as may the catch parameter.
+type TryExpression struct {
+	Token      token.Token
+	Block      Expression
+	CatchParam *Identifier
+	Catch      Expression
+	Finally    Expression
+	CatchSlots int // set by the resolver: the size of the scope of the catch clause
+}
+
+func (te *TryExpression) expressionNode()      {}
+func (te *TryExpression) TokenLiteral() string { return te.Token.Literal }
+func (te *TryExpression) Pos() token.Position  { return te.Token.Pos }
+func (te *TryExpression) End() token.Position {
+	switch {
+	case te.Finally != nil:
+		return te.Finally.End()
+	case te.Catch != nil:
+		return te.Catch.End()
+	case te.Block != nil:
+		return te.Block.End()
+	}
+	return te.Token.End
+}
+func (te *TryExpression) String() string {
+	out := "try " + te.Block.String()
+	if te.Catch != nil {
+		out += " catch "
+		if te.CatchParam != nil {
+			out += "(" + te.CatchParam.String() + ") "
+		}
+		out += te.Catch.String()
+	}
+	if te.Finally != nil {
+		out += " finally " + te.Finally.String()
+	}
+	return out
+}
+
 type IfExpression struct {
 	Token       token.Token
 	Condition   Expression
@@ -243,6 +532,16 @@ type IfExpression struct {
 
 func (ie *IfExpression) expressionNode()      {}
 func (ie *IfExpression) TokenLiteral() string { return ie.Token.Literal }
+func (ie *IfExpression) Pos() token.Position  { return ie.Token.Pos }
+func (ie *IfExpression) End() token.Position {
+	if ie.Alternative != nil {
+		return ie.Alternative.End()
+	}
+	if ie.Consequence != nil {
+		return ie.Consequence.End()
+	}
+	return ie.Token.End
+}
 func (ie *IfExpression) String() string {
 	var out string
 
@@ -262,27 +561,88 @@ type StringLiteral struct {
 
 func (sl *StringLiteral) expressionNode()      {}
 func (sl *StringLiteral) TokenLiteral() string { return sl.Token.Literal }
-func (sl *StringLiteral) String() string       { return "\"" + sl.Token.Literal + "\"" }
+func (sl *StringLiteral) Pos() token.Position  { return sl.Token.Pos }
+func (sl *StringLiteral) End() token.Position  { return sl.Token.End }
+func (sl *StringLiteral) String() string       { return strconv.Quote(sl.Value) }
+
+// InterpolatedString is a string such as "a ${x} b". Parts alternates between
+// string literals and interpolated expressions, starting and ending with a
+// (possibly empty) string literal.
+type InterpolatedString struct {
+	Token token.Token
+	Parts []Expression
+}
+
+func (is *InterpolatedString) expressionNode()      {}
+func (is *InterpolatedString) TokenLiteral() string { return is.Token.Literal }
+func (is *InterpolatedString) Pos() token.Position  { return is.Token.Pos }
+func (is *InterpolatedString) End() token.Position  { return is.Parts[len(is.Parts)-1].End() }
+func (is *InterpolatedString) String() string {
+	var out string
+
+	out = "\""
+	for i, part := range is.Parts {
+		if i%2 == 0 {
+			quoted := strconv.Quote(part.(*StringLiteral).Value)
+			out += strings.ReplaceAll(quoted[1:len(quoted)-1], "${", "\\${")
+		} else {
+			out += "${" + part.String() + "}"
+		}
+	}
+	out += "\""
+
+	return out
+}
 
 type IndexExpression struct {
-	Token token.Token
-	Left  Expression
-	Index Expression
+	Token    token.Token
+	Left     Expression
+	Index    Expression
+	RBracket token.Token
 }
 
 func (ie *IndexExpression) expressionNode()      {}
 func (ie *IndexExpression) TokenLiteral() string { return ie.Token.Literal }
+func (ie *IndexExpression) Pos() token.Position  { return ie.Left.Pos() }
+func (ie *IndexExpression) End() token.Position  { return ie.RBracket.End }
 func (ie *IndexExpression) String() string {
 	return ie.Left.String() + "[" + ie.Index.String() + "]"
 }
 
+// SliceExpression is xs[Low:High]; either bound may be omitted.
+type SliceExpression struct {
+	Token    token.Token
+	Left     Expression
+	Low      Expression
+	High     Expression
+	RBracket token.Token
+}
+
+func (se *SliceExpression) expressionNode()      {}
+func (se *SliceExpression) TokenLiteral() string { return se.Token.Literal }
+func (se *SliceExpression) Pos() token.Position  { return se.Left.Pos() }
+func (se *SliceExpression) End() token.Position  { return se.RBracket.End }
+func (se *SliceExpression) String() string {
+	var low, high string
+	if se.Low != nil {
+		low = se.Low.String()
+	}
+	if se.High != nil {
+		high = se.High.String()
+	}
+	return se.Left.String() + "[" + low + ":" + high + "]"
+}
+
 type HashLiteral struct {
-	Token token.Token
-	Pairs map[Expression]Expression
+	Token  token.Token
+	Pairs  map[Expression]Expression
+	RBrace token.Token
 }
 
 func (hl *HashLiteral) expressionNode()      {}
 func (hl *HashLiteral) TokenLiteral() string { return hl.Token.Literal }
+func (hl *HashLiteral) Pos() token.Position  { return hl.Token.Pos }
+func (hl *HashLiteral) End() token.Position  { return hl.RBrace.End }
 func (hl *HashLiteral) String() string {
 	var out string
 
@@ -300,10 +660,13 @@ func (hl *HashLiteral) String() string {
 type ArrayLiteral struct {
 	Token    token.Token
 	Elements []Expression
+	RBracket token.Token
 }
 
 func (al *ArrayLiteral) expressionNode()      {}
 func (al *ArrayLiteral) TokenLiteral() string { return al.Token.Literal }
+func (al *ArrayLiteral) Pos() token.Position  { return al.Token.Pos }
+func (al *ArrayLiteral) End() token.Position  { return al.RBracket.End }
 func (al *ArrayLiteral) String() string {
 	var out string
 
@@ -327,4 +690,6 @@ type NullLiteral struct {
 
 func (nl *NullLiteral) expressionNode()      {}
 func (nl *NullLiteral) TokenLiteral() string { return nl.Token.Literal }
+func (nl *NullLiteral) Pos() token.Position  { return nl.Token.Pos }
+func (nl *NullLiteral) End() token.Position  { return nl.Token.End }
 func (nl *NullLiteral) String() string       { return nl.Token.Literal }
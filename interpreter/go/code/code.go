@@ -0,0 +1,300 @@
+package code
+
+import (
+	"bytes"
+	"encoding/binary"
+	"fmt"
+	"kaze/diag"
+	"sort"
+)
+
+type Instructions []byte
+
+func (ins Instructions) String() string {
+	var out bytes.Buffer
+
+	i := 0
+	for i < len(ins) {
+		def, err := Lookup(ins[i])
+		if err != nil {
+			fmt.Fprintf(&out, "ERROR: %s\n", err)
+			i++
+			continue
+		}
+
+		operands, read := ReadOperands(def, ins[i+1:])
+		fmt.Fprintf(&out, "%04d %s\n", i, fmtInstruction(def, operands))
+		i += 1 + read
+	}
+
+	return out.String()
+}
+
+func fmtInstruction(def *Definition, operands []int) string {
+	if len(operands) != len(def.OperandWidths) {
+		return fmt.Sprintf("ERROR: operand len %d does not match defined %d\n", len(operands), len(def.OperandWidths))
+	}
+
+	out := def.Name
+	for _, operand := range operands {
+		out += fmt.Sprintf(" %d", operand)
+	}
+	return out
+}
+
+type Opcode byte
+
+const (
+	OpConstant Opcode = iota
+	OpString          // a string constant, copied because strings are mutable
+	OpNull
+	OpTrue
+	OpFalse
+	OpPop
+	OpDup2
+
+	OpAdd
+	OpSub
+	OpMul
+	OpDiv
+	OpMod
+	OpBitAnd
+	OpBitOr
+	OpBitXor
+	OpShl
+	OpShr
+	OpEqual
+	OpNotEqual
+	OpLess
+	OpGreater
+	OpLessEqual
+	OpGreaterEqual
+	OpRange
+	OpRangeInclusive
+
+	OpMinus
+	OpBang
+	OpBitNot
+
+	OpJump
+	OpJumpNotTruthy
+	OpJumpIfFalsyOrPop  // && : keep a falsy left operand, else pop it
+	OpJumpIfTruthyOrPop // || : keep a truthy left operand, else pop it
+	OpJumpIfSet         // skip the default value of a passed argument
+
+	OpGetGlobal
+	OpLookupGlobal // like OpGetGlobal, but pushes nil for an undefined name
+	OpDefineGlobal
+	OpSetGlobal
+	OpGetLocal
+	OpSetLocal
+	OpGetFree
+	OpSetFree
+
+	OpLocalRef
+	OpFreeRef
+	OpClosure
+	OpCloseUpvalues
+
+	OpCall
+	OpReturnValue
+
+	OpArray
+	OpAppend
+	OpAppendSpread
+	OpHash
+	OpIndex
+	OpIndexRef // like OpIndex, but pushes nil for a missing element
+	OpSetIndex
+	OpAssertCurrent
+	OpAssertDefined // raises a NameError if the value on the stack is nil
+	OpSlice
+	OpInterpolate
+
+	OpIterInit
+	OpIterNext
+	OpMark
+	OpUnwind
+
+	OpSetupTry
+	OpPopTry
+	OpThrow
+	OpError // raise a runtime error with a constant message
+)
+
+// Flags of OpCall.
+const (
+	CALL_TAIL   = 1 << iota // replace the calling frame
+	CALL_SPREAD             // the arguments are collected in an array
+)
+
+// Flags of OpSlice telling which bounds are on the stack.
+const (
+	SLICE_LOW = 1 << iota
+	SLICE_HIGH
+)
+
+type Definition struct {
+	Name          string
+	OperandWidths []int
+}
+
+var definitions = map[Opcode]*Definition{
+	OpConstant: {"OpConstant", []int{2}},
+	OpString:   {"OpString", []int{2}},
+	OpNull:     {"OpNull", []int{}},
+	OpTrue:     {"OpTrue", []int{}},
+	OpFalse:    {"OpFalse", []int{}},
+	OpPop:      {"OpPop", []int{}},
+	OpDup2:     {"OpDup2", []int{}},
+
+	OpAdd:            {"OpAdd", []int{}},
+	OpSub:            {"OpSub", []int{}},
+	OpMul:            {"OpMul", []int{}},
+	OpDiv:            {"OpDiv", []int{}},
+	OpMod:            {"OpMod", []int{}},
+	OpBitAnd:         {"OpBitAnd", []int{}},
+	OpBitOr:          {"OpBitOr", []int{}},
+	OpBitXor:         {"OpBitXor", []int{}},
+	OpShl:            {"OpShl", []int{}},
+	OpShr:            {"OpShr", []int{}},
+	OpEqual:          {"OpEqual", []int{}},
+	OpNotEqual:       {"OpNotEqual", []int{}},
+	OpLess:           {"OpLess", []int{}},
+	OpGreater:        {"OpGreater", []int{}},
+	OpLessEqual:      {"OpLessEqual", []int{}},
+	OpGreaterEqual:   {"OpGreaterEqual", []int{}},
+	OpRange:          {"OpRange", []int{}},
+	OpRangeInclusive: {"OpRangeInclusive", []int{}},
+
+	OpMinus:  {"OpMinus", []int{}},
+	OpBang:   {"OpBang", []int{}},
+	OpBitNot: {"OpBitNot", []int{}},
+
+	OpJump:              {"OpJump", []int{2}},
+	OpJumpNotTruthy:     {"OpJumpNotTruthy", []int{2}},
+	OpJumpIfFalsyOrPop:  {"OpJumpIfFalsyOrPop", []int{2}},
+	OpJumpIfTruthyOrPop: {"OpJumpIfTruthyOrPop", []int{2}},
+	OpJumpIfSet:         {"OpJumpIfSet", []int{2, 2}},
+
+	OpGetGlobal:    {"OpGetGlobal", []int{2}},
+	OpLookupGlobal: {"OpLookupGlobal", []int{2}},
+	OpDefineGlobal: {"OpDefineGlobal", []int{2}},
+	OpSetGlobal:    {"OpSetGlobal", []int{2}},
+	OpGetLocal:     {"OpGetLocal", []int{2}},
+	OpSetLocal:     {"OpSetLocal", []int{2}},
+	OpGetFree:      {"OpGetFree", []int{1}},
+	OpSetFree:      {"OpSetFree", []int{1}},
+
+	OpLocalRef:      {"OpLocalRef", []int{2}},
+	OpFreeRef:       {"OpFreeRef", []int{1}},
+	OpClosure:       {"OpClosure", []int{2, 1}},
+	OpCloseUpvalues: {"OpCloseUpvalues", []int{2}},
+
+	OpCall:        {"OpCall", []int{1, 1}},
+	OpReturnValue: {"OpReturnValue", []int{}},
+
+	OpArray:         {"OpArray", []int{2}},
+	OpAppend:        {"OpAppend", []int{}},
+	OpAppendSpread:  {"OpAppendSpread", []int{}},
+	OpHash:          {"OpHash", []int{2}},
+	OpIndex:         {"OpIndex", []int{}},
+	OpIndexRef:      {"OpIndexRef", []int{}},
+	OpSetIndex:      {"OpSetIndex", []int{}},
+	OpAssertCurrent: {"OpAssertCurrent", []int{2}},
+	OpAssertDefined: {"OpAssertDefined", []int{2}},
+	OpSlice:         {"OpSlice", []int{1}},
+	OpInterpolate:   {"OpInterpolate", []int{2}},
+
+	OpIterInit: {"OpIterInit", []int{1}},
+	OpIterNext: {"OpIterNext", []int{2, 2}},
+	OpMark:     {"OpMark", []int{2}},
+	OpUnwind:   {"OpUnwind", []int{2}},
+
+	OpSetupTry: {"OpSetupTry", []int{2}},
+	OpPopTry:   {"OpPopTry", []int{}},
+	OpThrow:    {"OpThrow", []int{}},
+	OpError:    {"OpError", []int{2}},
+}
+
+func Lookup(op byte) (*Definition, error) {
+	def, ok := definitions[Opcode(op)]
+	if !ok {
+		return nil, fmt.Errorf("opcode %d undefined", op)
+	}
+
+	return def, nil
+}
+
+func Make(op Opcode, operands ...int) []byte {
+	def, ok := definitions[op]
+	if !ok {
+		return []byte{}
+	}
+
+	instructionLen := 1
+	for _, w := range def.OperandWidths {
+		instructionLen += w
+	}
+
+	instruction := make([]byte, instructionLen)
+	instruction[0] = byte(op)
+
+	offset := 1
+	for i, o := range operands {
+		width := def.OperandWidths[i]
+		switch width {
+		case 2:
+			binary.BigEndian.PutUint16(instruction[offset:], uint16(o))
+		case 1:
+			instruction[offset] = byte(o)
+		}
+		offset += width
+	}
+
+	return instruction
+}
+
+func ReadOperands(def *Definition, ins Instructions) ([]int, int) {
+	operands := make([]int, len(def.OperandWidths))
+	offset := 0
+
+	for i, width := range def.OperandWidths {
+		switch width {
+		case 2:
+			operands[i] = int(ReadUint16(ins[offset:]))
+		case 1:
+			operands[i] = int(ReadUint8(ins[offset:]))
+		}
+		offset += width
+	}
+
+	return operands, offset
+}
+
+func ReadUint16(ins Instructions) uint16 {
+	return binary.BigEndian.Uint16(ins)
+}
+
+func ReadUint8(ins Instructions) uint8 {
+	return uint8(ins[0])
+}
+
+// SourceSpan maps the instructions from Offset up to the next SourceSpan to
+// the source they were compiled from.
+type SourceSpan struct {
+	Offset int
+	Span   diag.Span
+}
+
+// SpanAt returns the source of the instruction at offset in a function with
+// the given spans, which are sorted by offset.
+func SpanAt(spans []SourceSpan, offset int) diag.Span {
+	i := sort.Search(len(spans), func(i int) bool {
+		return spans[i].Offset > offset
+	})
+	if i == 0 {
+		return diag.Span{}
+	}
+	return spans[i-1].Span
+}
@@ -0,0 +1,121 @@
+package code
+
+import (
+	"kaze/diag"
+	"kaze/token"
+	"testing"
+)
+
+func TestMake(t *testing.T) {
+	tests := []struct {
+		op       Opcode
+		operands []int
+		expected []byte
+	}{
+		{OpConstant, []int{65534}, []byte{byte(OpConstant), 255, 254}},
+		{OpAdd, []int{}, []byte{byte(OpAdd)}},
+		{OpGetFree, []int{255}, []byte{byte(OpGetFree), 255}},
+		{OpClosure, []int{65534, 255}, []byte{byte(OpClosure), 255, 254, 255}},
+		{OpIterNext, []int{1, 513}, []byte{byte(OpIterNext), 0, 1, 2, 1}},
+	}
+
+	for _, tt := range tests {
+		instruction := Make(tt.op, tt.operands...)
+
+		if len(instruction) != len(tt.expected) {
+			t.Errorf("instruction has wrong length. want=%d, got=%d", len(tt.expected), len(instruction))
+		}
+
+		for i, b := range tt.expected {
+			if instruction[i] != tt.expected[i] {
+				t.Errorf("wrong byte at pos %d. want=%d, got=%d", i, b, instruction[i])
+			}
+		}
+	}
+}
+
+func TestInstructionsString(t *testing.T) {
+	instructions := []Instructions{
+		Make(OpAdd),
+		Make(OpGetLocal, 1),
+		Make(OpConstant, 2),
+		Make(OpConstant, 65535),
+		Make(OpClosure, 65535, 255),
+		Make(OpCall, 2, CALL_TAIL),
+	}
+
+	expected := `0000 OpAdd
+0001 OpGetLocal 1
+0004 OpConstant 2
+0007 OpConstant 65535
+0010 OpClosure 65535 255
+0014 OpCall 2 1
+`
+
+	concatted := Instructions{}
+	for _, ins := range instructions {
+		concatted = append(concatted, ins...)
+	}
+
+	if concatted.String() != expected {
+		t.Errorf("instructions wrongly formatted.\nwant=%q\ngot=%q", expected, concatted.String())
+	}
+}
+
+func TestReadOperands(t *testing.T) {
+	tests := []struct {
+		op        Opcode
+		operands  []int
+		bytesRead int
+	}{
+		{OpConstant, []int{65535}, 2},
+		{OpGetFree, []int{255}, 1},
+		{OpClosure, []int{65535, 255}, 3},
+		{OpJumpIfSet, []int{3, 40}, 4},
+	}
+
+	for _, tt := range tests {
+		instruction := Make(tt.op, tt.operands...)
+
+		def, err := Lookup(byte(tt.op))
+		if err != nil {
+			t.Fatalf("definition not found: %q\n", err)
+		}
+
+		operandsRead, n := ReadOperands(def, instruction[1:])
+		if n != tt.bytesRead {
+			t.Fatalf("n wrong. want=%d, got=%d", tt.bytesRead, n)
+		}
+
+		for i, want := range tt.operands {
+			if operandsRead[i] != want {
+				t.Errorf("operand wrong. want=%d, got=%d", want, operandsRead[i])
+			}
+		}
+	}
+}
+
+func TestSpanAt(t *testing.T) {
+	span := func(line int) diag.Span {
+		return diag.Span{Start: token.Position{Line: line, Column: 1}, End: token.Position{Line: line, Column: 2}}
+	}
+	spans := []SourceSpan{{0, span(1)}, {4, span(2)}, {9, span(3)}}
+
+	tests := []struct {
+		offset   int
+		expected diag.Span
+	}{
+		{0, span(1)},
+		{3, span(1)},
+		{4, span(2)},
+		{8, span(2)},
+		{20, span(3)},
+		{-1, diag.Span{}},
+	}
+
+	for _, tt := range tests {
+		if got := SpanAt(spans, tt.offset); got != tt.expected {
+			t.Errorf("SpanAt(%d) wrong. want=%v, got=%v", tt.offset, tt.expected, got)
+		}
+	}
+}
@@ -0,0 +1,948 @@
+package compiler
+
+import (
+	"fmt"
+	"kaze/ast"
+	"kaze/code"
+	"kaze/diag"
+	"kaze/object"
+	"sort"
+	"strings"
+)
+
+type Bytecode struct {
+	Instructions code.Instructions
+	Spans        []code.SourceSpan
+	NumLocals    int
+	Constants    []object.Object
+}
+
+type Compiler struct {
+	constants []object.Object
+	strings   map[string]int // the constant index of each string
+
+	symbolTable *SymbolTable
+
+	scopes     []CompilationScope
+	scopeIndex int
+
+	span diag.Span // the source of the instructions being emitted
+}
+
+// CompilationScope holds the instructions of the function being compiled.
+type CompilationScope struct {
+	instructions code.Instructions
+	spans        []code.SourceSpan
+	jumps        []*jumpContext
+}
+
+// jumpContext is an enclosing loop or try expression, which break, continue
+// and return have to leave properly.
+type jumpContext struct {
+	loop      bool
+	mark      int   // the slot holding the stack pointer at the start of the loop
+	firstSlot int   // the first slot of the locals of the loop body
+	start     int   // the target of continue
+	breaks    []int // the jumps of break, to be patched with the end of the loop
+
+	handler bool           // the try block or catch block is guarded by a handler
+	finally ast.Expression // nil if the try expression has no finally block
+}
+
+var infixOperators = map[string]code.Opcode{
+	"+":   code.OpAdd,
+	"-":   code.OpSub,
+	"*":   code.OpMul,
+	"/":   code.OpDiv,
+	"%":   code.OpMod,
+	"&":   code.OpBitAnd,
+	"|":   code.OpBitOr,
+	"^":   code.OpBitXor,
+	"<<":  code.OpShl,
+	">>":  code.OpShr,
+	"==":  code.OpEqual,
+	"!=":  code.OpNotEqual,
+	"<":   code.OpLess,
+	">":   code.OpGreater,
+	"<=":  code.OpLessEqual,
+	">=":  code.OpGreaterEqual,
+	"..":  code.OpRange,
+	"..=": code.OpRangeInclusive,
+}
+
+var prefixOperators = map[string]code.Opcode{
+	"-": code.OpMinus,
+	"!": code.OpBang,
+	"~": code.OpBitNot,
+}
+
+func New() *Compiler {
+	return &Compiler{
+		strings:     make(map[string]int),
+		symbolTable: NewSymbolTable(),
+		scopes:      []CompilationScope{{}},
+	}
+}
+
+// Compile emits the bytecode for node. Errors are returned as
+// *diag.Diagnostic.
+func (c *Compiler) Compile(node ast.Node) error {
+	defer c.at(node)()
+
+	switch node := node.(type) {
+	case *ast.Program:
+		if err := c.compileStatements(node.Statements); err != nil {
+			return err
+		}
+		c.emit(code.OpReturnValue)
+	case ast.Statement:
+		return c.compileStatement(node, true)
+	case *ast.IntegerLiteral:
+		if node.Big != nil {
+			c.emit(code.OpConstant, c.addConstant(&object.BigInt{Value: node.Big}))
+		} else {
+			c.emit(code.OpConstant, c.addConstant(&object.Integer{Value: node.Value}))
+		}
+	case *ast.FloatLiteral:
+		c.emit(code.OpConstant, c.addConstant(&object.Float{Value: node.Value}))
+	case *ast.Boolean:
+		if node.Value {
+			c.emit(code.OpTrue)
+		} else {
+			c.emit(code.OpFalse)
+		}
+	case *ast.NullLiteral:
+		c.emit(code.OpNull)
+	case *ast.StringLiteral:
+		c.emit(code.OpString, c.addString(node.Value))
+	case *ast.InterpolatedString:
+		for _, part := range node.Parts {
+			if err := c.Compile(part); err != nil {
+				return err
+			}
+		}
+		c.emit(code.OpInterpolate, len(node.Parts))
+	case *ast.Identifier:
+		c.loadSymbol(c.symbolTable.Resolve(node.Value))
+	case *ast.PrefixExpression:
+		op, ok := prefixOperators[node.Operator]
+		if !ok {
+			return fmt.Errorf("unknown operator %s", node.Operator)
+		}
+		if err := c.Compile(node.Right); err != nil {
+			return err
+		}
+		c.emit(op)
+	case *ast.InfixExpression:
+		return c.compileInfixExpression(node)
+	case *ast.AssignExpression:
+		return c.compileAssignExpression(node)
+	case *ast.BlockExpression:
+		c.enterBlock()
+		for _, stmt := range node.Statements {
+			switch stmt := stmt.(type) {
+			case *ast.VarStatement:
+				c.symbolTable.Hoist(stmt.Name.Value)
+			case *ast.FunctionDefinitionStatement:
+				c.symbolTable.Hoist(stmt.Name.Value)
+			}
+		}
+		err := c.compileStatements(node.Statements)
+		c.leaveBlock()
+		return err
+	case *ast.IfExpression:
+		return c.compileIfExpression(node)
+	case *ast.CallExpression:
+		return c.compileCallExpression(node)
+	case *ast.FunctionLiteral:
+		return c.compileFunction("", node.Parameters, node.Body)
+	case *ast.TryExpression:
+		return c.compileTryExpression(node)
+	case *ast.IndexExpression:
+		if err := c.Compile(node.Left); err != nil {
+			return err
+		}
+		if err := c.Compile(node.Index); err != nil {
+			return err
+		}
+		c.emit(code.OpIndex)
+	case *ast.SliceExpression:
+		return c.compileSliceExpression(node)
+	case *ast.ArrayLiteral:
+		return c.compileElements(node.Elements, false)
+	case *ast.HashLiteral:
+		return c.compileHashLiteral(node)
+	case *ast.SpreadExpression:
+		c.emit(code.OpError, c.addString("spread is only allowed in calls and array literals"))
+	default:
+		return fmt.Errorf("cannot compile %T", node)
+	}
+
+	return nil
+}
+
+// compileStatements leaves the value of the last statement on the stack,
+// or null if there are none.
+func (c *Compiler) compileStatements(stmts []ast.Statement) error {
+	if len(stmts) == 0 {
+		c.emit(code.OpNull)
+		return nil
+	}
+
+	for i, stmt := range stmts {
+		if err := c.compileStatement(stmt, i == len(stmts)-1); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// compileStatement compiles stmt, leaving its value on the stack if keep is
+// set. Statements other than expressions have the value null.
+func (c *Compiler) compileStatement(stmt ast.Statement, keep bool) error {
+	defer c.at(stmt)()
+
+	switch stmt := stmt.(type) {
+	case *ast.ExpressionStatement:
+		if stmt.Expression == nil {
+			c.emit(code.OpNull)
+		} else if err := c.Compile(stmt.Expression); err != nil {
+			return err
+		}
+		if !keep {
+			c.emit(code.OpPop)
+		}
+		return nil
+	case *ast.VarStatement:
+		if err := c.Compile(stmt.Value); err != nil {
+			return err
+		}
+		c.defineSymbol(c.symbolTable.Define(stmt.Name.Value))
+	case *ast.FunctionDefinitionStatement:
+		// The name is defined first so the function can call itself.
+		symbol := c.symbolTable.Define(stmt.Name.Value)
+		if err := c.compileFunction(stmt.Name.Value, stmt.Parameters, stmt.Body); err != nil {
+			return err
+		}
+		c.defineSymbol(symbol)
+	case *ast.ReturnStatement:
+		return c.compileReturnStatement(stmt)
+	case *ast.ThrowStatement:
+		if err := c.Compile(stmt.Value); err != nil {
+			return err
+		}
+		c.emit(code.OpThrow)
+		return nil
+	case *ast.BreakStatement:
+		return c.compileJump(stmt, true)
+	case *ast.ContinueStatement:
+		return c.compileJump(stmt, false)
+	case *ast.WhileStatement:
+		if err := c.compileWhileStatement(stmt); err != nil {
+			return err
+		}
+	case *ast.ForStatement:
+		if err := c.compileForStatement(stmt); err != nil {
+			return err
+		}
+	default:
+		return fmt.Errorf("cannot compile %T", stmt)
+	}
+
+	if keep {
+		c.emit(code.OpNull)
+	}
+	return nil
+}
+
+func (c *Compiler) compileInfixExpression(node *ast.InfixExpression) error {
+	if node.Operator == "&&" || node.Operator == "||" {
+		if err := c.Compile(node.Left); err != nil {
+			return err
+		}
+		op := code.OpJumpIfFalsyOrPop
+		if node.Operator == "||" {
+			op = code.OpJumpIfTruthyOrPop
+		}
+		jump := c.emit(op, 9999)
+		if err := c.Compile(node.Right); err != nil {
+			return err
+		}
+		c.changeOperands(jump, c.position())
+		return nil
+	}
+
+	op, ok := infixOperators[node.Operator]
+	if !ok {
+		return fmt.Errorf("unknown operator %s", node.Operator)
+	}
+	if err := c.Compile(node.Left); err != nil {
+		return err
+	}
+	if err := c.Compile(node.Right); err != nil {
+		return err
+	}
+	c.emit(op)
+	return nil
+}
+
+// compileAssignExpression evaluates the target before the value, and an
+// index like h[f()] only once, as the evaluator does.
+func (c *Compiler) compileAssignExpression(node *ast.AssignExpression) error {
+	var op code.Opcode
+	if node.Operator != "=" {
+		operator := strings.TrimSuffix(node.Operator, "=")
+		if node.Value == nil {
+			// x++ and x--
+			operator = operator[:1]
+		}
+		var ok bool
+		op, ok = infixOperators[operator]
+		if !ok {
+			return fmt.Errorf("unknown operator %s", node.Operator)
+		}
+	}
+	noCurrent := fmt.Sprintf("cannot apply %s to %s: no current value", node.Operator, node.Left.String())
+
+	switch left := node.Left.(type) {
+	case *ast.Identifier:
+		symbol := c.symbolTable.Resolve(left.Value)
+		if node.Operator != "=" {
+			if symbol.Scope == GlobalScope {
+				c.emit(code.OpLookupGlobal, c.addString(symbol.Name))
+				c.emit(code.OpAssertCurrent, c.addString(noCurrent))
+			} else if symbol.Hoisted {
+				c.emit(code.OpGetFree, symbol.Index)
+				c.emit(code.OpAssertCurrent, c.addString(noCurrent))
+			} else {
+				c.loadSymbol(symbol)
+			}
+		}
+		if err := c.compileAssignedValue(node); err != nil {
+			return err
+		}
+		if node.Operator != "=" {
+			c.emit(op)
+		}
+		c.storeSymbol(symbol)
+	case *ast.IndexExpression:
+		if err := c.compileContainer(left.Left); err != nil {
+			return err
+		}
+		if err := c.Compile(left.Index); err != nil {
+			return err
+		}
+		if node.Operator != "=" {
+			c.emit(code.OpDup2)
+			c.emit(code.OpIndexRef)
+			c.emit(code.OpAssertCurrent, c.addString(noCurrent))
+		}
+		if err := c.compileAssignedValue(node); err != nil {
+			return err
+		}
+		if node.Operator != "=" {
+			c.emit(op)
+		}
+		c.emit(code.OpSetIndex)
+	default:
+		c.emit(code.OpError, c.addString("not a lvalue: "+node.Left.String()))
+	}
+	return nil
+}
+
+// compileAssignedValue pushes the right side of an assignment, which is 1
+// for x++ and x--.
+func (c *Compiler) compileAssignedValue(node *ast.AssignExpression) error {
+	if node.Value == nil {
+		c.emit(code.OpConstant, c.addConstant(&object.Integer{Value: 1}))
+		return nil
+	}
+	return c.Compile(node.Value)
+}
+
+// compileContainer pushes the value being indexed by an assignment, or nil
+// if it doesn't exist.
+func (c *Compiler) compileContainer(node ast.Expression) error {
+	switch node := node.(type) {
+	case *ast.Identifier:
+		symbol := c.symbolTable.Resolve(node.Value)
+		if symbol.Scope == GlobalScope {
+			c.emit(code.OpLookupGlobal, c.addString(symbol.Name))
+		} else if symbol.Hoisted {
+			c.emit(code.OpGetFree, symbol.Index)
+		} else {
+			c.loadSymbol(symbol)
+		}
+	case *ast.IndexExpression:
+		if err := c.compileContainer(node.Left); err != nil {
+			return err
+		}
+		if err := c.Compile(node.Index); err != nil {
+			return err
+		}
+		c.emit(code.OpIndexRef)
+	default:
+		c.emit(code.OpError, c.addString("not a lvalue: "+node.String()))
+	}
+	return nil
+}
+
+func (c *Compiler) compileIfExpression(node *ast.IfExpression) error {
+	if err := c.Compile(node.Condition); err != nil {
+		return err
+	}
+	jumpNotTruthy := c.emit(code.OpJumpNotTruthy, 9999)
+
+	if err := c.Compile(node.Consequence); err != nil {
+		return err
+	}
+	jump := c.emit(code.OpJump, 9999)
+
+	c.changeOperands(jumpNotTruthy, c.position())
+	if node.Alternative == nil {
+		c.emit(code.OpNull)
+	} else if err := c.Compile(node.Alternative); err != nil {
+		return err
+	}
+	c.changeOperands(jump, c.position())
+
+	return nil
+}
+
+func (c *Compiler) compileCallExpression(node *ast.CallExpression) error {
+	if err := c.Compile(node.Function); err != nil {
+		return err
+	}
+
+	flags := 0
+	if node.Tail {
+		flags |= code.CALL_TAIL
+	}
+
+	// Arguments that don't fit in the operand are passed like spread ones.
+	if hasSpread(node.Arguments) || len(node.Arguments) > 255 {
+		if err := c.compileElements(node.Arguments, true); err != nil {
+			return err
+		}
+		c.emit(code.OpCall, 0, flags|code.CALL_SPREAD)
+		return nil
+	}
+
+	for _, arg := range node.Arguments {
+		if err := c.Compile(arg); err != nil {
+			return err
+		}
+	}
+	c.emit(code.OpCall, len(node.Arguments), flags)
+	return nil
+}
+
+// compileElements pushes an array of the values of elements, expanding
+// spread ones. With forceAppend, the array is built element by element.
+func (c *Compiler) compileElements(elements []ast.Expression, forceAppend bool) error {
+	if !forceAppend && !hasSpread(elements) && len(elements) <= 0xffff {
+		for _, element := range elements {
+			if err := c.Compile(element); err != nil {
+				return err
+			}
+		}
+		c.emit(code.OpArray, len(elements))
+		return nil
+	}
+
+	c.emit(code.OpArray, 0)
+	for _, element := range elements {
+		if spread, ok := element.(*ast.SpreadExpression); ok {
+			restore := c.at(spread)
+			err := c.Compile(spread.Value)
+			c.emit(code.OpAppendSpread)
+			restore()
+			if err != nil {
+				return err
+			}
+			continue
+		}
+
+		if err := c.Compile(element); err != nil {
+			return err
+		}
+		c.emit(code.OpAppend)
+	}
+	return nil
+}
+
+func hasSpread(elements []ast.Expression) bool {
+	for _, element := range elements {
+		if _, ok := element.(*ast.SpreadExpression); ok {
+			return true
+		}
+	}
+	return false
+}
+
+func (c *Compiler) compileSliceExpression(node *ast.SliceExpression) error {
+	if err := c.Compile(node.Left); err != nil {
+		return err
+	}
+
+	flags := 0
+	if node.Low != nil {
+		flags |= code.SLICE_LOW
+		if err := c.Compile(node.Low); err != nil {
+			return err
+		}
+	}
+	if node.High != nil {
+		flags |= code.SLICE_HIGH
+		if err := c.Compile(node.High); err != nil {
+			return err
+		}
+	}
+	c.emit(code.OpSlice, flags)
+	return nil
+}
+
+func (c *Compiler) compileHashLiteral(node *ast.HashLiteral) error {
+	keys := make([]ast.Expression, 0, len(node.Pairs))
+	for key := range node.Pairs {
+		keys = append(keys, key)
+	}
+	// Map order is random, so compile the pairs in source order.
+	sort.Slice(keys, func(i, j int) bool {
+		return keys[i].Pos().Offset < keys[j].Pos().Offset
+	})
+
+	for _, key := range keys {
+		if err := c.Compile(key); err != nil {
+			return err
+		}
+		if err := c.Compile(node.Pairs[key]); err != nil {
+			return err
+		}
+	}
+	c.emit(code.OpHash, len(keys))
+	return nil
+}
+
+// compileFunction pushes a closure of a function. Parameters get the first
+// local slots; the VM fills in the passed arguments and a default value is
+// computed only when its slot is still empty.
+func (c *Compiler) compileFunction(name string, params []*ast.Parameter, body ast.Expression) error {
+	c.enterScope()
+	table := c.symbolTable
+	table.ReserveSlots(len(params))
+
+	fn := &object.CompiledFunction{Name: name, Parameters: params, Body: body}
+	for i, param := range params {
+		switch {
+		case param.Rest:
+			fn.Rest = true
+		case param.Default != nil:
+			restore := c.at(param)
+			jump := c.emit(code.OpJumpIfSet, i, 9999)
+			err := c.Compile(param.Default)
+			c.emit(code.OpSetLocal, i)
+			c.emit(code.OpPop)
+			c.changeOperands(jump, i, c.position())
+			restore()
+			if err != nil {
+				c.leaveScope()
+				return err
+			}
+		default:
+			fn.MinArguments = i + 1
+		}
+		table.DefineSlot(param.Name.Value, i)
+	}
+
+	if err := c.Compile(body); err != nil {
+		c.leaveScope()
+		return err
+	}
+	c.emit(code.OpReturnValue)
+
+	free := table.FreeSymbols
+	fn.NumLocals = table.NumSlots()
+	fn.Instructions, fn.Spans = c.leaveScope()
+
+	if len(free) > 255 {
+		return diag.Errorf(diag.TOO_MANY_CAPTURES, c.span, "too many captured variables in function")
+	}
+	for _, symbol := range free {
+		if symbol.Scope == LocalScope {
+			c.emit(code.OpLocalRef, symbol.Index)
+		} else {
+			c.emit(code.OpFreeRef, symbol.Index)
+		}
+	}
+	c.emit(code.OpClosure, c.addConstant(fn), len(free))
+	return nil
+}
+
+func (c *Compiler) compileReturnStatement(stmt *ast.ReturnStatement) error {
+	if stmt.ReturnValue == nil {
+		c.emit(code.OpNull)
+	} else if err := c.Compile(stmt.ReturnValue); err != nil {
+		return err
+	}
+
+	jumps := c.currentScope().jumps
+	for i := len(jumps) - 1; i >= 0; i-- {
+		if !jumps[i].loop {
+			if err := c.leaveTry(jumps[i], jumps[:i]); err != nil {
+				return err
+			}
+		}
+	}
+	c.emit(code.OpReturnValue)
+	return nil
+}
+
+// compileJump compiles break or continue: it leaves the try expressions
+// inside the innermost loop, drops what the loop body left on the stack and
+// closes the variables of the body.
+func (c *Compiler) compileJump(stmt ast.Statement, isBreak bool) error {
+	jumps := c.currentScope().jumps
+	i := len(jumps) - 1
+	for i >= 0 && !jumps[i].loop {
+		i--
+	}
+	if i < 0 {
+		return diag.Errorf(diag.JUMP_OUTSIDE_LOOP, c.span, "%s outside loop", stmt.TokenLiteral())
+	}
+
+	for j := len(jumps) - 1; j > i; j-- {
+		if err := c.leaveTry(jumps[j], jumps[:j]); err != nil {
+			return err
+		}
+	}
+
+	loop := jumps[i]
+	c.emit(code.OpUnwind, loop.mark)
+	c.emit(code.OpCloseUpvalues, loop.firstSlot)
+	if isBreak {
+		loop.breaks = append(loop.breaks, c.emit(code.OpJump, 9999))
+	} else {
+		c.emit(code.OpJump, loop.start)
+	}
+	return nil
+}
+
+// leaveTry emits what a jump out of a try expression has to do first:
+// remove its handler and run its finally block. The finally block can
+// itself only jump to the contexts outside.
+func (c *Compiler) leaveTry(try *jumpContext, outer []*jumpContext) error {
+	if try.handler {
+		c.emit(code.OpPopTry)
+	}
+	if try.finally == nil {
+		return nil
+	}
+
+	scope := c.currentScope()
+	saved := scope.jumps
+	scope.jumps = append([]*jumpContext(nil), outer...)
+	err := c.Compile(try.finally)
+	c.emit(code.OpPop)
+	scope.jumps = saved
+	return err
+}
+
+func (c *Compiler) compileWhileStatement(stmt *ast.WhileStatement) error {
+	c.enterBlock()
+	defer c.leaveBlock()
+
+	mark := c.symbolTable.AllocateSlot()
+	c.emit(code.OpMark, mark)
+
+	loop := &jumpContext{loop: true, mark: mark, firstSlot: c.symbolTable.NextSlot(), start: c.position()}
+	if err := c.Compile(stmt.Condition); err != nil {
+		return err
+	}
+	exit := c.emit(code.OpJumpNotTruthy, 9999)
+
+	c.pushJump(loop)
+	err := c.Compile(stmt.Body)
+	c.popJump()
+	if err != nil {
+		return err
+	}
+	c.emit(code.OpPop)
+	c.emit(code.OpJump, loop.start)
+
+	c.changeOperands(exit, c.position())
+	for _, jump := range loop.breaks {
+		c.changeOperands(jump, c.position())
+	}
+	return nil
+}
+
+func (c *Compiler) compileForStatement(stmt *ast.ForStatement) error {
+	if err := c.Compile(stmt.Iterable); err != nil {
+		return err
+	}
+
+	c.enterBlock()
+	defer c.leaveBlock()
+
+	pairs := 0
+	if len(stmt.Variables) == 2 {
+		pairs = 1
+	}
+	iterator := c.symbolTable.AllocateSlot()
+	mark := c.symbolTable.AllocateSlot()
+	c.emit(code.OpIterInit, pairs)
+	c.emit(code.OpSetLocal, iterator)
+	c.emit(code.OpPop)
+	c.emit(code.OpMark, mark)
+
+	start := c.position()
+	exit := c.emit(code.OpIterNext, iterator, 9999)
+
+	// Each iteration gets its own scope so closures capture that
+	// iteration's variables.
+	c.enterBlock()
+	loop := &jumpContext{loop: true, mark: mark, firstSlot: c.symbolTable.FirstSlot(), start: start}
+	for i := len(stmt.Variables) - 1; i >= 0; i-- {
+		symbol := c.symbolTable.Define(stmt.Variables[i].Value)
+		c.emit(code.OpSetLocal, symbol.Index)
+		c.emit(code.OpPop)
+	}
+
+	c.pushJump(loop)
+	err := c.Compile(stmt.Body)
+	c.popJump()
+	if err != nil {
+		c.leaveBlock()
+		return err
+	}
+	c.emit(code.OpPop)
+	c.leaveBlock()
+	c.emit(code.OpJump, start)
+
+	c.changeOperands(exit, iterator, c.position())
+	for _, jump := range loop.breaks {
+		c.changeOperands(jump, c.position())
+	}
+	return nil
+}
+
+// compileTryExpression lays out a try expression as
+//
+//	OpSetupTry catch
+//	<try block>
+//	OpPopTry
+//	OpJump end
+//	catch:          ; the exception is on the stack
+//	<catch block>   ; guarded by a handler rethrowing after the finally block
+//	OpJump end
+//	rethrow:
+//	<finally block>
+//	OpThrow
+//	end:
+//	<finally block>
+//
+// break, continue and return run the finally block on their way out.
+func (c *Compiler) compileTryExpression(node *ast.TryExpression) error {
+	firstSlot := c.symbolTable.NextSlot()
+	setup := c.emit(code.OpSetupTry, 9999)
+
+	c.pushJump(&jumpContext{handler: true, finally: node.Finally})
+	err := c.Compile(node.Block)
+	c.popJump()
+	if err != nil {
+		return err
+	}
+	c.emit(code.OpPopTry)
+	ends := []int{c.emit(code.OpJump, 9999)}
+
+	c.changeOperands(setup, c.position())
+	c.emit(code.OpCloseUpvalues, firstSlot)
+
+	if node.Catch != nil {
+		rethrow := -1
+		if node.Finally != nil {
+			rethrow = c.emit(code.OpSetupTry, 9999)
+			c.pushJump(&jumpContext{handler: true, finally: node.Finally})
+		}
+
+		c.enterBlock()
+		if node.CatchParam != nil {
+			symbol := c.symbolTable.Define(node.CatchParam.Value)
+			c.emit(code.OpSetLocal, symbol.Index)
+		}
+		c.emit(code.OpPop)
+		err := c.Compile(node.Catch)
+		c.leaveBlock()
+		if err != nil {
+			return err
+		}
+
+		if node.Finally != nil {
+			c.popJump()
+			c.emit(code.OpPopTry)
+			ends = append(ends, c.emit(code.OpJump, 9999))
+			c.changeOperands(rethrow, c.position())
+			c.emit(code.OpCloseUpvalues, firstSlot)
+		}
+	}
+
+	if node.Finally != nil {
+		if err := c.Compile(node.Finally); err != nil {
+			return err
+		}
+		c.emit(code.OpPop)
+		c.emit(code.OpThrow)
+	}
+
+	for _, end := range ends {
+		c.changeOperands(end, c.position())
+	}
+	if node.Finally != nil {
+		if err := c.Compile(node.Finally); err != nil {
+			return err
+		}
+		c.emit(code.OpPop)
+	}
+	return nil
+}
+
+// defineSymbol pops the value on the stack into a newly declared symbol.
+func (c *Compiler) defineSymbol(symbol Symbol) {
+	if symbol.Scope == GlobalScope {
+		c.emit(code.OpDefineGlobal, c.addString(symbol.Name))
+		return
+	}
+	c.emit(code.OpSetLocal, symbol.Index)
+	c.emit(code.OpPop)
+}
+
+func (c *Compiler) loadSymbol(symbol Symbol) {
+	switch symbol.Scope {
+	case GlobalScope:
+		c.emit(code.OpGetGlobal, c.addString(symbol.Name))
+	case LocalScope:
+		c.emit(code.OpGetLocal, symbol.Index)
+	case FreeScope:
+		c.emit(code.OpGetFree, symbol.Index)
+		if symbol.Hoisted {
+			c.emit(code.OpAssertDefined, c.addString(symbol.Name))
+		}
+	}
+}
+
+// storeSymbol assigns the value on the stack to symbol, leaving it there.
+func (c *Compiler) storeSymbol(symbol Symbol) {
+	switch symbol.Scope {
+	case GlobalScope:
+		c.emit(code.OpSetGlobal, c.addString(symbol.Name))
+	case LocalScope:
+		c.emit(code.OpSetLocal, symbol.Index)
+	case FreeScope:
+		c.emit(code.OpSetFree, symbol.Index)
+	}
+}
+
+func (c *Compiler) addConstant(obj object.Object) int {
+	c.constants = append(c.constants, obj)
+	return len(c.constants) - 1
+}
+
+func (c *Compiler) addString(s string) int {
+	if index, ok := c.strings[s]; ok {
+		return index
+	}
+	index := c.addConstant(&object.String{Value: s})
+	c.strings[s] = index
+	return index
+}
+
+func (c *Compiler) emit(op code.Opcode, operands ...int) int {
+	scope := c.currentScope()
+	pos := len(scope.instructions)
+	scope.instructions = append(scope.instructions, code.Make(op, operands...)...)
+
+	if n := len(scope.spans); n == 0 || scope.spans[n-1].Span != c.span {
+		scope.spans = append(scope.spans, code.SourceSpan{Offset: pos, Span: c.span})
+	}
+	return pos
+}
+
+// changeOperands replaces the operands of the instruction at pos.
+func (c *Compiler) changeOperands(pos int, operands ...int) {
+	ins := c.currentScope().instructions
+	copy(ins[pos:], code.Make(code.Opcode(ins[pos]), operands...))
+}
+
+func (c *Compiler) position() int {
+	return len(c.currentScope().instructions)
+}
+
+// at makes node the source of the instructions emitted until the returned
+// function is called.
+func (c *Compiler) at(node ast.Node) func() {
+	saved := c.span
+	c.span = diag.Span{Start: node.Pos(), End: node.End()}
+	return func() { c.span = saved }
+}
+
+func (c *Compiler) currentScope() *CompilationScope {
+	return &c.scopes[c.scopeIndex]
+}
+
+func (c *Compiler) enterScope() {
+	c.scopes = append(c.scopes[:c.scopeIndex+1], CompilationScope{})
+	c.scopeIndex++
+	c.symbolTable = NewFunctionSymbolTable(c.symbolTable)
+}
+
+func (c *Compiler) leaveScope() (code.Instructions, []code.SourceSpan) {
+	scope := c.currentScope()
+	c.scopes = c.scopes[:c.scopeIndex]
+	c.scopeIndex--
+	c.symbolTable = c.symbolTable.function.Outer
+	return scope.instructions, scope.spans
+}
+
+func (c *Compiler) enterBlock() {
+	c.symbolTable = NewBlockSymbolTable(c.symbolTable)
+}
+
+// leaveBlock closes the variables of the block that closures captured, so
+// its slots can be reused.
+func (c *Compiler) leaveBlock() {
+	table := c.symbolTable
+	if table.Captured() {
+		c.emit(code.OpCloseUpvalues, table.FirstSlot())
+	}
+	table.Leave()
+	c.symbolTable = table.Outer
+}
+
+func (c *Compiler) pushJump(jump *jumpContext) {
+	scope := c.currentScope()
+	scope.jumps = append(scope.jumps, jump)
+}
+
+func (c *Compiler) popJump() {
+	scope := c.currentScope()
+	scope.jumps = scope.jumps[:len(scope.jumps)-1]
+}
+
+func (c *Compiler) Bytecode() *Bytecode {
+	// A function may outlive the program, like one stored in a global of a
+	// REPL session, so it keeps its own reference to the constants.
+	for _, constant := range c.constants {
+		if fn, ok := constant.(*object.CompiledFunction); ok {
+			fn.Constants = c.constants
+		}
+	}
+
+	scope := c.currentScope()
+	return &Bytecode{
+		Instructions: scope.instructions,
+		Spans:        scope.spans,
+		NumLocals:    c.symbolTable.NumSlots(),
+		Constants:    c.constants,
+	}
+}
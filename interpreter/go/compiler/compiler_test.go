@@ -0,0 +1,284 @@
+package compiler
+
+import (
+	"kaze/ast"
+	"kaze/code"
+	"kaze/diag"
+	"kaze/lexer"
+	"kaze/object"
+	"kaze/parser"
+	"testing"
+)
+
+func parse(input string) *ast.Program {
+	l := lexer.New(input)
+	p := parser.New(l)
+	return p.ParseProgram()
+}
+
+func concatInstructions(s []code.Instructions) code.Instructions {
+	out := code.Instructions{}
+	for _, ins := range s {
+		out = append(out, ins...)
+	}
+	return out
+}
+
+func TestSymbolTable(t *testing.T) {
+	global := NewSymbolTable()
+	if symbol := global.Define("a"); symbol.Scope != GlobalScope {
+		t.Errorf("top-level name is not global. got=%s", symbol.Scope)
+	}
+
+	fn := NewFunctionSymbolTable(global)
+	fn.ReserveSlots(1)
+	fn.DefineSlot("p", 0)
+
+	block := NewBlockSymbolTable(fn)
+	b := block.Define("b")
+	if b.Scope != LocalScope || b.Index != 1 {
+		t.Errorf("wrong symbol for b. got=%+v", b)
+	}
+	if again := block.Define("b"); again.Index != b.Index {
+		t.Errorf("redeclaration got a new slot. got=%d, want=%d", again.Index, b.Index)
+	}
+
+	inner := NewBlockSymbolTable(block)
+	if c := inner.Define("c"); c.Index != 2 {
+		t.Errorf("wrong slot for c. got=%d, want=2", c.Index)
+	}
+	inner.Leave()
+
+	sibling := NewBlockSymbolTable(block)
+	if d := sibling.Define("d"); d.Index != 2 {
+		t.Errorf("slot of a finished block wasn't reused. got=%d, want=2", d.Index)
+	}
+	if fn.NumSlots() != 3 {
+		t.Errorf("wrong number of slots. got=%d, want=3", fn.NumSlots())
+	}
+
+	nested := NewFunctionSymbolTable(sibling)
+	expected := []Symbol{
+		{Name: "a", Scope: GlobalScope},
+		{Name: "p", Scope: FreeScope, Index: 0},
+		{Name: "d", Scope: FreeScope, Index: 1},
+		{Name: "p", Scope: FreeScope, Index: 0},
+	}
+	for _, want := range expected {
+		got := nested.Resolve(want.Name)
+		if got.Name != want.Name || got.Scope != want.Scope || got.Index != want.Index {
+			t.Errorf("wrong symbol for %s. got=%+v, want=%+v", want.Name, got, want)
+		}
+	}
+	if len(nested.FreeSymbols) != 2 {
+		t.Errorf("wrong number of free symbols. got=%d, want=2", len(nested.FreeSymbols))
+	}
+	if !sibling.Captured() || block.Captured() {
+		t.Errorf("wrong blocks marked as captured")
+	}
+}
+
+func TestSymbolTableHoist(t *testing.T) {
+	fn := NewFunctionSymbolTable(NewSymbolTable())
+	block := NewBlockSymbolTable(fn)
+	block.Hoist("g")
+	block.Hoist("h")
+
+	// The function itself only sees h once it is declared.
+	if h := block.Resolve("h"); h.Scope != GlobalScope {
+		t.Errorf("h is visible before its declaration. got=%+v", h)
+	}
+
+	closure := NewFunctionSymbolTable(block)
+	h := closure.Resolve("h")
+	if h.Scope != FreeScope || !h.Hoisted {
+		t.Errorf("wrong symbol for h in a closure. got=%+v", h)
+	}
+	if original := closure.FreeSymbols[h.Index]; original.Index != 1 {
+		t.Errorf("wrong slot for h. got=%d, want=1", original.Index)
+	}
+
+	if declared := block.Define("h"); declared.Index != 1 || declared.Hoisted {
+		t.Errorf("declaration didn't take the hoisted slot. got=%+v", declared)
+	}
+}
+
+func TestCompile(t *testing.T) {
+	tests := []struct {
+		input        string
+		constants    []interface{}
+		instructions []code.Instructions
+	}{
+		{
+			"1 + 2",
+			[]interface{}{1, 2},
+			[]code.Instructions{
+				code.Make(code.OpConstant, 0),
+				code.Make(code.OpConstant, 1),
+				code.Make(code.OpAdd),
+				code.Make(code.OpReturnValue),
+			},
+		},
+		{
+			"var x = \"a\"; x",
+			[]interface{}{"a", "x"},
+			[]code.Instructions{
+				code.Make(code.OpString, 0),
+				code.Make(code.OpDefineGlobal, 1),
+				code.Make(code.OpGetGlobal, 1),
+				code.Make(code.OpReturnValue),
+			},
+		},
+		{
+			"{ var x = 1; x += 2 }",
+			[]interface{}{1, 2},
+			[]code.Instructions{
+				code.Make(code.OpConstant, 0),
+				code.Make(code.OpSetLocal, 0),
+				code.Make(code.OpPop),
+				code.Make(code.OpGetLocal, 0),
+				code.Make(code.OpConstant, 1),
+				code.Make(code.OpAdd),
+				code.Make(code.OpSetLocal, 0),
+				code.Make(code.OpReturnValue),
+			},
+		},
+		{
+			"if true { 1 }; 2",
+			[]interface{}{1, 2},
+			[]code.Instructions{
+				code.Make(code.OpTrue),
+				code.Make(code.OpJumpNotTruthy, 10),
+				code.Make(code.OpConstant, 0),
+				code.Make(code.OpJump, 11),
+				code.Make(code.OpNull),
+				code.Make(code.OpPop),
+				code.Make(code.OpConstant, 1),
+				code.Make(code.OpReturnValue),
+			},
+		},
+		{
+			"f(...xs, 1)",
+			[]interface{}{"f", "xs", 1},
+			[]code.Instructions{
+				code.Make(code.OpGetGlobal, 0),
+				code.Make(code.OpArray, 0),
+				code.Make(code.OpGetGlobal, 1),
+				code.Make(code.OpAppendSpread),
+				code.Make(code.OpConstant, 2),
+				code.Make(code.OpAppend),
+				code.Make(code.OpCall, 0, code.CALL_SPREAD),
+				code.Make(code.OpReturnValue),
+			},
+		},
+	}
+
+	for _, tt := range tests {
+		compiler := New()
+		if err := compiler.Compile(parse(tt.input)); err != nil {
+			t.Fatalf("%q: compiler error: %s", tt.input, err)
+		}
+		bytecode := compiler.Bytecode()
+
+		expected := concatInstructions(tt.instructions)
+		if bytecode.Instructions.String() != expected.String() {
+			t.Errorf("%q: wrong instructions.\nwant=\n%s\ngot=\n%s", tt.input, expected, bytecode.Instructions)
+		}
+		testConstants(t, tt.input, tt.constants, bytecode.Constants)
+	}
+}
+
+func testConstants(t *testing.T, input string, expected []interface{}, actual []object.Object) {
+	if len(expected) != len(actual) {
+		t.Errorf("%q: wrong number of constants. got=%d, want=%d", input, len(actual), len(expected))
+		return
+	}
+
+	for i, constant := range expected {
+		switch constant := constant.(type) {
+		case int:
+			integer, ok := actual[i].(*object.Integer)
+			if !ok || integer.Value != int64(constant) {
+				t.Errorf("%q: constant %d is not %d. got=%s", input, i, constant, actual[i].Inspect())
+			}
+		case string:
+			str, ok := actual[i].(*object.String)
+			if !ok || str.Value != constant {
+				t.Errorf("%q: constant %d is not %q. got=%s", input, i, constant, actual[i].Inspect())
+			}
+		}
+	}
+}
+
+func TestFunctions(t *testing.T) {
+	compiler := New()
+	if err := compiler.Compile(parse("fun f(a, b = a) { (x) => a + x }")); err != nil {
+		t.Fatalf("compiler error: %s", err)
+	}
+	constants := compiler.Bytecode().Constants
+
+	var inner, outer *object.CompiledFunction
+	for _, constant := range constants {
+		if fn, ok := constant.(*object.CompiledFunction); ok {
+			if fn.Name == "f" {
+				outer = fn
+			} else {
+				inner = fn
+			}
+		}
+	}
+	if inner == nil || outer == nil {
+		t.Fatalf("functions not compiled. got=%v", constants)
+	}
+
+	if outer.MinArguments != 1 || outer.Rest || outer.NumLocals != 2 {
+		t.Errorf("wrong signature of f. got=%+v", outer)
+	}
+
+	expected := concatInstructions([]code.Instructions{
+		code.Make(code.OpJumpIfSet, 1, 12),
+		code.Make(code.OpGetLocal, 0),
+		code.Make(code.OpSetLocal, 1),
+		code.Make(code.OpPop),
+		code.Make(code.OpLocalRef, 0),
+		code.Make(code.OpClosure, 0, 1),
+		code.Make(code.OpReturnValue),
+	})
+	if outer.Instructions.String() != expected.String() {
+		t.Errorf("wrong instructions of f.\nwant=\n%s\ngot=\n%s", expected, outer.Instructions)
+	}
+
+	expected = concatInstructions([]code.Instructions{
+		code.Make(code.OpGetFree, 0),
+		code.Make(code.OpGetLocal, 0),
+		code.Make(code.OpAdd),
+		code.Make(code.OpReturnValue),
+	})
+	if inner.Instructions.String() != expected.String() {
+		t.Errorf("wrong instructions of the closure.\nwant=\n%s\ngot=\n%s", expected, inner.Instructions)
+	}
+}
+
+func TestCompileErrors(t *testing.T) {
+	tests := []struct {
+		input    string
+		code     string
+		expected string
+	}{
+		{"break", diag.JUMP_OUTSIDE_LOOP, "1:1: break outside loop"},
+		{"fun f() {\n  continue;\n}", diag.JUMP_OUTSIDE_LOOP, "2:3: continue outside loop"},
+		{"while true { fun f() { break } }", diag.JUMP_OUTSIDE_LOOP, "1:24: break outside loop"},
+	}
+
+	for _, tt := range tests {
+		err := New().Compile(parse(tt.input))
+		d, ok := err.(*diag.Diagnostic)
+		if !ok {
+			t.Errorf("%q: expected a diagnostic, got=%v", tt.input, err)
+			continue
+		}
+		if d.Code != tt.code || d.Error() != tt.expected {
+			t.Errorf("%q: wrong error. want=%s %q, got=%s %q", tt.input, tt.code, tt.expected, d.Code, d.Error())
+		}
+	}
+}
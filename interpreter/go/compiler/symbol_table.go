@@ -0,0 +1,199 @@
+package compiler
+
+type SymbolScope string
+
+const (
+	GlobalScope SymbolScope = "GLOBAL"
+	LocalScope  SymbolScope = "LOCAL"
+	FreeScope   SymbolScope = "FREE"
+)
+
+type Symbol struct {
+	Name  string
+	Scope SymbolScope
+	Index int
+
+	// Hoisted is set for a variable a closure refers to before its
+	// declaration, which may not have run when the closure does.
+	Hoisted bool
+
+	table *SymbolTable // the block that declared a local
+}
+
+// SymbolTable holds the names declared in one block. The blocks of a
+// function share its local slots: a block allocates from the slots left
+// free by its enclosing blocks and gives them back when it ends. A name
+// declared in an enclosing function becomes a free variable.
+//
+// Names declared at the top level of the program are globals, which are
+// looked up by name at run time.
+//
+// Within a function, a name is visible from its declaration on. A closure
+// sees all the names of the blocks around it, even those declared after it,
+// so local functions can call each other; they get their slots when the
+// block starts.
+type SymbolTable struct {
+	Outer *SymbolTable
+
+	FreeSymbols []Symbol
+
+	store    map[string]Symbol
+	hoisted  map[string]Symbol // every name the block declares
+	function *SymbolTable // the table of the function the block belongs to
+	global   bool
+
+	firstSlot int
+	captured  bool // a closure refers to a local of the block
+
+	nextSlot int // of a function table: the first unused slot
+	numSlots int // of a function table: the number of slots it needs
+}
+
+// NewSymbolTable returns the table of the top level of a program.
+func NewSymbolTable() *SymbolTable {
+	s := &SymbolTable{store: make(map[string]Symbol), global: true}
+	s.function = s
+	return s
+}
+
+// NewFunctionSymbolTable returns the table of the parameters of a function
+// defined in outer.
+func NewFunctionSymbolTable(outer *SymbolTable) *SymbolTable {
+	s := &SymbolTable{Outer: outer, store: make(map[string]Symbol)}
+	s.function = s
+	return s
+}
+
+// NewBlockSymbolTable returns the table of a block nested in outer.
+func NewBlockSymbolTable(outer *SymbolTable) *SymbolTable {
+	return &SymbolTable{
+		Outer:     outer,
+		store:     make(map[string]Symbol),
+		function:  outer.function,
+		firstSlot: outer.function.nextSlot,
+	}
+}
+
+// Define declares name in the block. Declaring a name again in the same
+// block reuses its slot.
+func (s *SymbolTable) Define(name string) Symbol {
+	if s.global {
+		return Symbol{Name: name, Scope: GlobalScope}
+	}
+	if symbol, ok := s.store[name]; ok && symbol.Scope == LocalScope {
+		return symbol
+	}
+
+	symbol, ok := s.hoisted[name]
+	if !ok {
+		symbol = Symbol{Name: name, Scope: LocalScope, Index: s.AllocateSlot(), table: s}
+	}
+	s.store[name] = symbol
+	return symbol
+}
+
+// Hoist gives name a slot before the block declares it, so closures
+// created earlier in the block can refer to it.
+func (s *SymbolTable) Hoist(name string) {
+	if s.global {
+		return
+	}
+	if _, ok := s.hoisted[name]; ok {
+		return
+	}
+	if s.hoisted == nil {
+		s.hoisted = make(map[string]Symbol)
+	}
+	s.hoisted[name] = Symbol{Name: name, Scope: LocalScope, Index: s.AllocateSlot(), table: s}
+}
+
+// DefineSlot declares name as the local in slot, which must have been
+// reserved with ReserveSlots.
+func (s *SymbolTable) DefineSlot(name string, slot int) Symbol {
+	symbol := Symbol{Name: name, Scope: LocalScope, Index: slot, table: s}
+	s.store[name] = symbol
+	return symbol
+}
+
+// ReserveSlots allocates the first n slots of a function for its parameters.
+func (s *SymbolTable) ReserveSlots(n int) {
+	for i := 0; i < n; i++ {
+		s.AllocateSlot()
+	}
+}
+
+// AllocateSlot returns a slot for a value that has no name, like the state
+// of a loop.
+func (s *SymbolTable) AllocateSlot() int {
+	f := s.function
+	slot := f.nextSlot
+	f.nextSlot++
+	if f.nextSlot > f.numSlots {
+		f.numSlots = f.nextSlot
+	}
+	return slot
+}
+
+// Leave frees the slots of the block for reuse.
+func (s *SymbolTable) Leave() {
+	s.function.nextSlot = s.firstSlot
+}
+
+// FirstSlot returns the first slot the block may use.
+func (s *SymbolTable) FirstSlot() int {
+	return s.firstSlot
+}
+
+// NextSlot returns the slot the next local of the function would get.
+func (s *SymbolTable) NextSlot() int {
+	return s.function.nextSlot
+}
+
+// NumSlots returns the number of local slots the function needs.
+func (s *SymbolTable) NumSlots() int {
+	return s.function.numSlots
+}
+
+// Captured reports whether a closure refers to a local of the block, so
+// the block has to close its upvalues when it ends.
+func (s *SymbolTable) Captured() bool {
+	return s.captured
+}
+
+// Resolve finds the declaration name refers to. A name declared nowhere
+// is a global, which may still be defined by the time it's used.
+func (s *SymbolTable) Resolve(name string) Symbol {
+	return s.resolve(name, false)
+}
+
+// resolve looks name up from a closure if inClosure is set, so the names
+// declared later in the blocks are visible too.
+func (s *SymbolTable) resolve(name string, inClosure bool) Symbol {
+	if symbol, ok := s.store[name]; ok {
+		return symbol
+	}
+	if symbol, ok := s.hoisted[name]; ok && inClosure {
+		symbol.Hoisted = true
+		return symbol
+	}
+	if s.Outer == nil {
+		return Symbol{Name: name, Scope: GlobalScope}
+	}
+
+	symbol := s.Outer.resolve(name, inClosure || s.function == s)
+	if symbol.Scope == GlobalScope || s.function != s {
+		return symbol
+	}
+	return s.defineFree(symbol)
+}
+
+func (s *SymbolTable) defineFree(original Symbol) Symbol {
+	if original.Scope == LocalScope {
+		original.table.captured = true
+	}
+	s.FreeSymbols = append(s.FreeSymbols, original)
+
+	symbol := Symbol{Name: original.Name, Scope: FreeScope, Index: len(s.FreeSymbols) - 1, Hoisted: original.Hoisted}
+	s.store[original.Name] = symbol
+	return symbol
+}
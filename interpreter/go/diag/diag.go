@@ -0,0 +1,166 @@
+package diag
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"kaze/token"
+	"strconv"
+	"strings"
+)
+
+type Severity string
+
+const (
+	ERROR   Severity = "error"
+	WARNING Severity = "warning"
+)
+
+// Diagnostic codes. The first letter tells which phase produced the
+// diagnostic: L for the lexer, P for the parser, S for the scope resolver, C
+// for the compiler, R for the evaluator.
+const (
+	UNTERMINATED_STRING = "L0001"
+	UNEXPECTED_CHAR     = "L0002"
+	INVALID_ESCAPE      = "L0003"
+	INVALID_NUMBER      = "L0004"
+
+	UNEXPECTED_TOKEN    = "P0001"
+	NO_PREFIX_PARSE_FN  = "P0002"
+	INVALID_INTEGER     = "P0003"
+	INVALID_ASSIGN_LEFT = "P0004"
+	INVALID_FLOAT       = "P0005"
+	INVALID_PARAMETER   = "P0006"
+
+	UNDEFINED_VARIABLE = "S0001"
+
+	JUMP_OUTSIDE_LOOP = "C0001"
+	TOO_MANY_CAPTURES = "C0002"
+
+	RUNTIME_ERROR = "R0001"
+)
+
+// Span is the half-open source range [Start, End).
+type Span struct {
+	Start token.Position `json:"start"`
+	End   token.Position `json:"end"`
+}
+
+type Diagnostic struct {
+	Severity Severity `json:"severity"`
+	Code     string   `json:"code"`
+	Message  string   `json:"message"`
+	Span     Span     `json:"span"`
+	Notes    []string `json:"notes,omitempty"`
+}
+
+func Errorf(code string, span Span, format string, a ...interface{}) *Diagnostic {
+	return &Diagnostic{
+		Severity: ERROR,
+		Code:     code,
+		Message:  fmt.Sprintf(format, a...),
+		Span:     span,
+	}
+}
+
+// Error formats the diagnostic as "line:col: message".
+func (d *Diagnostic) Error() string {
+	return d.Span.Start.String() + ": " + d.Message
+}
+
+// Render formats the diagnostic for a terminal, with the offending source
+// line underlined by carets:
+//
+//	error[P0001]: expected next token to be IDENT, got = instead
+//	 --> main.kz:2:5
+//	  |
+//	2 | var = 2;
+//	  |     ^
+func (d *Diagnostic) Render(filename string, source string) string {
+	var out strings.Builder
+
+	out.WriteString(string(d.Severity))
+	if d.Code != "" {
+		out.WriteString("[" + d.Code + "]")
+	}
+	out.WriteString(": " + d.Message + "\n")
+
+	start := d.Span.Start
+	if !start.IsValid() {
+		out.WriteString(" --> " + filename + "\n")
+		d.renderNotes(&out, "")
+		return out.String()
+	}
+
+	line, ok := sourceLine(source, start.Line)
+	lineNo := strconv.Itoa(start.Line)
+	gutter := strings.Repeat(" ", len(lineNo))
+
+	out.WriteString(gutter + "--> " + filename + ":" + start.String() + "\n")
+	if ok {
+		out.WriteString(gutter + " |\n")
+		out.WriteString(lineNo + " | " + line + "\n")
+		out.WriteString(gutter + " | " + underline(line, d.Span) + "\n")
+	}
+	d.renderNotes(&out, gutter)
+
+	return out.String()
+}
+
+func (d *Diagnostic) renderNotes(out *strings.Builder, gutter string) {
+	for _, note := range d.Notes {
+		out.WriteString(gutter + " = note: " + note + "\n")
+	}
+}
+
+// sourceLine returns the text of the 1-based line n without its newline.
+func sourceLine(source string, n int) (string, bool) {
+	lines := strings.Split(source, "\n")
+	if n < 1 || n > len(lines) {
+		return "", false
+	}
+	return strings.TrimRight(lines[n-1], "\r"), true
+}
+
+// underline builds the caret line for span on line. Tabs before the span are
+// kept so the carets line up with the excerpt above them.
+func underline(line string, span Span) string {
+	var out strings.Builder
+
+	startCol := span.Start.Column
+	endCol := span.End.Column
+	if span.End.Line != span.Start.Line {
+		endCol = len(line) + 1
+	}
+	if endCol <= startCol {
+		endCol = startCol + 1
+	}
+
+	for i := 0; i < startCol-1; i++ {
+		if i < len(line) && line[i] == '\t' {
+			out.WriteByte('\t')
+		} else {
+			out.WriteByte(' ')
+		}
+	}
+	out.WriteString(strings.Repeat("^", endCol-startCol))
+
+	return out.String()
+}
+
+// Fprint renders each diagnostic in diags to w for a terminal.
+func Fprint(w io.Writer, filename string, source string, diags []*Diagnostic) {
+	for _, d := range diags {
+		io.WriteString(w, d.Render(filename, source))
+	}
+}
+
+// WriteJSON writes diags to w as a JSON array for editor integrations.
+func WriteJSON(w io.Writer, diags []*Diagnostic) error {
+	if diags == nil {
+		diags = []*Diagnostic{}
+	}
+	enc := json.NewEncoder(w)
+	enc.SetEscapeHTML(false)
+	return enc.Encode(diags)
+}
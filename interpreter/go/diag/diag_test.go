@@ -0,0 +1,69 @@
+package diag
+
+import (
+	"bytes"
+	"encoding/json"
+	"kaze/token"
+	"testing"
+)
+
+func TestRender(t *testing.T) {
+	source := "var x = 1;\n\tx + true;\n"
+	d := Errorf(RUNTIME_ERROR, Span{
+		Start: token.Position{Offset: 12, Line: 2, Column: 2},
+		End:   token.Position{Offset: 20, Line: 2, Column: 10},
+	}, "type mismatch: %s + %s", "INTEGER", "BOOLEAN")
+	d.Notes = []string{"operands must have the same type"}
+
+	expected := "error[R0001]: type mismatch: INTEGER + BOOLEAN\n" +
+		" --> main.kz:2:2\n" +
+		"  |\n" +
+		"2 | \tx + true;\n" +
+		"  | \t^^^^^^^^\n" +
+		"  = note: operands must have the same type\n"
+
+	if got := d.Render("main.kz", source); got != expected {
+		t.Fatalf("wrong rendering. expected=\n%s\ngot=\n%s", expected, got)
+	}
+}
+
+func TestRenderWithoutPosition(t *testing.T) {
+	d := Errorf(RUNTIME_ERROR, Span{}, "oops")
+
+	expected := "error[R0001]: oops\n --> main.kz\n"
+	if got := d.Render("main.kz", ""); got != expected {
+		t.Fatalf("wrong rendering. expected=%q, got=%q", expected, got)
+	}
+}
+
+func TestWriteJSON(t *testing.T) {
+	d := Errorf(UNEXPECTED_TOKEN, Span{
+		Start: token.Position{Offset: 4, Line: 1, Column: 5},
+		End:   token.Position{Offset: 5, Line: 1, Column: 6},
+	}, "expected next token to be IDENT, got = instead")
+
+	var buf bytes.Buffer
+	if err := WriteJSON(&buf, []*Diagnostic{d}); err != nil {
+		t.Fatalf("WriteJSON returned error: %s", err)
+	}
+
+	var decoded []Diagnostic
+	if err := json.Unmarshal(buf.Bytes(), &decoded); err != nil {
+		t.Fatalf("output is not valid JSON: %s", err)
+	}
+	if len(decoded) != 1 {
+		t.Fatalf("wrong number of diagnostics. got=%d", len(decoded))
+	}
+	if decoded[0].Severity != ERROR || decoded[0].Code != UNEXPECTED_TOKEN {
+		t.Fatalf("wrong severity or code. got=%s %s", decoded[0].Severity, decoded[0].Code)
+	}
+	if decoded[0].Span != d.Span {
+		t.Fatalf("wrong span. expected=%+v, got=%+v", d.Span, decoded[0].Span)
+	}
+
+	buf.Reset()
+	WriteJSON(&buf, nil)
+	if buf.String() != "[]\n" {
+		t.Fatalf("empty list not encoded as []. got=%q", buf.String())
+	}
+}
@@ -3,11 +3,23 @@ package eval
 import (
 	"fmt"
 	"kaze/object"
+	"math"
+	"math/big"
 	"os"
 	"strconv"
 	"strings"
 )
 
+// Args is what the args builtin returns. The interpreter sets it to the
+// program name, the script path and the script's arguments.
+var Args = os.Args
+
+// LookupBuiltin returns the builtin function with the given name.
+func LookupBuiltin(name string) (*object.Builtin, bool) {
+	builtin, ok := builtins[name]
+	return builtin, ok
+}
+
 var builtins = map[string]*object.Builtin{
 	"print": {
 		Fn: func(args ...object.Object) object.Object {
@@ -46,7 +58,7 @@ var builtins = map[string]*object.Builtin{
 			}
 
 			var _args []object.Object
-			for _, arg := range os.Args {
+			for _, arg := range Args {
 				_args = append(_args, &object.String{Value: arg})
 			}
 			return &object.Array{Elements: _args}
@@ -69,19 +81,49 @@ var builtins = map[string]*object.Builtin{
 				return &object.Error{Message: fmt.Sprintf("wrong number of arguments. got=%d, want=1", len(args))}
 			}
 			switch arg := args[0].(type) {
-			case *object.Integer:
+			case *object.Integer, *object.BigInt:
 				return arg
-			case *object.String:
-				value, ok := strconv.ParseInt(arg.Value, 10, 64)
-				if ok != nil {
+			case *object.Float:
+				if math.IsNaN(arg.Value) || math.IsInf(arg.Value, 0) {
 					return NAN
 				}
-				return &object.Integer{Value: value}
+				value, _ := big.NewFloat(arg.Value).Int(nil)
+				return object.NewInteger(value)
+			case *object.String:
+				value, ok := new(big.Int).SetString(arg.Value, 10)
+				if !ok {
+					return &object.Error{Kind: object.VALUE_ERROR, Message: fmt.Sprintf("invalid integer: %q", arg.Value)}
+				}
+				return object.NewInteger(value)
 			default:
 				return NAN
 			}
 		},
 	},
+	"float": {
+		Fn: func(args ...object.Object) object.Object {
+			if len(args) != 1 {
+				return &object.Error{Message: fmt.Sprintf("wrong number of arguments. got=%d, want=1", len(args))}
+			}
+			switch arg := args[0].(type) {
+			case *object.Float:
+				return arg
+			case *object.Integer:
+				return &object.Float{Value: float64(arg.Value)}
+			case *object.BigInt:
+				value, _ := new(big.Float).SetInt(arg.Value).Float64()
+				return &object.Float{Value: value}
+			case *object.String:
+				value, err := strconv.ParseFloat(arg.Value, 64)
+				if err != nil {
+					return &object.Float{Value: math.NaN()}
+				}
+				return &object.Float{Value: value}
+			default:
+				return &object.Float{Value: math.NaN()}
+			}
+		},
+	},
 	"ord": {
 		Fn: func(args ...object.Object) object.Object {
 			if len(args) != 1 {
@@ -1,10 +1,16 @@
 package eval
 
 import (
+	"context"
 	"fmt"
 	"kaze/ast"
+	"kaze/diag"
 	"kaze/object"
+	"kaze/token"
+	"math"
+	"math/big"
 	"reflect"
+	"strings"
 )
 
 var (
@@ -16,7 +22,42 @@ var (
 	CONTINUE = &object.Continue{}
 )
 
-func Eval(node ast.Node, env *object.Environment) object.Object {
+// Eval evaluates node in env. A program should be annotated by
+// resolver.Resolve first; identifiers that aren't are looked up by name.
+// Eval doesn't change the AST, so a resolved program can be evaluated many
+// times, also concurrently in different environments.
+func Eval(node ast.Node, env *object.Environment) (result object.Object) {
+	defer func() {
+		// A Go panic in the evaluator or a builtin is a bug, but it must not
+		// take down the host process.
+		if r := recover(); r != nil {
+			result = newKindError(object.INTERNAL_ERROR, "internal error: %v", r)
+		}
+		// The innermost node that produced an error determines its position
+		// and stack trace.
+		if err, ok := result.(*object.Error); ok && !err.Span.Start.IsValid() && node != nil {
+			err.Span = diag.Span{Start: node.Pos(), End: node.End()}
+			err.Stack = stackTrace(env.Frame(), node.Pos())
+			if err.Kind == "" {
+				err.Kind = object.RUNTIME_ERROR
+			}
+		}
+	}()
+
+	return evalNode(node, env)
+}
+
+// EvalContext is Eval for hosts that need to stop an evaluation: once ctx
+// is done, it fails with a TimeoutError or a CanceledError.
+func EvalContext(ctx context.Context, node ast.Node, env *object.Environment) object.Object {
+	limits := env.Limits()
+	env.SetLimits(limits.WithContext(ctx))
+	defer env.SetLimits(limits)
+
+	return Eval(node, env)
+}
+
+func evalNode(node ast.Node, env *object.Environment) object.Object {
 	switch node := node.(type) {
 	case *ast.Program:
 		evaluated := evalStatements(node.Statements, env)
@@ -30,7 +71,7 @@ func Eval(node ast.Node, env *object.Environment) object.Object {
 			return value
 		}
 
-		env.Create(node.Name.Value, value)
+		define(node.Name, value, env)
 	case *ast.ReturnStatement:
 		if node.ReturnValue == nil {
 			return &object.ReturnValue{Value: NULL}
@@ -41,10 +82,18 @@ func Eval(node ast.Node, env *object.Environment) object.Object {
 		}
 		return &object.ReturnValue{Value: val}
 	case *ast.FunctionDefinitionStatement:
-		fn := &object.Function{Parameters: node.Parameters, Body: node.Body, Env: env}
-		env.Create(node.Name.Value, fn)
+		fn := &object.Function{Name: node.Name.Value, Parameters: node.Parameters, Body: node.Body, Env: env, Slots: node.Slots}
+		define(node.Name, fn, env)
+	case *ast.SpreadExpression:
+		return newError("spread is only allowed in calls and array literals")
+	case *ast.FunctionLiteral:
+		return &object.Function{Parameters: node.Parameters, Body: node.Body, Env: env, Slots: node.Slots}
+	case *ast.ThrowStatement:
+		return evalThrowStatement(node, env)
 	case *ast.WhileStatement:
 		return evalWhileStatement(node, env)
+	case *ast.ForStatement:
+		return evalForStatement(node, env)
 	case *ast.BreakStatement:
 		return BREAK
 	case *ast.ContinueStatement:
@@ -58,6 +107,10 @@ func Eval(node ast.Node, env *object.Environment) object.Object {
 		}
 		return evalPrefixExpression(node.Operator, right)
 	case *ast.InfixExpression:
+		if node.Operator == "&&" || node.Operator == "||" {
+			return evalLogicalExpression(node, env)
+		}
+
 		left := Eval(node.Left, env)
 		if isError(left) {
 			return left
@@ -73,6 +126,10 @@ func Eval(node ast.Node, env *object.Environment) object.Object {
 			return err
 		}
 
+		if node.Operator != "=" {
+			return evalCompoundAssignment(node, lvalue, env)
+		}
+
 		value := Eval(node.Value, env)
 		if isError(value) {
 			return value
@@ -83,7 +140,10 @@ func Eval(node ast.Node, env *object.Environment) object.Object {
 		}
 		return newError("assignment failed")
 	case *ast.BlockExpression:
-		return evalStatements(node.Statements, object.NewEnclosedEnvironment(env))
+		if node.Slots > 0 {
+			env = object.NewEnclosedEnvironment(env, node.Slots)
+		}
+		return evalStatements(node.Statements, env)
 	case *ast.CallExpression:
 		fn := Eval(node.Function, env)
 		if isError(fn) {
@@ -95,14 +155,26 @@ func Eval(node ast.Node, env *object.Environment) object.Object {
 			return args[0]
 		}
 
-		return applyFunction(fn, args)
+		if err := env.Limits().Step(); err != nil {
+			return err
+		}
+
+		if function, ok := fn.(*object.Function); ok && node.Tail {
+			if err := checkArity(function, args); err != nil {
+				return err
+			}
+			return &object.TailCall{Function: function, Arguments: args}
+		}
+		return applyFunction(fn, args, node, env)
+	case *ast.TryExpression:
+		return evalTryExpression(node, env)
 	case *ast.IfExpression:
 		condition := Eval(node.Condition, env)
 		if isError(condition) {
 			return condition
 		}
 
-		if isTruthy(condition) {
+		if IsTruthy(condition) {
 			return Eval(node.Consequence, env)
 		}
 
@@ -121,12 +193,21 @@ func Eval(node ast.Node, env *object.Environment) object.Object {
 			return index
 		}
 		return evalIndexExpression(array, index)
+	case *ast.SliceExpression:
+		return evalSliceExpression(node, env)
 	case *ast.IntegerLiteral:
+		if node.Big != nil {
+			return &object.BigInt{Value: node.Big}
+		}
 		return &object.Integer{Value: node.Value}
+	case *ast.FloatLiteral:
+		return &object.Float{Value: node.Value}
 	case *ast.Boolean:
 		return nativeBoolToBooleanObject(node.Value)
 	case *ast.StringLiteral:
 		return newString(node.Value)
+	case *ast.InterpolatedString:
+		return evalInterpolatedString(node, env)
 	case *ast.Identifier:
 		return evalIdentifier(node, env)
 	case *ast.HashLiteral:
@@ -143,6 +224,9 @@ func Eval(node ast.Node, env *object.Environment) object.Object {
 func evalLValue(node ast.Expression, env *object.Environment) (object.LValue, *object.Error) {
 	switch node := node.(type) {
 	case *ast.Identifier:
+		if isLocal(node) {
+			return &object.Local{Env: env, Depth: node.Depth, Slot: node.Slot}, nil
+		}
 		return &object.Variable{Name: node.Value, Env: env}, nil
 	case *ast.IndexExpression:
 		array, err := evalLValue(node.Left, env)
@@ -155,6 +239,38 @@ func evalLValue(node ast.Expression, env *object.Environment) (object.LValue, *o
 	return nil, newError("not a lvalue: %s", node.String())
 }
 
+// evalCompoundAssignment evaluates x += y and friends, and x++ and x--. The
+// l-value has already been evaluated, so an index like h[f()] is computed
+// only once.
+func evalCompoundAssignment(node *ast.AssignExpression, lvalue object.LValue, env *object.Environment) object.Object {
+	current, ok := lvalue.Get()
+	if !ok {
+		return newError("cannot apply %s to %s: no current value", node.Operator, node.Left.String())
+	}
+
+	operator := strings.TrimSuffix(node.Operator, "=")
+	var value object.Object
+	if node.Value == nil {
+		// x++ and x--
+		operator, value = operator[:1], &object.Integer{Value: 1}
+	} else {
+		value = Eval(node.Value, env)
+		if isError(value) {
+			return value
+		}
+	}
+
+	result := evalInfixExpression(operator, current, value)
+	if isError(result) {
+		return result
+	}
+
+	if result, ok := lvalue.Update(result); ok {
+		return result
+	}
+	return newError("assignment failed")
+}
+
 func evalArrayLiteral(node *ast.ArrayLiteral, env *object.Environment) object.Object {
 	elements := evalExpressions(node.Elements, env)
 	if len(elements) == 1 && isError(elements[0]) {
@@ -178,7 +294,7 @@ func evalHashLiteral(node *ast.HashLiteral, env *object.Environment) object.Obje
 
 		hashKey, ok := key.(object.Hashable)
 		if !ok {
-			return newError("unusable as hash key: %s", key.Type())
+			return newKindError(object.TYPE_ERROR, "unusable as hash key: %s", key.Type())
 		}
 
 		hashed := hashKey.HashKey()
@@ -188,6 +304,29 @@ func evalHashLiteral(node *ast.HashLiteral, env *object.Environment) object.Obje
 	return &object.Hash{Pairs: pairs}
 }
 
+func evalInterpolatedString(node *ast.InterpolatedString, env *object.Environment) object.Object {
+	var out strings.Builder
+
+	for _, part := range node.Parts {
+		value := Eval(part, env)
+		if isError(value) {
+			return value
+		}
+		printable, ok := value.(object.Printable)
+		if !ok {
+			return newKindError(object.TYPE_ERROR, "cannot interpolate type: %s", value.Type())
+		}
+		out.WriteString(printable.String())
+	}
+
+	return newString(out.String())
+}
+
+// IndexOperation evaluates left[index].
+func IndexOperation(left object.Object, index object.Object) object.Object {
+	return evalIndexExpression(left, index)
+}
+
 func evalIndexExpression(left object.Object, index object.Object) object.Object {
 	switch {
 	case left.Type() == object.STRING_OBJ && index.Type() == object.INTEGER_OBJ:
@@ -196,17 +335,157 @@ func evalIndexExpression(left object.Object, index object.Object) object.Object
 		return evalArrayIndexExpression(left, index)
 	case left.Type() == object.HASH_OBJ:
 		return evalHashIndexExpression(left, index)
+	case left.Type() == object.EXCEPTION_OBJ:
+		return evalExceptionIndexExpression(left, index)
+	case index.Type() == object.RANGE_OBJ:
+		r := index.(*object.Range)
+		return sliceObject(left, r.Start, r.End, r.Inclusive)
+	default:
+		return newKindError(object.TYPE_ERROR, "index operator not supported: %s", left.Type())
+	}
+}
+
+// evalExceptionIndexExpression gives scripts access to a caught error
+// through e["message"], e["kind"] and e["stack"].
+func evalExceptionIndexExpression(exception object.Object, index object.Object) object.Object {
+	err := exception.(*object.Exception).Error
+	key, ok := index.(*object.String)
+	if !ok {
+		return newKindError(object.TYPE_ERROR, "unusable as exception key: %s", index.Type())
+	}
+
+	switch key.Value {
+	case "message":
+		return newString(err.Message)
+	case "kind":
+		return newString(err.Kind)
+	case "stack":
+		stack := make([]object.Object, len(err.Stack))
+		for i, entry := range err.Stack {
+			stack[i] = newString(entry.String())
+		}
+		return &object.Array{Elements: stack}
+	default:
+		return NULL
+	}
+}
+
+func evalSliceExpression(node *ast.SliceExpression, env *object.Environment) object.Object {
+	left := Eval(node.Left, env)
+	if isError(left) {
+		return left
+	}
+
+	var low, high object.Object
+	if node.Low != nil {
+		low = Eval(node.Low, env)
+		if isError(low) {
+			return low
+		}
+	}
+	if node.High != nil {
+		high = Eval(node.High, env)
+		if isError(high) {
+			return high
+		}
+	}
+
+	return SliceOperation(left, low, high)
+}
+
+// SliceOperation evaluates left[low:high]. A nil bound was omitted.
+func SliceOperation(left, low, high object.Object) object.Object {
+	lowVal, err := sliceBound(low, 0)
+	if err != nil {
+		return err
+	}
+	highVal, err := sliceBound(high, math.MaxInt64)
+	if err != nil {
+		return err
+	}
+
+	return sliceObject(left, lowVal, highVal, false)
+}
+
+func sliceBound(bound object.Object, omitted int64) (int64, *object.Error) {
+	if bound == nil {
+		return omitted, nil
+	}
+
+	switch bound := bound.(type) {
+	case *object.Integer:
+		return bound.Value, nil
+	case *object.BigInt:
+		// Out-of-range bounds are clamped anyway.
+		if bound.Value.Sign() < 0 {
+			return math.MinInt64, nil
+		}
+		return math.MaxInt64, nil
+	default:
+		return 0, newKindError(object.TYPE_ERROR, "slice bounds must be integers, got %s", bound.Type())
+	}
+}
+
+// sliceObject returns the elements of an array or string between low and
+// high. Negative bounds count from the end and out-of-range bounds are
+// clamped, as in Python.
+func sliceObject(obj object.Object, low, high int64, inclusive bool) object.Object {
+	var length int64
+	switch obj := obj.(type) {
+	case *object.Array:
+		length = int64(len(obj.Elements))
+	case *object.String:
+		length = int64(len(obj.Value))
+	default:
+		return newKindError(object.TYPE_ERROR, "slice operator not supported: %s", obj.Type())
+	}
+
+	if low < 0 {
+		low += length
+	}
+	if high < 0 {
+		high += length
+	}
+	if inclusive && high < length {
+		high++
+	}
+
+	low = clampIndex(low, length)
+	high = clampIndex(high, length)
+	if low > high {
+		low = high
+	}
+
+	switch obj := obj.(type) {
+	case *object.Array:
+		elements := make([]object.Object, high-low)
+		copy(elements, obj.Elements[low:high])
+		return &object.Array{Elements: elements}
 	default:
-		return newError("index operator not supported: %s", left.Type())
+		return newString(obj.(*object.String).Value[low:high])
 	}
 }
 
+func clampIndex(index, length int64) int64 {
+	if index < 0 {
+		return 0
+	}
+	if index > length {
+		return length
+	}
+	return index
+}
+
 func evalArrayIndexExpression(left object.Object, index object.Object) object.Object {
 	array := left.(*object.Array)
-	idx := index.(*object.Integer).Value
+	integer, ok := index.(*object.Integer)
+	if !ok {
+		return newKindError(object.INDEX_ERROR, "index out of range: %s", index.Inspect())
+	}
+	idx := integer.Value
 
 	if idx < 0 || idx >= int64(len(array.Elements)) {
-		return newError("index out of range: %d", idx)
+		return newKindError(object.INDEX_ERROR, "index out of range: %d", idx)
 	}
 
 	return array.Elements[idx]
@@ -216,7 +495,7 @@ func evalHashIndexExpression(hash object.Object, index object.Object) object.Obj
 	hashObject := hash.(*object.Hash)
 	key, ok := index.(object.Hashable)
 	if !ok {
-		return newError("unusable as hash key: %s", index.Type())
+		return newKindError(object.TYPE_ERROR, "unusable as hash key: %s", index.Type())
 	}
 
 	pair, ok := hashObject.Pairs[key.HashKey()]
@@ -229,17 +508,29 @@ func evalHashIndexExpression(hash object.Object, index object.Object) object.Obj
 
 func evalStringIndexExpression(stringObj object.Object, indexObj object.Object) object.Object {
 	str := stringObj.(*object.String).Value
-	index := indexObj.(*object.Integer).Value
+	integer, ok := indexObj.(*object.Integer)
+	if !ok {
+		return newKindError(object.INDEX_ERROR, "index out of range: %s", indexObj.Inspect())
+	}
+	index := integer.Value
 
 	if index < 0 || index >= int64(len(str)) {
-		return newError("index out of range: %d", index)
+		return newKindError(object.INDEX_ERROR, "index out of range: %d", index)
 	}
 
 	return newString(string(str[index]))
 }
 
 func evalWhileStatement(node *ast.WhileStatement, env *object.Environment) object.Object {
-	for isTruthy(Eval(node.Condition, env)) {
+	for {
+		condition := Eval(node.Condition, env)
+		if isError(condition) {
+			return condition
+		}
+		if !IsTruthy(condition) {
+			break
+		}
+
 		result := Eval(node.Body, env)
 		if isError(result) {
 			return result
@@ -250,14 +541,244 @@ func evalWhileStatement(node *ast.WhileStatement, env *object.Environment) objec
 		if result == BREAK {
 			break
 		}
-		if result == CONTINUE {
-			continue
+		if err := env.Limits().Step(); err != nil {
+			return err
 		}
 	}
 	return NULL
 }
 
+func evalForStatement(node *ast.ForStatement, env *object.Environment) object.Object {
+	iterable := Eval(node.Iterable, env)
+	if isError(iterable) {
+		return iterable
+	}
+
+	var result object.Object = NULL
+	err := iterate(iterable, len(node.Variables) == 2, func(first, second object.Object) bool {
+		// Each iteration gets its own scope so closures capture that
+		// iteration's variables.
+		loopEnv := object.NewEnclosedEnvironment(env, node.Slots)
+		define(node.Variables[0], first, loopEnv)
+		if len(node.Variables) == 2 {
+			define(node.Variables[1], second, loopEnv)
+		}
+
+		evaluated := Eval(node.Body, loopEnv)
+		if isError(evaluated) {
+			result = evaluated
+			return false
+		}
+		if returnValue, ok := evaluated.(*object.ReturnValue); ok {
+			result = returnValue
+			return false
+		}
+		if evaluated == BREAK {
+			return false
+		}
+		if err := env.Limits().Step(); err != nil {
+			result = err
+			return false
+		}
+		return true
+	})
+	if err != nil {
+		return err
+	}
+
+	return result
+}
+
+// iterate calls fn for each element of obj until fn returns false. With
+// pairs, fn receives index and element (key and value for a hash); without,
+// it receives the element (the key for a hash).
+func iterate(obj object.Object, pairs bool, fn func(first, second object.Object) bool) *object.Error {
+	it, err := NewIterator(obj, pairs)
+	if err != nil {
+		return err
+	}
+	for {
+		first, second, ok := it.Next()
+		if !ok || !fn(first, second) {
+			return nil
+		}
+	}
+}
+
+// Iterator steps through the elements of an array, string, range or hash, in
+// the order of a for loop.
+type Iterator struct {
+	pairs bool
+	index int64
+
+	array    *object.Array
+	str      *object.String
+	hash     []object.HashPair
+	next     int64 // the next number of a range
+	last     int64 // the last number of a range
+	finished bool
+}
+
+// NewIterator returns an iterator over obj. With pairs, each step yields
+// index and element (key and value for a hash); without, it yields the
+// element (the key for a hash).
+func NewIterator(obj object.Object, pairs bool) (*Iterator, *object.Error) {
+	it := &Iterator{pairs: pairs}
+
+	switch obj := obj.(type) {
+	case *object.Array:
+		it.array = obj
+	case *object.String:
+		it.str = obj
+	case *object.Range:
+		it.next, it.last = obj.Start, obj.End
+		if !obj.Inclusive {
+			if obj.End == math.MinInt64 {
+				it.finished = true
+			}
+			it.last--
+		}
+		if it.next > it.last {
+			it.finished = true
+		}
+	case *object.Hash:
+		it.hash = obj.SortedPairs()
+	default:
+		return nil, newKindError(object.TYPE_ERROR, "cannot iterate over %s", obj.Type())
+	}
+
+	return it, nil
+}
+
+// Next returns the next element, or ok == false when there are no more.
+func (it *Iterator) Next() (first, second object.Object, ok bool) {
+	if it.finished {
+		return nil, nil, false
+	}
+
+	index := &object.Integer{Value: it.index}
+	var element object.Object
+	switch {
+	case it.array != nil:
+		if it.index >= int64(len(it.array.Elements)) {
+			return nil, nil, false
+		}
+		element = it.array.Elements[it.index]
+	case it.str != nil:
+		if it.index >= int64(len(it.str.Value)) {
+			return nil, nil, false
+		}
+		element = newString(string(it.str.Value[it.index]))
+	case it.hash != nil:
+		if it.index >= int64(len(it.hash)) {
+			return nil, nil, false
+		}
+		pair := it.hash[it.index]
+		it.index++
+		if it.pairs {
+			return pair.Key, pair.Value, true
+		}
+		return pair.Key, nil, true
+	default:
+		element = &object.Integer{Value: it.next}
+		// Stop explicitly at last so a range ending at MaxInt64 doesn't wrap.
+		if it.next == it.last {
+			it.finished = true
+		} else {
+			it.next++
+		}
+	}
+	it.index++
+
+	if it.pairs {
+		return index, element, true
+	}
+	return element, nil, true
+}
+
+func evalThrowStatement(node *ast.ThrowStatement, env *object.Environment) object.Object {
+	value := Eval(node.Value, env)
+	if isError(value) {
+		return value
+	}
+
+	return ThrownError(value)
+}
+
+// ThrownError returns the error raised by throwing value.
+func ThrownError(value object.Object) *object.Error {
+	switch value := value.(type) {
+	case *object.Exception:
+		// Rethrowing keeps the original position and stack trace.
+		return value.Error
+	case *object.Hash:
+		// #{"kind": ..., "message": ...} throws an error of a custom kind.
+		err := &object.Error{Kind: object.ERROR, Message: value.Inspect()}
+		if message, ok := hashString(value, "message"); ok {
+			err.Message = message
+		}
+		if kind, ok := hashString(value, "kind"); ok {
+			err.Kind = kind
+		}
+		return err
+	case object.Printable:
+		return &object.Error{Kind: object.ERROR, Message: value.String()}
+	default:
+		return &object.Error{Kind: object.ERROR, Message: value.Inspect()}
+	}
+}
+
+func hashString(hash *object.Hash, key string) (string, bool) {
+	pair, ok := hash.Pairs[newString(key).HashKey()]
+	if !ok {
+		return "", false
+	}
+	value, ok := pair.Value.(*object.String)
+	if !ok {
+		return "", false
+	}
+	return value.Value, true
+}
+
+// evalTryExpression runs the try block, then the catch block if the try
+// block raised an error, then the finally block. A finally block that
+// itself raises, returns or breaks overrides the result of the others. A
+// fatal error skips both blocks.
+func evalTryExpression(node *ast.TryExpression, env *object.Environment) object.Object {
+	result := Eval(node.Block, env)
+	if isFatal(result) {
+		return result
+	}
+
+	if err, ok := result.(*object.Error); ok && node.Catch != nil {
+		catchEnv := object.NewEnclosedEnvironment(env, node.CatchSlots)
+		if node.CatchParam != nil {
+			define(node.CatchParam, &object.Exception{Error: err}, catchEnv)
+		}
+		result = Eval(node.Catch, catchEnv)
+		if isFatal(result) {
+			return result
+		}
+	}
+
+	if node.Finally != nil {
+		finally := Eval(node.Finally, env)
+		if _, ok := finally.(*object.ReturnValue); ok || isError(finally) || finally == BREAK || finally == CONTINUE {
+			return finally
+		}
+	}
+
+	return result
+}
+
 func evalIdentifier(node *ast.Identifier, env *object.Environment) object.Object {
+	if isLocal(node) {
+		// A closure can run before a variable it sees is declared.
+		if val := env.GetAt(node.Depth, node.Slot); val != nil {
+			return val
+		}
+		return newKindError(object.NAME_ERROR, "identifier not found: %s", node.Value)
+	}
 	if val, ok := env.Get(node.Value); ok {
 		return val
 	}
@@ -265,7 +786,23 @@ func evalIdentifier(node *ast.Identifier, env *object.Environment) object.Object
 		return val
 	}
 
-	return newError("identifier not found: " + node.Value)
+	return newKindError(object.NAME_ERROR, "identifier not found: %s", node.Value)
+}
+
+// define binds the variable declared by ident in env, the scope of the
+// declaration.
+func define(ident *ast.Identifier, val object.Object, env *object.Environment) {
+	if isLocal(ident) {
+		env.SetAt(ident.Depth, ident.Slot, val)
+	} else {
+		env.Create(ident.Value, val)
+	}
+}
+
+// isLocal reports whether the resolver found ident in a slot. Other
+// identifiers are looked up by name.
+func isLocal(ident *ast.Identifier) bool {
+	return ident.Resolved && ident.Depth >= 0
 }
 
 func evalStatements(stmts []ast.Statement, env *object.Environment) object.Object {
@@ -287,14 +824,23 @@ func evalStatements(stmts []ast.Statement, env *object.Environment) object.Objec
 	return result
 }
 
+// PrefixOperation applies a unary operator to an evaluated operand. Like the
+// other exported operations, it lets the bytecode VM share the evaluator's
+// semantics.
+func PrefixOperation(operator string, right object.Object) object.Object {
+	return evalPrefixExpression(operator, right)
+}
+
 func evalPrefixExpression(operator string, right object.Object) object.Object {
 	switch operator {
 	case "!":
 		return evalBangOperatorExpression(right)
 	case "-":
 		return evalMinusPrefixOperatorExpression(right)
+	case "~":
+		return evalTildePrefixOperatorExpression(right)
 	default:
-		return newError("unknown operator: %s%s", operator, right.Type())
+		return newKindError(object.TYPE_ERROR, "unknown operator: %s%s", operator, right.Type())
 	}
 }
 
@@ -312,33 +858,84 @@ func evalBangOperatorExpression(right object.Object) object.Object {
 }
 
 func evalMinusPrefixOperatorExpression(right object.Object) object.Object {
-	if right.Type() != object.INTEGER_OBJ {
-		return newError("unknown operator: -%s", right.Type())
+	switch right := right.(type) {
+	case *object.Integer:
+		if right.Value == math.MinInt64 {
+			return object.NewInteger(new(big.Int).Neg(big.NewInt(right.Value)))
+		}
+		return &object.Integer{Value: -right.Value}
+	case *object.BigInt:
+		return object.NewInteger(new(big.Int).Neg(right.Value))
+	case *object.Float:
+		return &object.Float{Value: -right.Value}
+	default:
+		return newKindError(object.TYPE_ERROR, "unknown operator: -%s", right.Type())
 	}
+}
 
-	value := right.(*object.Integer).Value
-	return &object.Integer{Value: -value}
+func evalTildePrefixOperatorExpression(right object.Object) object.Object {
+	switch right := right.(type) {
+	case *object.Integer:
+		return &object.Integer{Value: ^right.Value}
+	case *object.BigInt:
+		return object.NewInteger(new(big.Int).Not(right.Value))
+	default:
+		return newKindError(object.TYPE_ERROR, "unknown operator: ~%s", right.Type())
+	}
+}
+
+// evalLogicalExpression evaluates && and || with short-circuiting. The result
+// is the operand that decided the outcome, not a coerced boolean.
+func evalLogicalExpression(node *ast.InfixExpression, env *object.Environment) object.Object {
+	left := Eval(node.Left, env)
+	if isError(left) {
+		return left
+	}
+
+	if IsTruthy(left) == (node.Operator == "||") {
+		return left
+	}
+
+	return Eval(node.Right, env)
+}
+
+// InfixOperation applies a binary operator such as "+" or "..=" to evaluated
+// operands.
+func InfixOperation(operator string, left object.Object, right object.Object) object.Object {
+	return evalInfixExpression(operator, left, right)
 }
 
 func evalInfixExpression(operator string, left object.Object, right object.Object) object.Object {
 	switch {
+	case operator == ".." || operator == "..=":
+		return evalRangeExpression(operator, left, right)
 	case left.Type() == object.INTEGER_OBJ && right.Type() == object.INTEGER_OBJ:
 		return evalIntegerInfixExpression(operator, left, right)
+	case isNumber(left) && isNumber(right):
+		return evalFloatInfixExpression(operator, left, right)
 	case left.Type() == object.STRING_OBJ && right.Type() == object.STRING_OBJ:
 		return evalStringInfixExpression(operator, left, right)
 	case operator == "==":
 		return nativeBoolToBooleanObject(reflect.DeepEqual(left, right))
 	case operator == "!=":
 		return nativeBoolToBooleanObject(!reflect.DeepEqual(left, right))
-	case operator == "&&":
-		return nativeBoolToBooleanObject(isTruthy(left) && isTruthy(right))
-	case operator == "||":
-		return nativeBoolToBooleanObject(isTruthy(left) || isTruthy(right))
 	case left.Type() != right.Type():
-		return newError("type mismatch: %s %s %s", left.Type(), operator, right.Type())
+		return newKindError(object.TYPE_ERROR, "type mismatch: %s %s %s", left.Type(), operator, right.Type())
 	default:
-		return newError("unknown operator: %s %s %s", left.Type(), operator, right.Type())
+		return newKindError(object.TYPE_ERROR, "unknown operator: %s %s %s", left.Type(), operator, right.Type())
+	}
+}
+
+func evalRangeExpression(operator string, left object.Object, right object.Object) object.Object {
+	if left.Type() != object.INTEGER_OBJ || right.Type() != object.INTEGER_OBJ {
+		return newKindError(object.TYPE_ERROR, "range bounds must be integers, got %s%s%s", left.Type(), operator, right.Type())
+	}
+	for _, bound := range []object.Object{left, right} {
+		if bound, ok := bound.(*object.BigInt); ok {
+			return newKindError(object.VALUE_ERROR, "range bound out of range: %s", bound.Inspect())
+		}
 	}
+	return &object.Range{Start: left.(*object.Integer).Value, End: right.(*object.Integer).Value, Inclusive: operator == "..="}
 }
 
 func evalStringInfixExpression(operator string, left object.Object, right object.Object) object.Object {
@@ -361,7 +958,7 @@ func evalStringInfixExpression(operator string, left object.Object, right object
 	case "!=":
 		return nativeBoolToBooleanObject(leftVal != rightVal)
 	default:
-		return newError("unknown operator: %s %s %s", left.Type(), operator, right.Type())
+		return newKindError(object.TYPE_ERROR, "unknown operator: %s %s %s", left.Type(), operator, right.Type())
 	}
 }
 
@@ -370,18 +967,65 @@ func evalIntegerInfixExpression(operator string, left object.Object, right objec
 		return NAN
 	}
 
-	leftVal := left.(*object.Integer).Value
-	rightVal := right.(*object.Integer).Value
+	leftInt, leftOk := left.(*object.Integer)
+	rightInt, rightOk := right.(*object.Integer)
+	if !leftOk || !rightOk {
+		return evalBigIntInfixExpression(operator, left, right)
+	}
+	leftVal := leftInt.Value
+	rightVal := rightInt.Value
 
 	switch operator {
 	case "+":
-		return &object.Integer{Value: leftVal + rightVal}
+		result := leftVal + rightVal
+		if (leftVal >= 0) == (rightVal >= 0) && (result >= 0) != (leftVal >= 0) {
+			return evalBigIntInfixExpression(operator, left, right)
+		}
+		return &object.Integer{Value: result}
 	case "-":
-		return &object.Integer{Value: leftVal - rightVal}
+		result := leftVal - rightVal
+		if (leftVal >= 0) != (rightVal >= 0) && (result >= 0) != (leftVal >= 0) {
+			return evalBigIntInfixExpression(operator, left, right)
+		}
+		return &object.Integer{Value: result}
 	case "*":
-		return &object.Integer{Value: leftVal * rightVal}
+		result := leftVal * rightVal
+		if leftVal != 0 && (result/leftVal != rightVal || leftVal == -1 && rightVal == math.MinInt64) {
+			return evalBigIntInfixExpression(operator, left, right)
+		}
+		return &object.Integer{Value: result}
 	case "/":
+		if rightVal == 0 {
+			return newKindError(object.ZERO_DIVISION_ERROR, "division by zero")
+		}
+		if leftVal == math.MinInt64 && rightVal == -1 {
+			return evalBigIntInfixExpression(operator, left, right)
+		}
 		return &object.Integer{Value: leftVal / rightVal}
+	case "%":
+		if rightVal == 0 {
+			return newKindError(object.ZERO_DIVISION_ERROR, "division by zero")
+		}
+		return &object.Integer{Value: leftVal % rightVal}
+	case "&":
+		return &object.Integer{Value: leftVal & rightVal}
+	case "|":
+		return &object.Integer{Value: leftVal | rightVal}
+	case "^":
+		return &object.Integer{Value: leftVal ^ rightVal}
+	case "<<":
+		if rightVal >= 0 && rightVal < 63 && (leftVal<<rightVal)>>rightVal == leftVal {
+			return &object.Integer{Value: leftVal << rightVal}
+		}
+		return evalShift(operator, left, right)
+	case ">>":
+		if rightVal < 0 {
+			return evalShift(operator, left, right)
+		}
+		if rightVal > 63 {
+			rightVal = 63
+		}
+		return &object.Integer{Value: leftVal >> rightVal}
 	case "<":
 		return nativeBoolToBooleanObject(leftVal < rightVal)
 	case ">":
@@ -395,7 +1039,122 @@ func evalIntegerInfixExpression(operator string, left object.Object, right objec
 	case "!=":
 		return nativeBoolToBooleanObject(leftVal != rightVal)
 	default:
-		return newError("unknown operator: %s %s %s", left.Type(), operator, right.Type())
+		return newKindError(object.TYPE_ERROR, "unknown operator: %s %s %s", left.Type(), operator, right.Type())
+	}
+}
+
+// maxShiftCount bounds << so a script can't allocate an absurdly large integer.
+const maxShiftCount = 1 << 16
+
+// evalShift shifts an integer of either representation. >> by any count
+// ends at 0 or -1, and << of a non-zero value can't go past maxShiftCount.
+func evalShift(operator string, left object.Object, right object.Object) object.Object {
+	value, count := toBigInt(left), toBigInt(right)
+	if count.Sign() < 0 {
+		return newKindError(object.VALUE_ERROR, "negative shift count: %s", count)
+	}
+
+	if operator == ">>" {
+		// Shifting by the bit length already leaves only the sign.
+		n := int64(value.BitLen())
+		if count.IsInt64() && count.Int64() < n {
+			n = count.Int64()
+		}
+		return object.NewInteger(new(big.Int).Rsh(value, uint(n)))
+	}
+	if value.Sign() == 0 {
+		return &object.Integer{Value: 0}
+	}
+	if !count.IsInt64() || count.Int64() > maxShiftCount {
+		return newKindError(object.VALUE_ERROR, "shift count too large: %s", count)
+	}
+	return object.NewInteger(new(big.Int).Lsh(value, uint(count.Int64())))
+}
+
+// evalBigIntInfixExpression evaluates an integer operation with arbitrary
+// precision. It handles operands that are already BigInts as well as int64
+// operations that would overflow.
+func evalBigIntInfixExpression(operator string, left object.Object, right object.Object) object.Object {
+	leftVal := toBigInt(left)
+	rightVal := toBigInt(right)
+
+	switch operator {
+	case "+":
+		return object.NewInteger(new(big.Int).Add(leftVal, rightVal))
+	case "-":
+		return object.NewInteger(new(big.Int).Sub(leftVal, rightVal))
+	case "*":
+		return object.NewInteger(new(big.Int).Mul(leftVal, rightVal))
+	case "/":
+		if rightVal.Sign() == 0 {
+			return newKindError(object.ZERO_DIVISION_ERROR, "division by zero")
+		}
+		return object.NewInteger(new(big.Int).Quo(leftVal, rightVal))
+	case "%":
+		if rightVal.Sign() == 0 {
+			return newKindError(object.ZERO_DIVISION_ERROR, "division by zero")
+		}
+		return object.NewInteger(new(big.Int).Rem(leftVal, rightVal))
+	case "&":
+		return object.NewInteger(new(big.Int).And(leftVal, rightVal))
+	case "|":
+		return object.NewInteger(new(big.Int).Or(leftVal, rightVal))
+	case "^":
+		return object.NewInteger(new(big.Int).Xor(leftVal, rightVal))
+	case "<<", ">>":
+		return evalShift(operator, left, right)
+	case "<":
+		return nativeBoolToBooleanObject(leftVal.Cmp(rightVal) < 0)
+	case ">":
+		return nativeBoolToBooleanObject(leftVal.Cmp(rightVal) > 0)
+	case "<=":
+		return nativeBoolToBooleanObject(leftVal.Cmp(rightVal) <= 0)
+	case ">=":
+		return nativeBoolToBooleanObject(leftVal.Cmp(rightVal) >= 0)
+	case "==":
+		return nativeBoolToBooleanObject(leftVal.Cmp(rightVal) == 0)
+	case "!=":
+		return nativeBoolToBooleanObject(leftVal.Cmp(rightVal) != 0)
+	default:
+		return newKindError(object.TYPE_ERROR, "unknown operator: %s %s %s", left.Type(), operator, right.Type())
+	}
+}
+
+// evalFloatInfixExpression evaluates an operation between two numbers where at
+// least one is a float. Integer operands are converted to float first.
+func evalFloatInfixExpression(operator string, left object.Object, right object.Object) object.Object {
+	if left == NAN || right == NAN {
+		return NAN
+	}
+
+	leftVal := toFloat(left)
+	rightVal := toFloat(right)
+
+	switch operator {
+	case "+":
+		return &object.Float{Value: leftVal + rightVal}
+	case "-":
+		return &object.Float{Value: leftVal - rightVal}
+	case "*":
+		return &object.Float{Value: leftVal * rightVal}
+	case "/":
+		return &object.Float{Value: leftVal / rightVal}
+	case "%":
+		return &object.Float{Value: math.Mod(leftVal, rightVal)}
+	case "<":
+		return nativeBoolToBooleanObject(leftVal < rightVal)
+	case ">":
+		return nativeBoolToBooleanObject(leftVal > rightVal)
+	case "<=":
+		return nativeBoolToBooleanObject(leftVal <= rightVal)
+	case ">=":
+		return nativeBoolToBooleanObject(leftVal >= rightVal)
+	case "==":
+		return nativeBoolToBooleanObject(leftVal == rightVal)
+	case "!=":
+		return nativeBoolToBooleanObject(leftVal != rightVal)
+	default:
+		return newKindError(object.TYPE_ERROR, "unknown operator: %s %s %s", left.Type(), operator, right.Type())
 	}
 }
 
@@ -403,6 +1162,15 @@ func evalExpressions(expressions []ast.Expression, env *object.Environment) []ob
 	var result []object.Object
 
 	for _, expression := range expressions {
+		if spread, ok := expression.(*ast.SpreadExpression); ok {
+			elements := evalSpreadExpression(spread, env)
+			if len(elements) == 1 && isError(elements[0]) {
+				return elements
+			}
+			result = append(result, elements...)
+			continue
+		}
+
 		expr := Eval(expression, env)
 		if isError(expr) {
 			return []object.Object{expr}
@@ -413,29 +1181,177 @@ func evalExpressions(expressions []ast.Expression, env *object.Environment) []ob
 	return result
 }
 
-func applyFunction(fn object.Object, args []object.Object) object.Object {
+func evalSpreadExpression(node *ast.SpreadExpression, env *object.Environment) []object.Object {
+	value := Eval(node.Value, env)
+	if isError(value) {
+		return []object.Object{value}
+	}
+
+	elements, err := SpreadElements(value)
+	if err != nil {
+		err.Span = diag.Span{Start: node.Pos(), End: node.End()}
+		err.Stack = stackTrace(env.Frame(), node.Pos())
+		return []object.Object{err}
+	}
+	return elements
+}
+
+// SpreadElements returns the elements ...value stands for.
+func SpreadElements(value object.Object) ([]object.Object, *object.Error) {
+	switch value := value.(type) {
+	case *object.Array:
+		return value.Elements, nil
+	case *object.Range:
+		var elements []object.Object
+		iterate(value, false, func(element, _ object.Object) bool {
+			elements = append(elements, element)
+			return true
+		})
+		return elements, nil
+	default:
+		return nil, newKindError(object.TYPE_ERROR, "cannot spread %s", value.Type())
+	}
+}
+
+func applyFunction(fn object.Object, args []object.Object, call *ast.CallExpression, env *object.Environment) object.Object {
 	if fn.Type() == object.BUILTIN_OBJ {
 		return fn.(*object.Builtin).Fn(args...)
 	}
 
 	function, ok := fn.(*object.Function)
 	if !ok {
-		return newError("not a function: %s", fn.Type())
+		return newKindError(object.TYPE_ERROR, "not a function: %s", fn.Type())
 	}
 
-	extendedEnv := extendFunctionEnv(function, args)
-	evaluated := Eval(function.Body, extendedEnv)
-	return unwrapReturnValue(evaluated)
+	if err := checkArity(function, args); err != nil {
+		return err
+	}
+
+	depth := 1
+	if caller := env.Frame(); caller != nil {
+		depth = caller.Depth + 1
+	}
+	if depth > maxCallDepth(env) {
+		return newKindError(object.RECURSION_ERROR, "maximum recursion depth exceeded")
+	}
+
+	// A call in tail position comes back as a TailCall, which is made here
+	// in place of the finished call instead of nesting another one. To the
+	// stack trace, it looks as if the caller had made it.
+	for {
+		frame := &object.Frame{Function: functionName(function), Call: call.Pos(), Caller: env.Frame(), Depth: depth}
+		extendedEnv, err := extendFunctionEnv(function, args, env, frame)
+		if err != nil {
+			return err
+		}
+
+		evaluated := unwrapReturnValue(Eval(function.Body, extendedEnv))
+		tailCall, ok := evaluated.(*object.TailCall)
+		if !ok {
+			return evaluated
+		}
+		function, args = tailCall.Function, tailCall.Arguments
+	}
 }
 
-func extendFunctionEnv(function *object.Function, args []object.Object) *object.Environment {
-	env := object.NewEnclosedEnvironment(function.Env)
+// DefaultMaxCallDepth is the maximum depth of nested function calls when the
+// environment doesn't set a limit. It keeps runaway recursion from
+// exhausting the Go stack.
+const DefaultMaxCallDepth = 10000
 
+func maxCallDepth(env *object.Environment) int {
+	if limits := env.Limits(); limits != nil && limits.MaxCallDepth > 0 {
+		return limits.MaxCallDepth
+	}
+	return DefaultMaxCallDepth
+}
+
+func checkArity(function *object.Function, args []object.Object) *object.Error {
+	required, max := 0, len(function.Parameters)
 	for i, param := range function.Parameters {
-		env.Create(param.Value, args[i])
+		if param.Rest {
+			max = -1
+		} else if param.Default == nil {
+			required = i + 1
+		}
 	}
 
-	return env
+	return CheckArity(function.Name, len(args), required, max)
+}
+
+// CheckArity reports an error if a function with the given number of
+// required parameters and at most max parameters (-1 for no limit) can't be
+// called with got arguments. An empty name stands for an anonymous function.
+func CheckArity(name string, got, required, max int) *object.Error {
+	if got >= required && (max < 0 || got <= max) {
+		return nil
+	}
+
+	if name == "" {
+		name = "anonymous function"
+	}
+
+	var want string
+	switch {
+	case max < 0:
+		want = fmt.Sprintf(">=%d", required)
+	case required == max:
+		want = fmt.Sprintf("=%d", required)
+	default:
+		want = fmt.Sprintf("=%d..%d", required, max)
+	}
+	return newKindError(object.ARGUMENT_ERROR, "wrong number of arguments to %s: got=%d, want%s", name, got, want)
+}
+
+// extendFunctionEnv binds the arguments to the parameters in a new scope.
+// Default values are evaluated in that scope, so they can refer to earlier
+// parameters.
+func extendFunctionEnv(function *object.Function, args []object.Object, caller *object.Environment, frame *object.Frame) (*object.Environment, *object.Error) {
+	env := object.NewFunctionEnvironment(function.Env, caller, frame, function.Slots)
+
+	for i, param := range function.Parameters {
+		switch {
+		case param.Rest:
+			rest := []object.Object{}
+			if i < len(args) {
+				rest = append(rest, args[i:]...)
+			}
+			define(param.Name, &object.Array{Elements: rest}, env)
+		case i < len(args):
+			define(param.Name, args[i], env)
+		default:
+			value := Eval(param.Default, env)
+			if err, ok := value.(*object.Error); ok {
+				return nil, err
+			}
+			define(param.Name, value, env)
+		}
+	}
+
+	return env, nil
+}
+
+func functionName(function *object.Function) string {
+	if function.Name == "" {
+		return "<anonymous>"
+	}
+	return function.Name
+}
+
+// stackTrace lists the calls that led to pos, outermost first. Each entry
+// names a function and the position it had reached.
+func stackTrace(frame *object.Frame, pos token.Position) []object.TraceEntry {
+	var trace []object.TraceEntry
+	for ; frame != nil; frame = frame.Caller {
+		trace = append(trace, object.TraceEntry{Function: frame.Function, Pos: pos})
+		pos = frame.Call
+	}
+	trace = append(trace, object.TraceEntry{Function: "<main>", Pos: pos})
+
+	for i, j := 0, len(trace)-1; i < j; i, j = i+1, j-1 {
+		trace[i], trace[j] = trace[j], trace[i]
+	}
+	return trace
 }
 
 func unwrapReturnValue(evaluated object.Object) object.Object {
@@ -456,6 +1372,10 @@ func newError(format string, a ...interface{}) *object.Error {
 	return &object.Error{Message: fmt.Sprintf(format, a...)}
 }
 
+func newKindError(kind string, format string, a ...interface{}) *object.Error {
+	return &object.Error{Kind: kind, Message: fmt.Sprintf(format, a...)}
+}
+
 func isError(obj object.Object) bool {
 	if obj != nil {
 		return obj.Type() == object.ERROR_OBJ
@@ -463,7 +1383,16 @@ func isError(obj object.Object) bool {
 	return false
 }
 
-func isTruthy(obj object.Object) bool {
+// isFatal reports whether obj is an error that stops the script, skipping
+// catch and finally blocks.
+func isFatal(obj object.Object) bool {
+	err, ok := obj.(*object.Error)
+	return ok && err.Fatal
+}
+
+// IsTruthy reports whether obj counts as true in a condition. Only null and
+// false don't.
+func IsTruthy(obj object.Object) bool {
 	switch obj {
 	case NULL:
 		return false
@@ -476,6 +1405,35 @@ func isTruthy(obj object.Object) bool {
 	}
 }
 
+func isNumber(obj object.Object) bool {
+	return obj.Type() == object.INTEGER_OBJ || obj.Type() == object.FLOAT_OBJ
+}
+
+func toFloat(obj object.Object) float64 {
+	switch obj := obj.(type) {
+	case *object.Integer:
+		return float64(obj.Value)
+	case *object.BigInt:
+		value, _ := new(big.Float).SetInt(obj.Value).Float64()
+		return value
+	case *object.Float:
+		return obj.Value
+	default:
+		return math.NaN()
+	}
+}
+
+func toBigInt(obj object.Object) *big.Int {
+	switch obj := obj.(type) {
+	case *object.Integer:
+		return big.NewInt(obj.Value)
+	case *object.BigInt:
+		return obj.Value
+	default:
+		return new(big.Int)
+	}
+}
+
 func newString(value string) *object.String {
 	return &object.String{Value: value}
 }
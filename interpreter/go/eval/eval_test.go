@@ -1,10 +1,20 @@
-package eval
+package eval_test
 
 import (
+	"context"
+	"fmt"
+	"kaze/ast"
+	"kaze/compiler"
+	"kaze/eval"
 	"kaze/lexer"
 	"kaze/object"
 	"kaze/parser"
+	"kaze/resolver"
+	"kaze/vm"
+	"strings"
+	"sync"
 	"testing"
+	"time"
 )
 
 func testIntegerObject(t *testing.T, evaluated object.Object, expected int64) {
@@ -18,6 +28,17 @@ func testIntegerObject(t *testing.T, evaluated object.Object, expected int64) {
 	}
 }
 
+func testFloatObject(t *testing.T, evaluated object.Object, expected float64) {
+	result, ok := evaluated.(*object.Float)
+	if !ok {
+		t.Fatalf("object is not Float. got=%T (%+v)", evaluated, evaluated)
+	}
+
+	if result.Value != expected {
+		t.Fatalf("object has wrong value. got=%g, want=%g", result.Value, expected)
+	}
+}
+
 func testBooleanObject(t *testing.T, evaluated object.Object, expected bool) {
 	result, ok := evaluated.(*object.Boolean)
 	if !ok {
@@ -40,13 +61,105 @@ func testStringObject(t *testing.T, evaluated object.Object, expected string) {
 	}
 }
 
-func testEval(input string) object.Object {
+// testEval evaluates input with the tree-walking evaluator and checks that
+// the bytecode VM gets the same result, so each test here also checks that
+// the two backends agree.
+func testEval(t *testing.T, input string) object.Object {
+	t.Helper()
+	return testEvalEnv(t, input, object.NewEnvironment)
+}
+
+// testEvalEnv is testEval with environments made by newEnv, one for each
+// backend.
+func testEvalEnv(t *testing.T, input string, newEnv func() *object.Environment) object.Object {
+	t.Helper()
 	l := lexer.New(input)
 	p := parser.New(l)
 	program := p.ParseProgram()
-	env := object.NewEnvironment()
+	resolver.Resolve(program, nil)
+
+	evaluated := eval.Eval(program, newEnv())
 
-	return Eval(program, env)
+	c := compiler.New()
+	if err := c.Compile(program); err != nil {
+		t.Errorf("%q: compile error: %s", input, err)
+		return evaluated
+	}
+	result := vm.New(c.Bytecode(), newEnv()).Run()
+	if diff := compareObjects(evaluated, result); diff != "" {
+		t.Errorf("%q: the VM disagrees with the evaluator: %s", input, diff)
+	}
+
+	return evaluated
+}
+
+// compareObjects describes how the result of the VM differs from the
+// result of the evaluator, or returns "" if they are the same.
+func compareObjects(expected, actual object.Object) string {
+	// Statements without a value evaluate to nil.
+	if expected == nil {
+		expected = eval.NULL
+	}
+	if actual == nil {
+		actual = eval.NULL
+	}
+
+	switch expected := expected.(type) {
+	case *object.Error:
+		actual, ok := actual.(*object.Error)
+		if !ok {
+			return fmt.Sprintf("expected error %q, got %s", expected.Message, actual.Inspect())
+		}
+		if expected.Kind != actual.Kind || expected.Message != actual.Message {
+			return fmt.Sprintf("expected %s: %s, got %s: %s", expected.Kind, expected.Message, actual.Kind, actual.Message)
+		}
+		if expected.Span != actual.Span {
+			return fmt.Sprintf("expected error at %v, got %v", expected.Span, actual.Span)
+		}
+		if fmt.Sprint(expected.Stack) != fmt.Sprint(actual.Stack) {
+			return fmt.Sprintf("expected stack %v, got %v", expected.Stack, actual.Stack)
+		}
+	case *object.Exception:
+		actual, ok := actual.(*object.Exception)
+		if !ok {
+			return fmt.Sprintf("expected EXCEPTION, got %s", actual.Type())
+		}
+		return compareObjects(expected.Error, actual.Error)
+	case *object.Function:
+		actual, ok := actual.(*object.Closure)
+		if !ok || actual.Fn.Name != expected.Name {
+			return fmt.Sprintf("expected function %q, got %s", expected.Name, actual.Inspect())
+		}
+	case *object.Array:
+		actual, ok := actual.(*object.Array)
+		if !ok || len(actual.Elements) != len(expected.Elements) {
+			return fmt.Sprintf("expected %s, got %s", expected.Inspect(), actual.Inspect())
+		}
+		for i, element := range expected.Elements {
+			if diff := compareObjects(element, actual.Elements[i]); diff != "" {
+				return fmt.Sprintf("element %d: %s", i, diff)
+			}
+		}
+	case *object.Hash:
+		actual, ok := actual.(*object.Hash)
+		if !ok || len(actual.Pairs) != len(expected.Pairs) {
+			return fmt.Sprintf("expected %s, got %s", expected.Inspect(), actual.Inspect())
+		}
+		for key, pair := range expected.Pairs {
+			actualPair, ok := actual.Pairs[key]
+			if !ok {
+				return fmt.Sprintf("missing key %s", pair.Key.Inspect())
+			}
+			if diff := compareObjects(pair.Value, actualPair.Value); diff != "" {
+				return fmt.Sprintf("key %s: %s", pair.Key.Inspect(), diff)
+			}
+		}
+	default:
+		if expected.Type() != actual.Type() || expected.Inspect() != actual.Inspect() {
+			return fmt.Sprintf("expected %s %s, got %s %s", expected.Type(), expected.Inspect(), actual.Type(), actual.Inspect())
+		}
+	}
+	return ""
 }
 
 func TestEvalIntegerExpression(t *testing.T) {
@@ -72,7 +185,165 @@ func TestEvalIntegerExpression(t *testing.T) {
 	}
 
 	for _, tt := range tests {
-		evaluated := testEval(tt.input)
+		evaluated := testEval(t, tt.input)
+		testIntegerObject(t, evaluated, tt.expected)
+	}
+}
+
+func TestEvalFloatExpression(t *testing.T) {
+	tests := []struct {
+		input    string
+		expected float64
+	}{
+		{"3.14", 3.14},
+		{"-2.5", -2.5},
+		{"1.5 + 1.5", 3},
+		{"1 + 0.5", 1.5},
+		{"0.5 + 1", 1.5},
+		{"7 / 2.0", 3.5},
+		{"2.5 * 2", 5},
+		{"1 - 0.25", 0.75},
+		{"float(3)", 3},
+		{`float("1.25")`, 1.25},
+		{"float(2.5)", 2.5},
+	}
+
+	for _, tt := range tests {
+		evaluated := testEval(t, tt.input)
+		testFloatObject(t, evaluated, tt.expected)
+	}
+}
+
+func TestFloatConversions(t *testing.T) {
+	testIntegerObject(t, testEval(t, "int(3.99)"), 3)
+	testIntegerObject(t, testEval(t, "int(-3.99)"), -3)
+	testStringObject(t, testEval(t, "string(2.5)"), "2.5")
+	testStringObject(t, testEval(t, "string(3.0)"), "3.0")
+	testStringObject(t, testEval(t, "string(1.0 / 0)"), "+Inf")
+	if evaluated := testEval(t, "int(1.0 / 0)"); evaluated != eval.NAN {
+		t.Fatalf("int(+Inf) is not NaN. got=%T (%+v)", evaluated, evaluated)
+	}
+}
+
+func TestEvalBitwiseExpression(t *testing.T) {
+	tests := []struct {
+		input    string
+		expected int64
+	}{
+		{"7 % 2", 1},
+		{"-7 % 2", -1},
+		{"7 % -2", 1},
+		{"12 & 10", 8},
+		{"12 | 10", 14},
+		{"12 ^ 10", 6},
+		{"~0", -1},
+		{"~5", -6},
+		{"1 << 10", 1024},
+		{"1024 >> 3", 128},
+		{"-16 >> 2", -4},
+		{"-1 >> 100", -1},
+		{"1 >> 100", 0},
+		{"1 + 2 << 3", 24},
+		{"var i = 0; var n = 0; while i < 10 { if i % 2 == 0 { n = n + 1; } i = i + 1; } n", 5},
+		{"(1 << 70) >> 68", 4},
+		{"(1 << 64) % 7", 2},
+		{"~(1 << 64) + (1 << 64)", -1},
+		{"((1 << 64) | 1) & 3", 1},
+		{"((1 << 64) ^ (1 << 64))", 0},
+		// The same rules apply to integers of either size.
+		{"1 >> 70000", 0},
+		{"(1 << 64) >> 70000", 0},
+		{"-1 >> 70000", -1},
+		{"-(1 << 64) >> 70000", -1},
+		{"5 >> (1 << 64)", 0},
+		{"-(1 << 64) >> (1 << 64)", -1},
+		{"0 << 70000", 0},
+		{"0 << (1 << 64)", 0},
+		{"(1 << 64) >> 63", 2},
+		{"-(1 << 64) >> 65", -1},
+	}
+
+	for _, tt := range tests {
+		evaluated := testEval(t, tt.input)
+		testIntegerObject(t, evaluated, tt.expected)
+	}
+
+	testFloatObject(t, testEval(t, "7.5 % 2"), 1.5)
+
+	for _, input := range []string{"1.5 & 1", "~true", "6 & 3 == 2"} {
+		if _, ok := testEval(t, input).(*object.Error); !ok {
+			t.Errorf("%s: expected an error, got=%+v", input, testEval(t, input))
+		}
+	}
+
+	shiftErrors := []struct {
+		input   string
+		message string
+	}{
+		{"1 << -1", "negative shift count: -1"},
+		{"(1 << 64) << -1", "negative shift count: -1"},
+		{"1 >> -1", "negative shift count: -1"},
+		{"(1 << 64) >> -(1 << 64)", "negative shift count: -18446744073709551616"},
+		{"1 << 70000", "shift count too large: 70000"},
+		{"(1 << 64) << 70000", "shift count too large: 70000"},
+		{"-1 << (1 << 64)", "shift count too large: 18446744073709551616"},
+	}
+	for _, tt := range shiftErrors {
+		err, ok := testEval(t, tt.input).(*object.Error)
+		if !ok {
+			t.Errorf("%s: expected an error", tt.input)
+			continue
+		}
+		if err.Kind != object.VALUE_ERROR || err.Message != tt.message {
+			t.Errorf("%s: wrong error. expected=%s: %s, got=%s: %s", tt.input, object.VALUE_ERROR, tt.message, err.Kind, err.Message)
+		}
+	}
+}
+
+func TestBigIntPromotion(t *testing.T) {
+	tests := []struct {
+		input    string
+		expected string
+	}{
+		{"9223372036854775807 + 1", "9223372036854775808"},
+		{"-9223372036854775807 - 2", "-9223372036854775809"},
+		{"4294967296 * 4294967296", "18446744073709551616"},
+		{"-(-9223372036854775807 - 1)", "9223372036854775808"},
+		{"(-9223372036854775807 - 1) / -1", "9223372036854775808"},
+		{"99999999999999999999999", "99999999999999999999999"},
+		{"1 << 64", "18446744073709551616"},
+		{"3 << 62", "13835058055282163712"},
+		{"fun fact(n) { if n == 0 { return 1; } return n * fact(n - 1); } fact(25)", "15511210043330985984000000"},
+		{`int("123456789012345678901234567890")`, "123456789012345678901234567890"},
+		{"int(100000000000000000000.0)", "100000000000000000000"},
+	}
+
+	for _, tt := range tests {
+		evaluated := testEval(t, tt.input)
+		result, ok := evaluated.(*object.BigInt)
+		if !ok {
+			t.Fatalf("%s: object is not BigInt. got=%T (%+v)", tt.input, evaluated, evaluated)
+		}
+		if result.Inspect() != tt.expected {
+			t.Fatalf("%s: object has wrong value. got=%s, want=%s", tt.input, result.Inspect(), tt.expected)
+		}
+	}
+}
+
+func TestBigIntDemotion(t *testing.T) {
+	tests := []struct {
+		input    string
+		expected int64
+	}{
+		{"9223372036854775808 - 1", 9223372036854775807},
+		{"(9223372036854775807 + 1) / 2", 4611686018427387904},
+		{"99999999999999999999 - 99999999999999999998", 1},
+		{"-9223372036854775808", -9223372036854775808},
+		{"var h = #{9223372036854775807: 1}; h[9223372036854775808 - 1]", 1},
+	}
+
+	for _, tt := range tests {
+		evaluated := testEval(t, tt.input)
 		testIntegerObject(t, evaluated, tt.expected)
 	}
 }
@@ -148,10 +419,22 @@ func TestEvalBooleanExpression(t *testing.T) {
 		{`[1,2,3] != [1,2,3]`, false},
 		{`null == null`, true},
 		{`null != null`, false},
+		{"1.5 < 2", true},
+		{"2 > 1.5", true},
+		{"1 == 1.0", true},
+		{"1.0 != 1", false},
+		{"0.1 + 0.2 == 0.3", false},
+		{"2.5 >= 2.5", true},
+		{"9223372036854775808 > 9223372036854775807", true},
+		{"-9223372036854775809 < -9223372036854775808", true},
+		{"9223372036854775808 == 9223372036854775807 + 1", true},
+		{"9223372036854775808 != 9223372036854775808", false},
+		{"9223372036854775808 > 1.5", true},
+		{`#{9223372036854775808: true}[9223372036854775807 + 1]`, true},
 	}
 
 	for _, tt := range tests {
-		evaluated := testEval(tt.input)
+		evaluated := testEval(t, tt.input)
 		testBooleanObject(t, evaluated, tt.expected)
 	}
 }
@@ -168,15 +451,69 @@ func TestEvalStringExpression(t *testing.T) {
 		{`var a = "hoge"; var b = 0; a[b]`, "h"},
 		{`fun greet(name) { "Hello, " + name + "!"; }; greet("Alice");`, "Hello, Alice!"},
 		{`fun greet(name) { "Hello, " + name + "!"; }; fun add(x,y){x+y}; greet("Alice")[add(3,4)];`, "A"},
+		{`"a\tb"`, "a\tb"},
+		{`var x = 1; var y = "two"; "expected ${x}, got ${y}"`, "expected 1, got two"},
+		{`"${1 + 2}${[1, 2]}${null}${true}"`, "3[ 1, 2 ]nulltrue"},
+		{`var h = #{"k": "v"}; "${h["k"]}!"`, "v!"},
+		{`"\${x}"`, "${x}"},
+		{`"line\n"[4]`, "\n"},
+		{"`C:\\path\\` + `\n`", "C:\\path\\\n"},
 	}
 
 	for _, tt := range tests {
-		evaluated := testEval(tt.input)
+		evaluated := testEval(t, tt.input)
 		testStringObject(t, evaluated, tt.expected)
 	}
 }
 
 // BANG operator
+func TestLogicalOperatorsShortCircuit(t *testing.T) {
+	tests := []struct {
+		input    string
+		expected interface{}
+	}{
+		{"1 && 2", 2},
+		{"0 && 2", 2},
+		{"null && 2", eval.NULL},
+		{"false || 5", 5},
+		{"null || \"default\"", "default"},
+		{"\"set\" || \"default\"", "set"},
+		{"var x = null; x != null && x[\"k\"]", false},
+		{"var h = #{\"k\": 1}; h != null && h[\"k\"]", 1},
+		{"var n = 0; fun inc() { n = n + 1; return true; } false && inc(); true || inc(); n", 0},
+		{"var n = 0; fun inc() { n = n + 1; return true; } true && inc(); false || inc(); n", 2},
+		{"false || undefinedName && 1", "identifier not found: undefinedName"},
+		{"true || undefinedName", true},
+	}
+
+	for _, tt := range tests {
+		evaluated := testEval(t, tt.input)
+		switch expected := tt.expected.(type) {
+		case int:
+			testIntegerObject(t, evaluated, int64(expected))
+		case bool:
+			testBooleanObject(t, evaluated, expected)
+		case object.Object:
+			if evaluated != expected {
+				t.Errorf("%s: expected=%+v, got=%+v", tt.input, expected, evaluated)
+			}
+		case string:
+			switch obj := evaluated.(type) {
+			case *object.String:
+				if obj.Value != expected {
+					t.Errorf("%s: expected=%q, got=%q", tt.input, expected, obj.Value)
+				}
+			case *object.Error:
+				if obj.Message != expected {
+					t.Errorf("%s: expected error %q, got=%q", tt.input, expected, obj.Message)
+				}
+			default:
+				t.Errorf("%s: unexpected object %T (%+v)", tt.input, obj, obj)
+			}
+		}
+	}
+}
+
 func TestBangOperator(t *testing.T) {
 	tests := []struct {
 		input    string
@@ -191,7 +528,7 @@ func TestBangOperator(t *testing.T) {
 	}
 
 	for _, tt := range tests {
-		evaluated := testEval(tt.input)
+		evaluated := testEval(t, tt.input)
 		testBooleanObject(t, evaluated, tt.expected)
 	}
 }
@@ -208,7 +545,7 @@ func TestIdentifier(t *testing.T) {
 	}
 
 	for _, tt := range tests {
-		evaluated := testEval(tt.input)
+		evaluated := testEval(t, tt.input)
 		testIntegerObject(t, evaluated, tt.expected)
 	}
 }
@@ -224,7 +561,7 @@ func TestBlockExpression(t *testing.T) {
 	}
 
 	for _, tt := range tests {
-		evaluated := testEval(tt.input)
+		evaluated := testEval(t, tt.input)
 		testIntegerObject(t, evaluated, tt.expected)
 	}
 }
@@ -242,7 +579,7 @@ func TestReturnStatements(t *testing.T) {
 	}
 
 	for _, tt := range tests {
-		evaluated := testEval(tt.input)
+		evaluated := testEval(t, tt.input)
 		testIntegerObject(t, evaluated, tt.expected)
 	}
 }
@@ -267,27 +604,172 @@ func TestFunctions(t *testing.T) {
 	}
 
 	for _, tt := range tests {
-		evaluated := testEval(tt.input)
+		evaluated := testEval(t, tt.input)
 		testIntegerObject(t, evaluated, tt.expected)
 	}
 }
 
+func TestFunctionLiterals(t *testing.T) {
+	tests := []struct {
+		input    string
+		expected int64
+	}{
+		{"var add = fun (a, b) { return a + b; }; add(1, 2);", 3},
+		{"var add = (a, b) => a + b; add(3, 4);", 7},
+		{"((x) => x * x)(5)", 25},
+		{"fun (x) { x + 1 }(1)", 2},
+		{"var zero = () => 0; zero()", 0},
+		{"fun apply(f, x) { f(x); } apply((x) => x * 10, 4)", 40},
+		{"fun adder(n) { (x) => x + n } var add2 = adder(2); add2(40)", 42},
+		{"fun counter() { var n = 0; fun () { n = n + 1; n } } var c = counter(); c(); c(); c()", 3},
+		{"var h = #{\"next\": (x) => x + 1}; h[\"next\"](1)", 2},
+	}
+
+	for _, tt := range tests {
+		evaluated := testEval(t, tt.input)
+		testIntegerObject(t, evaluated, tt.expected)
+	}
+
+	evaluated := testEval(t, "var f = (a) => a; f(1, 2)")
+	err, ok := evaluated.(*object.Error)
+	if !ok {
+		t.Fatalf("object is not Error. got=%T (%+v)", evaluated, evaluated)
+	}
+	if err.Message != "wrong number of arguments to anonymous function: got=2, want=1" {
+		t.Errorf("wrong error message. got=%q", err.Message)
+	}
+}
+
+func TestClosures(t *testing.T) {
+	tests := []struct {
+		input    string
+		expected int64
+	}{
+		{"fun f() { var n = 1; var get = () => n; n = 2; get() } f()", 2},
+		{"fun f() { var n = 0; var inc = () => { n += 1 }; inc(); inc(); n } f()", 2},
+		{"fun f() { var n = 0; [() => { n += 1 }, () => n] } var p = f(); p[0](); p[0](); p[1]()", 2},
+		{"fun f(a) { (b) => (c) => a * 100 + b * 10 + c } f(1)(2)(3)", 123},
+		{"fun f() { fun g(n) { if n == 0 { 0 } else { 1 + g(n - 1) } } g(5) } f()", 5},
+		{"fun f() { var fs = []; var i = 0; while i < 3 { var j = i; fs = append(fs, () => j); i += 1; } fs[0]() + fs[2]() * 10 } f()", 20},
+		{"fun f() { var fs = []; for i in 0..5 { if i % 2 == 0 { continue } fs = append(fs, () => i) } fs[0]() * 10 + fs[1]() } f()", 13},
+		{"fun f() { var g = null; for i in 0..5 { g = () => i; if i == 2 { break } } g() } f()", 2},
+		{"fun f() { var g = null; try { var x = 7; g = () => x; throw \"e\" } catch { } { var y = 1; } g() } f()", 7},
+		{"{ var a = 1; var f = () => a; { var b = 2; } a = 3; f() }", 3},
+		{"fun f(a, b = () => a) { a = 5; b() } f(1)", 5},
+		// A closure sees every name declared in the blocks around it, even
+		// those declared after it.
+		{"var x = 1; { fun f() { x } var x = 2; f() }", 2},
+		{"fun f(x) { var g = () => x; var x = 5; g() * 10 + x } f(1)", 55},
+		{"var x = 1; fun f() { x } x = 4; f()", 4},
+		{"fun f() { var g = fun() { h() }; var h = fun() { 7 }; g() } f()", 7},
+		{`fun f(n) {
+			fun isEven(n) { if n == 0 { 1 } else { isOdd(n - 1) } }
+			fun isOdd(n) { if n == 0 { 0 } else { isEven(n - 1) } }
+			isEven(n) * 10 + isOdd(n)
+		}
+		f(7)`, 1},
+		// Outside a closure, a name is visible from its declaration on.
+		{"var x = 1; { var x = x + 1; x }", 2},
+	}
+
+	for _, tt := range tests {
+		evaluated := testEval(t, tt.input)
+		testIntegerObject(t, evaluated, tt.expected)
+	}
+}
+
+func TestDefaultAndRestParameters(t *testing.T) {
+	tests := []struct {
+		input    string
+		expected interface{}
+	}{
+		{"fun f(a, b = 10) { a + b } f(1)", 11},
+		{"fun f(a, b = 10) { a + b } f(1, 2)", 3},
+		{"fun f(a, b = a * 2) { a + b } f(5)", 15},
+		{"var n = 0; fun f(a = n) { a } n = 7; f()", 7},
+		{"var f = (a, b = 3) => a * b; f(2)", 6},
+		{"fun f(...rest) { len(rest) } f()", 0},
+		{"fun f(a, ...rest) { len(rest) } f(1, 2, 3)", 2},
+		{"fun f(a, ...rest) { rest[1] } f(1, 2, 3)", 3},
+		{"var f = (...xs) => len(xs); f(1, 2, 3, 4)", 4},
+		{"fun add(a, b, c) { a + b + c } var xs = [1, 2, 3]; add(...xs)", 6},
+		{"fun add(a, b, c) { a + b + c } add(1, ...[2, 3])", 6},
+		{"fun f(a, ...rest) { len(rest) } f(...[1, 2], ...[3, 4])", 3},
+		{"var xs = [2, 3]; var ys = [1, ...xs, 4, ...[]]; len(ys)", 4},
+		{"var xs = [2, 3]; [1, ...xs, 4][2]", 3},
+		{"fun f(a = undefinedName) { a } f()", "identifier not found: undefinedName"},
+		{"fun f(a) { a } f(...1)", "cannot spread INTEGER"},
+		{"...[1]", "spread is only allowed in calls and array literals"},
+		{"fun f(a, b = 1) { a } f()", "wrong number of arguments to f: got=0, want=1..2"},
+		{"fun f(a, b = 1) { a } f(1, 2, 3)", "wrong number of arguments to f: got=3, want=1..2"},
+		{"fun f(a, ...rest) { a } f()", "wrong number of arguments to f: got=0, want>=1"},
+	}
+
+	for _, tt := range tests {
+		evaluated := testEval(t, tt.input)
+		switch expected := tt.expected.(type) {
+		case int:
+			testIntegerObject(t, evaluated, int64(expected))
+		case string:
+			err, ok := evaluated.(*object.Error)
+			if !ok {
+				t.Fatalf("object is not Error. got=%T (%+v)", evaluated, evaluated)
+			}
+			if err.Message != expected {
+				t.Errorf("wrong error message. expected=%q, got=%q", expected, err.Message)
+			}
+		}
+	}
+}
+
+func TestFunctionArity(t *testing.T) {
+	tests := []struct {
+		input           string
+		expectedMessage string
+	}{
+		{"fun add(a, b) { a + b; } add(1);", "wrong number of arguments to add: got=1, want=2"},
+		{"fun add(a, b) { a + b; } add(1, 2, 3);", "wrong number of arguments to add: got=3, want=2"},
+		{"fun f() { 1; } f(1);", "wrong number of arguments to f: got=1, want=0"},
+		{"var g = 1; g(1);", "not a function: INTEGER"},
+	}
+
+	for _, tt := range tests {
+		evaluated := testEval(t, tt.input)
+		err, ok := evaluated.(*object.Error)
+		if !ok {
+			t.Fatalf("object is not Error. got=%T (%+v)", evaluated, evaluated)
+		}
+		if err.Message != tt.expectedMessage {
+			t.Errorf("wrong error message. expected=%q, got=%q", tt.expectedMessage, err.Message)
+		}
+	}
+
+	evaluated := testEval(t, "fun add(a, b) { a + b; } add;")
+	fn, ok := evaluated.(*object.Function)
+	if !ok {
+		t.Fatalf("object is not Function. got=%T (%+v)", evaluated, evaluated)
+	}
+	if fn.Name != "add" {
+		t.Errorf("function has wrong name. expected=add, got=%q", fn.Name)
+	}
+}
+
 func TestIfExpressions(t *testing.T) {
 	tests := []struct {
 		input    string
 		expected interface{}
 	}{
 		{"if true { 10; }", 10},
-		{"if false { 10; }", NULL},
+		{"if false { 10; }", eval.NULL},
 		{"if 1 { 10; }", 10},
 		{"if 1 < 2 { 10; }", 10},
-		{"if 1 > 2 { 10; }", NULL},
+		{"if 1 > 2 { 10; }", eval.NULL},
 		{"if 1 > 2 { 10; } else { 20; }", 20},
 		{"if 1 < 2 { 10; } else { 20; }", 10},
 	}
 
 	for _, tt := range tests {
-		evaluated := testEval(tt.input)
+		evaluated := testEval(t, tt.input)
 		switch v := tt.expected.(type) {
 		case int:
 			testIntegerObject(t, evaluated, int64(v))
@@ -314,11 +796,126 @@ func TestWhileStatement(t *testing.T) {
 	}
 
 	for _, tt := range tests {
-		evaluated := testEval(tt.input)
+		evaluated := testEval(t, tt.input)
 		testIntegerObject(t, evaluated, tt.expected)
 	}
 }
 
+func TestForStatement(t *testing.T) {
+	tests := []struct {
+		input    string
+		expected interface{}
+	}{
+		{"var s = 0; for x in [1, 2, 3] { s = s + x; } s", 6},
+		{"var s = 0; for i, x in [10, 20, 30] { s = s + i * x; } s", 80},
+		{"var s = \"\"; for c in \"abc\" { s = c + s; } s", "cba"},
+		{"var s = 0; for i, c in \"abc\" { s = s + i; } s", 3},
+		{"var s = 0; for i in 0..5 { s = s + i; } s", 10},
+		{"var s = 0; for i in 5..0 { s = s + 1; } s", 0},
+		{"var s = 0; for i, n in 3..6 { s = s + i * n; } s", 14},
+		{"var s = \"\"; for k in #{\"b\": 2, \"a\": 1} { s = s + k; } s", "ab"},
+		{"var s = \"\"; for k, v in #{\"b\": 2, \"a\": 1} { s = s + k + string(v); } s", "a1b2"},
+		{"var h = #{}; for i in 0..12 { h[11 - i] = i; } var s = \"\"; for k in h { s = s + \"${k} \"; } s", "0 1 2 3 4 5 6 7 8 9 10 11 "},
+		{"var s = \"\"; for k in #{10: 0, -1: 0, 9223372036854775807 + 1: 0, 2: 0, \"b\": 0, \"a\": 0} { s = s + \"${k} \"; } s", "-1 2 10 9223372036854775808 a b "},
+		{"var s = 0; for i in 0..10 { if i == 3 { break; } s = s + i; } s", 3},
+		{"var s = 0; for i in 0..10 { if i % 2 == 0 { continue; } s = s + i; } s", 25},
+		{"fun f() { for x in [1, 2, 3] { if x == 2 { return x * 100; } } 0 } f()", 200},
+		{"var fs = []; for i in 0..3 { fs = append(fs, () => i); } fs[0]() + fs[1]() * 10 + fs[2]() * 100", 210},
+		{"var i = 42; for i in 0..3 {} i", 42},
+		{"for x in 5 {}", "cannot iterate over INTEGER"},
+		{"for x in 0..true {}", "range bounds must be integers, got INTEGER..BOOLEAN"},
+		{"for x in [1] { y; }", "identifier not found: y"},
+	}
+
+	for _, tt := range tests {
+		evaluated := testEval(t, tt.input)
+		switch expected := tt.expected.(type) {
+		case int:
+			testIntegerObject(t, evaluated, int64(expected))
+		case string:
+			switch obj := evaluated.(type) {
+			case *object.String:
+				if obj.Value != expected {
+					t.Errorf("%s: expected=%q, got=%q", tt.input, expected, obj.Value)
+				}
+			case *object.Error:
+				if obj.Message != expected {
+					t.Errorf("%s: expected error %q, got=%q", tt.input, expected, obj.Message)
+				}
+			default:
+				t.Errorf("%s: unexpected object %T (%+v)", tt.input, obj, obj)
+			}
+		}
+	}
+}
+
+func TestRangesAndSlices(t *testing.T) {
+	tests := []struct {
+		input    string
+		expected interface{}
+	}{
+		{"0..5", "0..5"},
+		{"1..=3", "1..=3"},
+		{"var s = 0; for i in 1..=4 { s = s + i; } s", 10},
+		{"var s = 0; for i in 3..=3 { s = s + i; } s", 3},
+		{"var n = 0; for i in 9223372036854775806..=9223372036854775807 { n = n + 1; } n", 2},
+		{"var r = 0..3; var s = 0; for i in r { s = s + i; } for i in r { s = s + i; } s", 6},
+		{"[...0..4]", "[0, 1, 2, 3]"},
+		{"[...1..=3, 4]", "[1, 2, 3, 4]"},
+		{"[1, 2, 3, 4, 5][1:3]", "[2, 3]"},
+		{"[1, 2, 3, 4, 5][:2]", "[1, 2]"},
+		{"[1, 2, 3, 4, 5][3:]", "[4, 5]"},
+		{"[1, 2, 3, 4, 5][:]", "[1, 2, 3, 4, 5]"},
+		{"[1, 2, 3, 4, 5][-2:]", "[4, 5]"},
+		{"[1, 2, 3, 4, 5][:-1]", "[1, 2, 3, 4]"},
+		{"[1, 2, 3, 4, 5][-100:100]", "[1, 2, 3, 4, 5]"},
+		{"[1, 2, 3, 4, 5][4:1]", "[]"},
+		{"[1, 2, 3, 4, 5][1..3]", "[2, 3]"},
+		{"[1, 2, 3, 4, 5][1..=3]", "[2, 3, 4]"},
+		{"[1, 2, 3, 4, 5][-2..=-1]", "[4, 5]"},
+		{"\"hello world\"[:5]", "hello"},
+		{"\"hello world\"[-5:]", "world"},
+		{"\"hello\"[1:-1]", "ell"},
+		{"\"hello\"[0..=1]", "he"},
+		{"var xs = [1, 2, 3]; var ys = xs[:]; ys[0] = 9; xs[0]", 1},
+		{"5[1:2]", "slice operator not supported: INTEGER"},
+		{"[1][\"a\":]", "slice bounds must be integers, got STRING"},
+		{"1..\"a\"", "range bounds must be integers, got INTEGER..STRING"},
+		{"1..=true", "range bounds must be integers, got INTEGER..=BOOLEAN"},
+		{"0..(9223372036854775807 + 1)", "range bound out of range: 9223372036854775808"},
+		{"-(9223372036854775807 + 2)..=0", "range bound out of range: -9223372036854775809"},
+		{"[1, 2, 3][1:(9223372036854775807 + 1)]", "[2, 3]"},
+		{"\"hello\"[-(9223372036854775807 + 2):2]", "he"},
+	}
+
+	for _, tt := range tests {
+		evaluated := testEval(t, tt.input)
+		switch expected := tt.expected.(type) {
+		case int:
+			testIntegerObject(t, evaluated, int64(expected))
+		case string:
+			var got string
+			switch obj := evaluated.(type) {
+			case *object.String:
+				got = obj.Value
+			case *object.Error:
+				got = obj.Message
+			case *object.Array:
+				var elements []string
+				for _, el := range obj.Elements {
+					elements = append(elements, el.Inspect())
+				}
+				got = "[" + strings.Join(elements, ", ") + "]"
+			default:
+				got = obj.Inspect()
+			}
+			if got != expected {
+				t.Errorf("%s: expected=%q, got=%q", tt.input, expected, got)
+			}
+		}
+	}
+}
+
 func TestHashLiterals(t *testing.T) {
 	input := `var two = "two";
 	#{
@@ -330,7 +927,7 @@ func TestHashLiterals(t *testing.T) {
 		false: 6
 	}`
 
-	evaluated := testEval(input)
+	evaluated := testEval(t, input)
 	result, ok := evaluated.(*object.Hash)
 	if !ok {
 		t.Fatalf("Eval didn't return Hash. got=%T (%+v)", evaluated, evaluated)
@@ -341,8 +938,8 @@ func TestHashLiterals(t *testing.T) {
 		(&object.String{Value: "two"}).HashKey():   2,
 		(&object.String{Value: "three"}).HashKey(): 3,
 		(&object.Integer{Value: 4}).HashKey():      4,
-		TRUE.HashKey():                             5,
-		FALSE.HashKey():                            6,
+		eval.TRUE.HashKey():                        5,
+		eval.FALSE.HashKey():                       6,
 	}
 
 	if len(result.Pairs) != len(expected) {
@@ -364,15 +961,16 @@ func TestHashIndexExpressions(t *testing.T) {
 		expected interface{}
 	}{
 		{`#{"foo": 5}["foo"]`, 5},
-		{`#{"foo": 5}["bar"]`, NULL},
+		{`#{"foo": 5}["bar"]`, eval.NULL},
 		{`var key = "foo"; #{"foo": 5}[key]`, 5},
-		{`#{"foo": 5}[5]`, NULL},
-		{`#{"foo": 5}[true]`, NULL},
-		{`#{"foo": 5}[false]`, NULL},
+		{`#{"foo": 5}[5]`, eval.NULL},
+		{`#{"foo": 5}[true]`, eval.NULL},
+		{`#{"foo": 5}[false]`, eval.NULL},
+		{`var h = #{9223372036854775807 + 1: 1, -590260884831411150: 2}; len(h) * 10 + h[9223372036854775807 + 1]`, 21},
 	}
 
 	for _, tt := range tests {
-		evaluated := testEval(tt.input)
+		evaluated := testEval(t, tt.input)
 		switch v := tt.expected.(type) {
 		case int:
 			testIntegerObject(t, evaluated, int64(v))
@@ -399,14 +997,84 @@ func TestHashAssignment(t *testing.T) {
 	}
 
 	for _, tt := range tests {
-		evaluated := testEval(tt.input)
+		evaluated := testEval(t, tt.input)
 		testIntegerObject(t, evaluated, tt.expected)
 	}
 }
 
+func TestCompoundAssignment(t *testing.T) {
+	tests := []struct {
+		input    string
+		expected interface{}
+	}{
+		{"var i = 1; i += 2; i", 3},
+		{"var i = 1; i -= 2; i", -1},
+		{"var i = 3; i *= 4; i", 12},
+		{"var i = 13; i /= 4; i", 3},
+		{"var i = 13; i %= 4; i", 1},
+		{"var i = 1; i += 2", 3},
+		{"var s = \"a\"; s += \"b\"; s", "ab"},
+		{"var xs = [1, 2]; xs[1] += 10; xs[1]", 12},
+		{"var h = #{\"a\": 1}; h[\"a\"] *= 5; h[\"a\"]", 5},
+		{"var m = [[1, 2], [3, 4]]; m[1][0] -= 1; m[1][0]", 2},
+		{"var n = 0; var h = #{\"k\": 0}; fun f() { n += 1; \"k\" } h[f()] += 1; h[f()] += 1; n * 10 + h[\"k\"]", 22},
+		{"var n = 0; var xs = [[0]]; fun f() { n += 1; 0 } xs[f()][f()] += 5; n * 10 + xs[0][0]", 25},
+		{"var x = 1; fun g() { x = 10; 1 } x += g(); x", 2},
+		{"var i = 0; for x in 0..5 { i += x; } i", 10},
+		{"var x = 1; x /= 0", "division by zero"},
+		{"var x = 1; x += true", "type mismatch: INTEGER + BOOLEAN"},
+		{"y += 1", "cannot apply += to y: no current value"},
+		{"var h = #{}; h[\"k\"] += 1", "cannot apply += to h[\"k\"]: no current value"},
+		{"var i = 1; i++; i++; i", 3},
+		{"var i = 1; i--; i", 0},
+		{"var x = 1.5; x++; int(x * 2)", 5},
+		{"fun f() { var i = 0; while i < 5 { i++ } i } f()", 5},
+		{"var n = 0; var xs = [10, 20]; fun f() { n++; 1 } xs[f()]--; xs[f()]++; xs[f()]++; n * 100 + xs[1]", 321},
+		{"var h = #{\"a\": 1}; h[\"a\"]++; h[\"a\"]", 2},
+		{"var s = \"a\"; s++", "type mismatch: STRING + INTEGER"},
+		{"y++", "cannot apply ++ to y: no current value"},
+		{"var h = #{}; h[\"k\"]--", "cannot apply -- to h[\"k\"]: no current value"},
+	}
+
+	for _, tt := range tests {
+		evaluated := testEval(t, tt.input)
+		switch expected := tt.expected.(type) {
+		case int:
+			testIntegerObject(t, evaluated, int64(expected))
+		case string:
+			switch obj := evaluated.(type) {
+			case *object.String:
+				if obj.Value != expected {
+					t.Errorf("%s: expected=%q, got=%q", tt.input, expected, obj.Value)
+				}
+			case *object.Error:
+				if obj.Message != expected {
+					t.Errorf("%s: expected error %q, got=%q", tt.input, expected, obj.Message)
+				}
+			default:
+				t.Errorf("%s: unexpected object %T (%+v)", tt.input, obj, obj)
+			}
+		}
+	}
+}
+
+func TestArgsBuiltin(t *testing.T) {
+	defer func(args []string) { eval.Args = args }(eval.Args)
+	eval.Args = []string{"kaze", "main.kz", "input.kz"}
+
+	evaluated := testEval(t, `args()[2] + " " + string(len(args()))`)
+	str, ok := evaluated.(*object.String)
+	if !ok {
+		t.Fatalf("object is not String. got=%T (%+v)", evaluated, evaluated)
+	}
+	if str.Value != "input.kz 3" {
+		t.Errorf("String has wrong value. got=%q", str.Value)
+	}
+}
+
 func TestArrayLiterals(t *testing.T) {
 	input := `[1, 2 * 2, 3 + 3]`
-	evaluated := testEval(input)
+	evaluated := testEval(t, input)
 	result, ok := evaluated.(*object.Array)
 	if !ok {
 		t.Fatalf("object is not Left. got=%T (%+v)", evaluated, evaluated)
@@ -424,7 +1092,7 @@ func TestArrayLiterals(t *testing.T) {
 func TestMultiDimensionalArrayLiterals(t *testing.T) {
 	input := `[[1, 2], [3, 4], [5, 6]][1][1]`
 
-	evaluated := testEval(input)
+	evaluated := testEval(t, input)
 	testIntegerObject(t, evaluated, 4)
 }
 
@@ -441,7 +1109,364 @@ func TestArrayAssignment(t *testing.T) {
 	}
 
 	for _, tt := range tests {
-		evaluated := testEval(tt.input)
+		evaluated := testEval(t, tt.input)
 		testIntegerObject(t, evaluated, tt.expected)
 	}
 }
+
+func TestTryCatch(t *testing.T) {
+	tests := []struct {
+		input    string
+		expected interface{}
+	}{
+		{`try { 1 } catch (e) { 2 }`, 1},
+		{`try { throw "oops"; 1 } catch (e) { 2 }`, 2},
+		{`try { throw "oops" } catch (e) { e["message"] }`, "oops"},
+		{`try { throw "oops" } catch (e) { e["kind"] }`, "Error"},
+		{`try { throw #{"kind": "ValueError", "message": "bad"} } catch (e) { e["kind"] + ": " + e["message"] }`, "ValueError: bad"},
+		{`try { 1 / 0 } catch (e) { e["kind"] }`, "ZeroDivisionError"},
+		{`try { undefinedName } catch (e) { e["kind"] }`, "NameError"},
+		{`try { [1][5] } catch (e) { e["kind"] }`, "IndexError"},
+		{`try { 1 + true } catch (e) { e["kind"] }`, "TypeError"},
+		{`try { len(1) } catch { -1 }`, -1},
+		{`try { int("x") } catch (e) { e["kind"] }`, "ValueError"},
+		{`try { int("12a") } catch (e) { e["message"] }`, `invalid integer: "12a"`},
+		{`try { throw 42 } catch (e) { e["message"] }`, "42"},
+		{`try { throw "x" } catch (e) { string(e) }`, "Error: x"},
+		{`var log = ""; try { log += "a"; } finally { log += "b"; } log`, "ab"},
+		{`var log = ""; try { throw "x"; } catch { log += "c"; } finally { log += "f"; } log`, "cf"},
+		{`var log = ""; try { try { throw "x"; } finally { log += "f"; } } catch (e) { log += e["message"]; } log`, "fx"},
+		{`try { try { throw "inner" } catch (e) { throw e } } catch (e) { e["message"] }`, "inner"},
+		{`try { try { throw "inner" } catch (e) { throw "outer" } } catch (e) { e["message"] }`, "outer"},
+		{`fun f() { try { return 1; } finally { 2; } } f()`, 1},
+		{`fun f() { try { return 1; } finally { return 2; } } f()`, 2},
+		{`fun f() { throw "deep" } fun g() { f() } try { g() } catch (e) { e["message"] }`, "deep"},
+		{`var n = 0; for i in 0..10 { try { if i == 3 { break; } } finally { n += 1; } } n`, 4},
+		{`var s = 0; for i in 0..5 { try { if i % 2 == 0 { throw "even" } s += i; } catch {} } s`, 4},
+		{`fun f() { throw "cond" } var n = 0; try { while f() { n += 1 } } catch (e) { e["message"] + "${n}" }`, "cond0"},
+		{`var n = 0; while n / 0 { n += 1 }`, "division by zero"},
+		{`var x = try { throw "a" } catch (e) { 5 }; x`, 5},
+		{`try { throw "uncaught" } finally { 1 }`, "uncaught"},
+		{`throw "top"`, "top"},
+		{`try { throw "a" } catch (e) { throw "b" }`, "b"},
+		{`try { 1 } finally { throw "from finally" }`, "from finally"},
+	}
+
+	for _, tt := range tests {
+		evaluated := testEval(t, tt.input)
+		switch expected := tt.expected.(type) {
+		case int:
+			testIntegerObject(t, evaluated, int64(expected))
+		case string:
+			switch obj := evaluated.(type) {
+			case *object.String:
+				if obj.Value != expected {
+					t.Errorf("%s: expected=%q, got=%q", tt.input, expected, obj.Value)
+				}
+			case *object.Error:
+				if obj.Message != expected {
+					t.Errorf("%s: expected error %q, got=%q", tt.input, expected, obj.Message)
+				}
+			default:
+				t.Errorf("%s: unexpected object %T (%+v)", tt.input, obj, obj)
+			}
+		}
+	}
+}
+
+func TestErrorStackTrace(t *testing.T) {
+	input := `fun inner(x) {
+  x + true
+}
+fun outer() {
+  inner(1);
+  null
+}
+outer()`
+
+	evaluated := testEval(t, input)
+	err, ok := evaluated.(*object.Error)
+	if !ok {
+		t.Fatalf("object is not Error. got=%T (%+v)", evaluated, evaluated)
+	}
+	if err.Kind != object.TYPE_ERROR {
+		t.Errorf("wrong error kind. expected=%s, got=%s", object.TYPE_ERROR, err.Kind)
+	}
+
+	expected := []string{"<main> (8:1)", "outer (5:3)", "inner (2:3)"}
+	if len(err.Stack) != len(expected) {
+		t.Fatalf("wrong stack length. expected=%d, got=%d (%v)", len(expected), len(err.Stack), err.Stack)
+	}
+	for i, entry := range err.Stack {
+		if entry.String() != expected[i] {
+			t.Errorf("wrong stack entry %d. expected=%q, got=%q", i, expected[i], entry.String())
+		}
+	}
+
+	evaluated = testEval(t, input[:len(input)-len("outer()")]+`try { outer() } catch (e) { e["stack"] }`)
+	stack, ok := evaluated.(*object.Array)
+	if !ok {
+		t.Fatalf("object is not Array. got=%T (%+v)", evaluated, evaluated)
+	}
+	if len(stack.Elements) != 3 {
+		t.Fatalf("wrong stack length. got=%d", len(stack.Elements))
+	}
+	testStringObject(t, stack.Elements[2], "inner (2:3)")
+}
+
+func TestStackTraceFrames(t *testing.T) {
+	tests := []struct {
+		input    string
+		expected []string
+	}{
+		{"len(1)", []string{"<main> (1:1)"}},
+		{"var f = (x) => x / 0;\nf(1)", []string{"<main> (2:1)", "<anonymous> (1:16)"}},
+		{"fun a() { b() }\nfun b() { len(1) }\na()", []string{"<main> (3:1)", "b (2:11)"}},
+		{"fun a() { b(); null }\nfun b() { len(1) }\na()", []string{"<main> (3:1)", "a (1:11)", "b (2:11)"}},
+		{"fun f(n) { if n == 0 { boom } 1 + f(n - 1) }\nf(2)", []string{"<main> (2:1)", "f (1:35)", "f (1:35)", "f (1:24)"}},
+		{"fun f() { throw \"x\" }\ntry { f() } catch (e) { 0 }\nf()", []string{"<main> (3:1)", "f (1:11)"}},
+	}
+
+	for _, tt := range tests {
+		evaluated := testEval(t, tt.input)
+		err, ok := evaluated.(*object.Error)
+		if !ok {
+			t.Fatalf("object is not Error. got=%T (%+v)", evaluated, evaluated)
+		}
+
+		var got []string
+		for _, entry := range err.Stack {
+			got = append(got, entry.String())
+		}
+		if strings.Join(got, ", ") != strings.Join(tt.expected, ", ") {
+			t.Errorf("%q: wrong stack. expected=%v, got=%v", tt.input, tt.expected, got)
+		}
+	}
+}
+
+func TestRecursionLimit(t *testing.T) {
+	evaluated := testEval(t, "fun f(n) { 1 + f(n + 1) }\nf(0)")
+	err, ok := evaluated.(*object.Error)
+	if !ok {
+		t.Fatalf("object is not Error. got=%T (%+v)", evaluated, evaluated)
+	}
+	if err.Kind != object.RECURSION_ERROR || err.Message != "maximum recursion depth exceeded" {
+		t.Errorf("wrong error. got=%s: %s", err.Kind, err.Message)
+	}
+	if len(err.Stack) != eval.DefaultMaxCallDepth+1 {
+		t.Errorf("wrong stack depth. expected=%d, got=%d", eval.DefaultMaxCallDepth+1, len(err.Stack))
+	}
+
+	testIntegerObject(t, testEval(t, "fun f(n) { if n == 0 { return 0 } 1 + f(n - 1) }\nf(5000)"), 5000)
+	testStringObject(t, testEval(t, "fun f() { 1 + f() }\ntry { f() } catch (e) { e[\"kind\"] }"), object.RECURSION_ERROR)
+
+	input := "fun f(n) { if n == 0 { return 0 } 1 + f(n - 1) }\n[f(10), try { f(11) } catch (e) { e[\"message\"] }]"
+	result, ok := testEvalEnv(t, input, limitedEnvironment(11)).(*object.Array)
+	if !ok {
+		t.Fatalf("object is not Array. got=%T", result)
+	}
+	testIntegerObject(t, result.Elements[0], 10)
+	testStringObject(t, result.Elements[1], "maximum recursion depth exceeded")
+}
+
+func limitedEnvironment(maxCallDepth int) func() *object.Environment {
+	return func() *object.Environment {
+		env := object.NewEnvironment()
+		env.SetLimits(&object.Limits{MaxCallDepth: maxCallDepth})
+		return env
+	}
+}
+
+func TestTailCalls(t *testing.T) {
+	tests := []struct {
+		input    string
+		expected interface{}
+	}{
+		{"fun sum(n, acc) { if n == 0 { return acc } sum(n - 1, acc + n) }\nsum(1000, 0)", 500500},
+		{"fun sum(n, acc) { if n == 0 { acc } else { return sum(n - 1, acc + n) } }\nsum(1000, 0)", 500500},
+		{"fun even(n) { if n == 0 { true } else { odd(n - 1) } }\nfun odd(n) { if n == 0 { false } else { even(n - 1) } }\neven(1001)", false},
+		{"var count = (n) => if n == 0 { \"done\" } else { count(n - 1) }\ncount(1000)", "done"},
+		{"fun f(n) { while true { if n == 0 { return 3 } return f(n - 1) } }\nf(1000)", 3},
+		{"fun f(n) { if n == 0 { return 0 } 1 + f(n - 1) }\nf(1000)", "maximum recursion depth exceeded"},
+		{"fun f(n) { if n == 0 { return 0 } try { f(n - 1) } catch (e) { e[\"message\"] } }\nf(1000)", "maximum recursion depth exceeded"},
+		{"fun f(n) { if n == 0 { return 0 } f(n - 1); null }\nf(1000)", "maximum recursion depth exceeded"},
+		{"fun f(n) { if n == 0 { return 0 } f() }\nf(1)", "wrong number of arguments to f: got=0, want=1"},
+	}
+
+	for _, tt := range tests {
+		evaluated := testEvalEnv(t, tt.input, limitedEnvironment(10))
+		switch expected := tt.expected.(type) {
+		case int:
+			testIntegerObject(t, evaluated, int64(expected))
+		case bool:
+			testBooleanObject(t, evaluated, expected)
+		case string:
+			if err, ok := evaluated.(*object.Error); ok {
+				if err.Message != expected {
+					t.Errorf("%q: wrong error message. expected=%q, got=%q", tt.input, expected, err.Message)
+				}
+			} else {
+				testStringObject(t, evaluated, expected)
+			}
+		}
+	}
+}
+
+func TestExecutionBudgets(t *testing.T) {
+	tests := []struct {
+		input    string
+		limits   object.Limits
+		expected interface{}
+	}{
+		{"var n = 0; for i in 0..10 { n += 1 } n", object.Limits{MaxSteps: 10}, 10},
+		{"var n = 0; for i in 0..10 { n += 1 } n", object.Limits{MaxSteps: 9}, "StepLimitError: step limit of 9 reached"},
+		{"var n = 0; while n < 6 { n += 1; if n % 2 == 0 { continue } } n", object.Limits{MaxSteps: 6}, 6},
+		{"fun f() { 1 } f() + f() + f()", object.Limits{MaxSteps: 2}, "StepLimitError: step limit of 2 reached"},
+		{"fun f() { f() } f()", object.Limits{MaxSteps: 50}, "StepLimitError: step limit of 50 reached"},
+		{"while true { try { while true {} } catch (e) { 1 } finally { 2 } }", object.Limits{MaxSteps: 100}, "StepLimitError: step limit of 100 reached"},
+		{"while true {}", object.Limits{Deadline: time.Now().Add(-time.Second)}, "TimeoutError: timed out"},
+	}
+
+	for _, tt := range tests {
+		evaluated := testEvalEnv(t, tt.input, func() *object.Environment {
+			env := object.NewEnvironment()
+			limits := tt.limits
+			env.SetLimits(&limits)
+			return env
+		})
+
+		switch expected := tt.expected.(type) {
+		case int:
+			testIntegerObject(t, evaluated, int64(expected))
+		case string:
+			err, ok := evaluated.(*object.Error)
+			if !ok {
+				t.Errorf("%q: object is not Error. got=%T (%+v)", tt.input, evaluated, evaluated)
+				continue
+			}
+			if got := err.Kind + ": " + err.Message; got != expected {
+				t.Errorf("%q: wrong error. expected=%q, got=%q", tt.input, expected, got)
+			}
+		}
+	}
+}
+
+func TestEvalContext(t *testing.T) {
+	program := parser.New(lexer.New("while true {}")).ParseProgram()
+	resolver.Resolve(program, nil)
+
+	canceled, cancel := context.WithCancel(context.Background())
+	cancel()
+	expired, cancel := context.WithDeadline(context.Background(), time.Now().Add(-time.Second))
+	defer cancel()
+
+	tests := []struct {
+		ctx  context.Context
+		kind string
+	}{
+		{canceled, object.CANCELED_ERROR},
+		{expired, object.TIMEOUT_ERROR},
+	}
+
+	for _, tt := range tests {
+		env := object.NewEnvironment()
+		evaluated := eval.EvalContext(tt.ctx, program, env)
+		err, ok := evaluated.(*object.Error)
+		if !ok {
+			t.Fatalf("object is not Error. got=%T (%+v)", evaluated, evaluated)
+		}
+		if err.Kind != tt.kind {
+			t.Errorf("wrong error kind. expected=%s, got=%s", tt.kind, err.Kind)
+		}
+		if env.Limits() != nil {
+			t.Errorf("limits of the environment were not restored")
+		}
+	}
+}
+
+func TestEvalUnresolved(t *testing.T) {
+	program := parser.New(lexer.New("fun double(n) { var d = n * 2; d } double(x)")).ParseProgram()
+	env := object.NewEnvironment()
+	env.Create("x", &object.Integer{Value: 21})
+
+	// Without the resolver, identifiers are looked up by name.
+	for _, stmt := range program.Statements {
+		eval.Eval(stmt, env)
+	}
+	call := program.Statements[1].(*ast.ExpressionStatement).Expression
+	testIntegerObject(t, eval.Eval(call, env), 42)
+	testIntegerObject(t, eval.Eval(program, env), 42)
+}
+
+func TestConcurrentEval(t *testing.T) {
+	program := parser.New(lexer.New("fun fib(n) { if n < 2 { n } else { var a = fib(n - 1); a + fib(n - 2) } } fib(15)")).ParseProgram()
+	resolver.Resolve(program, nil)
+
+	var wg sync.WaitGroup
+	results := make([]object.Object, 8)
+	for i := range results {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			results[i] = eval.Eval(program, object.NewEnvironment())
+		}(i)
+	}
+	wg.Wait()
+
+	for _, result := range results {
+		testIntegerObject(t, result, 610)
+	}
+}
+
+func TestPanicsBecomeErrors(t *testing.T) {
+	evaluated := testEvalEnv(t, "var x = 1;\nx + boom()", func() *object.Environment {
+		env := object.NewEnvironment()
+		env.Create("boom", &object.Builtin{Fn: func(args ...object.Object) object.Object {
+			var elements []object.Object
+			return elements[len(args)]
+		}})
+		return env
+	})
+	err, ok := evaluated.(*object.Error)
+	if !ok {
+		t.Fatalf("object is not Error. got=%T (%+v)", evaluated, evaluated)
+	}
+	if !strings.HasPrefix(err.Message, "internal error: runtime error: index out of range") {
+		t.Errorf("wrong error message. got=%q", err.Message)
+	}
+	if err.Span.Start.String() != "2:5" {
+		t.Errorf("wrong error position. expected=2:5, got=%s", err.Span.Start)
+	}
+}
+
+func TestErrorPositions(t *testing.T) {
+	tests := []struct {
+		input           string
+		expectedMessage string
+		expectedPos     string
+	}{
+		{"foo", "identifier not found: foo", "1:1"},
+		{"fun f() {\n  var g = () => h;\n  g();\n  var h = 1;\n}\nf()", "identifier not found: h", "2:17"},
+		{"var a = 1;\nvar b = a + true;", "type mismatch: INTEGER + BOOLEAN", "2:9"},
+		{"fun f(x) {\n  return -x;\n}\nf(true);", "unknown operator: -BOOLEAN", "2:10"},
+		{`[1, 2][5]`, "index out of range: 5", "1:1"},
+		{"var x = 0;\n1 + 10 / x", "division by zero", "2:5"},
+		{"5 % 0", "division by zero", "1:1"},
+		{"(1 << 70) / 0", "division by zero", "1:2"},
+		{"(1 << 70) % (1 - 1)", "division by zero", "1:2"},
+	}
+
+	for _, tt := range tests {
+		evaluated := testEval(t, tt.input)
+		err, ok := evaluated.(*object.Error)
+		if !ok {
+			t.Fatalf("object is not Error. got=%T (%+v)", evaluated, evaluated)
+		}
+		if err.Message != tt.expectedMessage {
+			t.Errorf("wrong error message. expected=%q, got=%q", tt.expectedMessage, err.Message)
+		}
+		if err.Span.Start.String() != tt.expectedPos {
+			t.Errorf("wrong error position. expected=%s, got=%s", tt.expectedPos, err.Span.Start)
+		}
+	}
+}
@@ -1,21 +1,60 @@
 package lexer
 
-import "kaze/token"
+import (
+	"fmt"
+	"kaze/diag"
+	"kaze/token"
+	"strconv"
+	"strings"
+	"unicode"
+	"unicode/utf8"
+)
 
 type Lexer struct {
 	input   string
 	pos     int
 	nextPos int
 	ch      byte
+	line    int
+	column  int
+	errors  []*diag.Diagnostic
+
+	// interpolations holds one entry per ${ ... } the lexer is inside of.
+	interpolations []interpolation
+}
+
+type interpolation struct {
+	start  token.Position // the opening quote of the string
+	braces int            // unmatched { inside the ${ ... }
 }
 
 func New(input string) *Lexer {
-	l := &Lexer{input: input}
+	l := &Lexer{input: input, line: 1}
 	l.readChar()
 	return l
 }
 
+// Errors returns the problems found in the input so far. The offending
+// characters are returned as UNKNOWN tokens.
+func (l *Lexer) Errors() []*diag.Diagnostic {
+	return l.errors
+}
+
+func (l *Lexer) errorf(code string, start token.Position, format string, a ...interface{}) {
+	span := diag.Span{Start: start, End: l.position()}
+	l.errors = append(l.errors, diag.Errorf(code, span, format, a...))
+}
+
 func (l *Lexer) readChar() {
+	if l.nextPos > len(l.input) {
+		return
+	}
+	if l.ch == '\n' {
+		l.line++
+		l.column = 1
+	} else {
+		l.column++
+	}
 	if l.nextPos >= len(l.input) {
 		l.ch = 0
 	} else {
@@ -26,8 +65,6 @@ func (l *Lexer) readChar() {
 }
 
 func (l *Lexer) NextToken() token.Token {
-	var tok token.Token
-
 	l.skipWhitespace()
 
 	for l.ch == '/' && l.peekChar() == '/' {
@@ -37,22 +74,51 @@ func (l *Lexer) NextToken() token.Token {
 		l.skipWhitespace()
 	}
 
+	pos := l.position()
+	tok := l.readToken()
+	tok.Pos = pos
+	tok.End = l.position()
+	return tok
+}
+
+func (l *Lexer) readToken() token.Token {
+	var tok token.Token
+
 	switch l.ch {
 	case '=':
 		if l.peekChar() == '=' {
 			tok = token.Token{Type: token.EQ, Literal: "=="}
 			l.readChar()
+		} else if l.peekChar() == '>' {
+			tok = token.Token{Type: token.ARROW, Literal: "=>"}
+			l.readChar()
 		} else {
 			tok = newToken(token.ASSIGN, l.ch)
 		}
 	case '+':
-		tok = newToken(token.PLUS, l.ch)
+		if l.peekChar() == '+' {
+			tok = token.Token{Type: token.INCREMENT, Literal: "++"}
+			l.readChar()
+		} else {
+			tok = l.readCompoundAssign(token.PLUS, token.PLUS_ASSIGN)
+		}
 	case '-':
-		tok = newToken(token.MINUS, l.ch)
+		if l.peekChar() == '-' {
+			tok = token.Token{Type: token.DECREMENT, Literal: "--"}
+			l.readChar()
+		} else {
+			tok = l.readCompoundAssign(token.MINUS, token.MINUS_ASSIGN)
+		}
 	case '*':
-		tok = newToken(token.ASTERISK, l.ch)
+		tok = l.readCompoundAssign(token.ASTERISK, token.ASTERISK_ASSIGN)
 	case '/':
-		tok = newToken(token.SLASH, l.ch)
+		tok = l.readCompoundAssign(token.SLASH, token.SLASH_ASSIGN)
+	case '%':
+		tok = l.readCompoundAssign(token.PERCENT, token.PERCENT_ASSIGN)
+	case '^':
+		tok = newToken(token.CARET, l.ch)
+	case '~':
+		tok = newToken(token.TILDE, l.ch)
 	case '!':
 		if l.peekChar() == '=' {
 			tok = token.Token{Type: token.NOT_EQ, Literal: "!="}
@@ -65,19 +131,22 @@ func (l *Lexer) NextToken() token.Token {
 			tok = token.Token{Type: token.AND, Literal: "&&"}
 			l.readChar()
 		} else {
-			tok = newToken(token.UNKNOWN, l.ch)
+			tok = newToken(token.AMPERSAND, l.ch)
 		}
 	case '|':
 		if l.peekChar() == '|' {
 			tok = token.Token{Type: token.OR, Literal: "||"}
 			l.readChar()
 		} else {
-			tok = newToken(token.UNKNOWN, l.ch)
+			tok = newToken(token.PIPE, l.ch)
 		}
 	case '<':
 		if l.peekChar() == '=' {
 			tok = token.Token{Type: token.LE, Literal: "<="}
 			l.readChar()
+		} else if l.peekChar() == '<' {
+			tok = token.Token{Type: token.SHL, Literal: "<<"}
+			l.readChar()
 		} else {
 			tok = newToken(token.LT, l.ch)
 		}
@@ -85,6 +154,9 @@ func (l *Lexer) NextToken() token.Token {
 		if l.peekChar() == '=' {
 			tok = token.Token{Type: token.GE, Literal: ">="}
 			l.readChar()
+		} else if l.peekChar() == '>' {
+			tok = token.Token{Type: token.SHR, Literal: ">>"}
+			l.readChar()
 		} else {
 			tok = newToken(token.GT, l.ch)
 		}
@@ -93,8 +165,17 @@ func (l *Lexer) NextToken() token.Token {
 	case ')':
 		tok = newToken(token.RPAREN, l.ch)
 	case '{':
+		if n := len(l.interpolations); n > 0 {
+			l.interpolations[n-1].braces++
+		}
 		tok = newToken(token.LBRACE, l.ch)
 	case '}':
+		if n := len(l.interpolations); n > 0 {
+			if l.interpolations[n-1].braces == 0 {
+				return l.readInterpolationRest()
+			}
+			l.interpolations[n-1].braces--
+		}
 		tok = newToken(token.RBRACE, l.ch)
 	case '[':
 		tok = newToken(token.LBRACKET, l.ch)
@@ -108,15 +189,34 @@ func (l *Lexer) NextToken() token.Token {
 		tok = newToken(token.COMMA, l.ch)
 	case '#':
 		tok = newToken(token.HASH, l.ch)
+	case '.':
+		switch {
+		case strings.HasPrefix(l.input[l.pos:], "..."):
+			l.readChar()
+			l.readChar()
+			tok = token.Token{Type: token.ELLIPSIS, Literal: "..."}
+		case strings.HasPrefix(l.input[l.pos:], "..="):
+			l.readChar()
+			l.readChar()
+			tok = token.Token{Type: token.DOTDOT_EQ, Literal: "..="}
+		case l.peekChar() == '.':
+			l.readChar()
+			tok = token.Token{Type: token.DOTDOT, Literal: ".."}
+		default:
+			return l.readUnexpectedChar()
+		}
 	case 0:
 		tok.Type = token.EOF
 		tok.Literal = ""
 	case '"':
-		literal, ok := l.readString()
-		tok.Literal = literal
-		if ok {
-			tok.Type = token.STRING
-		} else {
+		start := l.position()
+		l.readChar()
+		return l.readStringPart(start, token.STRING, token.INTERP_START)
+	case '`':
+		errors := len(l.errors)
+		tok.Literal = l.readRawString()
+		tok.Type = token.STRING
+		if len(l.errors) > errors {
 			tok.Type = token.UNKNOWN
 		}
 		return tok
@@ -127,17 +227,40 @@ func (l *Lexer) NextToken() token.Token {
 			return tok
 		}
 		if isDigit(l.ch) {
-			tok.Type = token.INT
-			tok.Literal = l.readInteger()
-			return tok
+			return l.readNumber()
 		}
-		tok = newToken(token.UNKNOWN, l.ch)
+		return l.readUnexpectedChar()
 	}
 
 	l.readChar()
 	return tok
 }
 
+// readCompoundAssign reads an operator that may be followed by '=', as in
+// + and +=.
+func (l *Lexer) readCompoundAssign(op token.TokenType, assign token.TokenType) token.Token {
+	if l.peekChar() != '=' {
+		return newToken(op, l.ch)
+	}
+	ch := l.ch
+	l.readChar()
+	return token.Token{Type: assign, Literal: string(ch) + "="}
+}
+
+func (l *Lexer) readUnexpectedChar() token.Token {
+	start := l.position()
+	r, size := utf8.DecodeRuneInString(l.input[l.pos:])
+	for i := 0; i < size; i++ {
+		l.readChar()
+	}
+	l.errorf(diag.UNEXPECTED_CHAR, start, "unexpected character %q", r)
+	return token.Token{Type: token.UNKNOWN, Literal: l.input[start.Offset:l.pos]}
+}
+
+func (l *Lexer) position() token.Position {
+	return token.Position{Offset: l.pos, Line: l.line, Column: l.column}
+}
+
 func (l *Lexer) peekChar() byte {
 	if l.nextPos >= len(l.input) {
 		return 0
@@ -151,12 +274,110 @@ func (l *Lexer) skipWhitespace() {
 	}
 }
 
-func (l *Lexer) readInteger() string {
-	pos := l.pos
-	for isDigit(l.ch) {
+var basePrefixes = map[byte]struct {
+	name    string
+	isDigit func(byte) bool
+}{
+	'x': {"hexadecimal", isHexDigit},
+	'X': {"hexadecimal", isHexDigit},
+	'o': {"octal", isOctalDigit},
+	'O': {"octal", isOctalDigit},
+	'b': {"binary", isBinaryDigit},
+	'B': {"binary", isBinaryDigit},
+}
+
+// readNumber reads an integer such as 42, 1_000, 0xFF, 0o755 or 0b1010, or a
+// float such as 3.14 or 1e9. A malformed literal is returned as an UNKNOWN
+// token. As in Go, an underscore may follow a base prefix, as in 0x_FF, but
+// unlike Go a decimal integer can't start with 0, so 0755 isn't mistaken for
+// an octal number.
+func (l *Lexer) readNumber() token.Token {
+	start := l.position()
+	tok := token.Token{Type: token.INT}
+	var problem string
+
+	if prefix, ok := basePrefixes[l.peekChar()]; ok && l.ch == '0' {
+		l.readChar()
+		l.readChar()
+		if l.ch == '_' {
+			l.readChar()
+		}
+		// read every alphanumeric character so a bad digit is reported
+		// instead of starting the next token
+		digits, p := l.readDigits(func(ch byte) bool { return isDigit(ch) || isLetter(ch) && ch != '_' })
+		switch {
+		case p != "":
+			problem = p
+		case digits == "":
+			problem = prefix.name + " literal has no digits"
+		default:
+			for i := 0; i < len(digits); i++ {
+				if !prefix.isDigit(digits[i]) {
+					problem = fmt.Sprintf("invalid digit %q in %s literal", digits[i], prefix.name)
+					break
+				}
+			}
+		}
+	} else {
+		var digits string
+		digits, problem = l.readDigits(isDigit)
+		if l.ch == '.' && isDigit(l.peekChar()) {
+			tok.Type = token.FLOAT
+			l.readChar()
+			if _, p := l.readDigits(isDigit); problem == "" {
+				problem = p
+			}
+		}
+		if l.ch == 'e' || l.ch == 'E' {
+			tok.Type = token.FLOAT
+			l.readChar()
+			if l.ch == '+' || l.ch == '-' {
+				l.readChar()
+			}
+			digits, p := l.readDigits(isDigit)
+			if problem == "" {
+				problem = p
+			}
+			if digits == "" && problem == "" {
+				problem = "exponent has no digits"
+			}
+		}
+		if tok.Type == token.INT && len(digits) > 1 && digits[0] == '0' && problem == "" {
+			problem = "leading zeros are not allowed, use 0o for octal"
+		}
+	}
+
+	tok.Literal = l.input[start.Offset:l.pos]
+	if problem != "" {
+		l.errorf(diag.INVALID_NUMBER, start, "invalid number literal %s: %s", tok.Literal, problem)
+		tok.Type = token.UNKNOWN
+	}
+	return tok
+}
+
+// readDigits reads digits accepted by isDigit, separated by single
+// underscores. It returns the digits without underscores, and a description
+// of the problem if an underscore is misplaced.
+func (l *Lexer) readDigits(isDigit func(byte) bool) (string, string) {
+	var digits strings.Builder
+	var problem string
+	prev := byte(0)
+
+	for isDigit(l.ch) || l.ch == '_' {
+		if l.ch == '_' && (prev == 0 || prev == '_') {
+			problem = "'_' must separate successive digits"
+		}
+		if l.ch != '_' {
+			digits.WriteByte(l.ch)
+		}
+		prev = l.ch
 		l.readChar()
 	}
-	return l.input[pos:l.pos]
+	if prev == '_' {
+		problem = "'_' must separate successive digits"
+	}
+
+	return digits.String(), problem
 }
 
 func (l *Lexer) readIdentifier() string {
@@ -167,19 +388,138 @@ func (l *Lexer) readIdentifier() string {
 	return l.input[pos:l.pos]
 }
 
-func (l *Lexer) readString() (string, bool) {
+// readInterpolationRest reads the part of a string that follows the } closing
+// an interpolation, up to the next ${ or the closing quote.
+func (l *Lexer) readInterpolationRest() token.Token {
+	n := len(l.interpolations)
+	start := l.interpolations[n-1].start
+	l.interpolations = l.interpolations[:n-1]
+
 	l.readChar()
+	return l.readStringPart(start, token.INTERP_END, token.INTERP_MID)
+}
+
+// readStringPart reads double-quoted string content with escape sequences
+// decoded, starting at the current character. A part ended by the closing
+// quote becomes a closed token; one ended by ${ becomes an open token and the
+// lexer continues with the tokens of the interpolated expression. A string
+// must end on the line it starts on.
+func (l *Lexer) readStringPart(start token.Position, closed token.TokenType, open token.TokenType) token.Token {
+	errors := len(l.errors)
+	var out strings.Builder
+	tok := token.Token{Type: closed}
+
+	for l.ch != '"' {
+		if l.ch == 0 || l.ch == '\n' {
+			l.errorf(diag.UNTERMINATED_STRING, start, "unterminated string starting at %s", start)
+			return token.Token{Type: token.UNKNOWN}
+		}
+		if l.ch == '$' && l.peekChar() == '{' {
+			l.readChar()
+			l.interpolations = append(l.interpolations, interpolation{start: start})
+			tok.Type = open
+			break
+		}
+		if l.ch == '\\' {
+			l.readEscape(&out)
+			continue
+		}
+		out.WriteByte(l.ch)
+		l.readChar()
+	}
+	l.readChar()
+
+	tok.Literal = out.String()
+	if len(l.errors) > errors {
+		tok.Type = token.UNKNOWN
+	}
+	return tok
+}
+
+// readEscape decodes the escape sequence at the current backslash into out.
+func (l *Lexer) readEscape(out *strings.Builder) {
+	start := l.position()
+	l.readChar()
+
+	switch l.ch {
+	case 'n':
+		out.WriteByte('\n')
+	case 't':
+		out.WriteByte('\t')
+	case 'r':
+		out.WriteByte('\r')
+	case '0':
+		out.WriteByte(0)
+	case '\\', '"', '$':
+		out.WriteByte(l.ch)
+	case 'x':
+		l.readChar()
+		digits := l.readHexDigits(2)
+		if len(digits) != 2 {
+			l.errorf(diag.INVALID_ESCAPE, start, "\\x must be followed by 2 hex digits")
+			return
+		}
+		value, _ := strconv.ParseUint(digits, 16, 8)
+		out.WriteByte(byte(value))
+		return
+	case 'u':
+		l.readChar()
+		if l.ch != '{' {
+			l.errorf(diag.INVALID_ESCAPE, start, "\\u must be followed by {")
+			return
+		}
+		l.readChar()
+		digits := l.readHexDigits(6)
+		if l.ch != '}' || len(digits) == 0 {
+			l.errorf(diag.INVALID_ESCAPE, start, "\\u{...} must contain 1 to 6 hex digits")
+			return
+		}
+		l.readChar()
+		value, _ := strconv.ParseUint(digits, 16, 32)
+		if value > unicode.MaxRune || 0xD800 <= value && value <= 0xDFFF {
+			l.errorf(diag.INVALID_ESCAPE, start, "invalid unicode code point \\u{%s}", digits)
+			return
+		}
+		out.WriteRune(rune(value))
+		return
+	case 0, '\n':
+		// reported as an unterminated string by the caller
+		return
+	default:
+		l.readChar()
+		l.errorf(diag.INVALID_ESCAPE, start, "unknown escape sequence %s", l.input[start.Offset:l.pos])
+		return
+	}
+	l.readChar()
+}
+
+// readHexDigits reads at most max hex digits.
+func (l *Lexer) readHexDigits(max int) string {
 	pos := l.pos
-	for l.ch != '"' && l.ch != 0 && l.ch != '\n' {
+	for l.pos-pos < max && isHexDigit(l.ch) {
 		l.readChar()
 	}
-	if l.ch != '"' {
-		println("string literal not terminated")
-		return "", false
+	return l.input[pos:l.pos]
+}
+
+// readRawString reads a backquoted string. Raw strings may span lines and
+// do not interpret escape sequences.
+func (l *Lexer) readRawString() string {
+	start := l.position()
+
+	l.readChar()
+	pos := l.pos
+	for l.ch != '`' {
+		if l.ch == 0 {
+			l.errorf(diag.UNTERMINATED_STRING, start, "unterminated raw string starting at %s", start)
+			return ""
+		}
+		l.readChar()
 	}
 	result := l.input[pos:l.pos]
 	l.readChar()
-	return result, true
+
+	return result
 }
 
 func isLetter(ch byte) bool {
@@ -190,6 +530,18 @@ func isDigit(ch byte) bool {
 	return '0' <= ch && ch <= '9'
 }
 
+func isBinaryDigit(ch byte) bool {
+	return ch == '0' || ch == '1'
+}
+
+func isOctalDigit(ch byte) bool {
+	return '0' <= ch && ch <= '7'
+}
+
+func isHexDigit(ch byte) bool {
+	return isDigit(ch) || 'a' <= ch && ch <= 'f' || 'A' <= ch && ch <= 'F'
+}
+
 func newToken(tokenType token.TokenType, ch byte) token.Token {
 	return token.Token{Type: tokenType, Literal: string(ch)}
 }
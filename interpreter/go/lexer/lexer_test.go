@@ -8,6 +8,7 @@ import (
 func TestNextToken(t *testing.T) {
 	input := `1 + 2 - 3 * 4 / 5 * (6 + 7) - 8;
 1234567890;
+3.14 0.5;
 var hoge = 1;
 fun fuga(x, y) {
     if (true) {
@@ -34,6 +35,15 @@ while true {
 #{"foo": "bar"};
 [1,2,3][0];
 true && true || true;
+7 % 2 & 3 | 4 ^ ~5 << 1 >> 2;
+(x) => x;
+f(...xs);
+for i in 0..n {}
+0..=9;
+xs[1:];
+a += 1; a -= 1; a *= 2; a /= 2; a %= 3;
+a++; a--;
+try catch finally throw
 // comment
 1; // comment
 null;
@@ -62,6 +72,9 @@ null;
 		{token.SEMICOLON, ";"},
 		{token.INT, "1234567890"},
 		{token.SEMICOLON, ";"},
+		{token.FLOAT, "3.14"},
+		{token.FLOAT, "0.5"},
+		{token.SEMICOLON, ";"},
 		{token.VAR, "var"},
 		{token.IDENT, "hoge"},
 		{token.ASSIGN, "="},
@@ -167,6 +180,81 @@ null;
 		{token.OR, "||"},
 		{token.TRUE, "true"},
 		{token.SEMICOLON, ";"},
+		{token.INT, "7"},
+		{token.PERCENT, "%"},
+		{token.INT, "2"},
+		{token.AMPERSAND, "&"},
+		{token.INT, "3"},
+		{token.PIPE, "|"},
+		{token.INT, "4"},
+		{token.CARET, "^"},
+		{token.TILDE, "~"},
+		{token.INT, "5"},
+		{token.SHL, "<<"},
+		{token.INT, "1"},
+		{token.SHR, ">>"},
+		{token.INT, "2"},
+		{token.SEMICOLON, ";"},
+		{token.LPAREN, "("},
+		{token.IDENT, "x"},
+		{token.RPAREN, ")"},
+		{token.ARROW, "=>"},
+		{token.IDENT, "x"},
+		{token.SEMICOLON, ";"},
+		{token.IDENT, "f"},
+		{token.LPAREN, "("},
+		{token.ELLIPSIS, "..."},
+		{token.IDENT, "xs"},
+		{token.RPAREN, ")"},
+		{token.SEMICOLON, ";"},
+		{token.FOR, "for"},
+		{token.IDENT, "i"},
+		{token.IN, "in"},
+		{token.INT, "0"},
+		{token.DOTDOT, ".."},
+		{token.IDENT, "n"},
+		{token.LBRACE, "{"},
+		{token.RBRACE, "}"},
+		{token.INT, "0"},
+		{token.DOTDOT_EQ, "..="},
+		{token.INT, "9"},
+		{token.SEMICOLON, ";"},
+		{token.IDENT, "xs"},
+		{token.LBRACKET, "["},
+		{token.INT, "1"},
+		{token.COLON, ":"},
+		{token.RBRACKET, "]"},
+		{token.SEMICOLON, ";"},
+		{token.IDENT, "a"},
+		{token.PLUS_ASSIGN, "+="},
+		{token.INT, "1"},
+		{token.SEMICOLON, ";"},
+		{token.IDENT, "a"},
+		{token.MINUS_ASSIGN, "-="},
+		{token.INT, "1"},
+		{token.SEMICOLON, ";"},
+		{token.IDENT, "a"},
+		{token.ASTERISK_ASSIGN, "*="},
+		{token.INT, "2"},
+		{token.SEMICOLON, ";"},
+		{token.IDENT, "a"},
+		{token.SLASH_ASSIGN, "/="},
+		{token.INT, "2"},
+		{token.SEMICOLON, ";"},
+		{token.IDENT, "a"},
+		{token.PERCENT_ASSIGN, "%="},
+		{token.INT, "3"},
+		{token.SEMICOLON, ";"},
+		{token.IDENT, "a"},
+		{token.INCREMENT, "++"},
+		{token.SEMICOLON, ";"},
+		{token.IDENT, "a"},
+		{token.DECREMENT, "--"},
+		{token.SEMICOLON, ";"},
+		{token.TRY, "try"},
+		{token.CATCH, "catch"},
+		{token.FINALLY, "finally"},
+		{token.THROW, "throw"},
 		{token.INT, "1"},
 		{token.SEMICOLON, ";"},
 		{token.NULL, "null"},
@@ -190,3 +278,317 @@ null;
 		}
 	}
 }
+
+func TestTokenPositions(t *testing.T) {
+	input := `var x = 10;
+fun f(a) {
+	"hoge" == a
+}`
+	tests := []struct {
+		expectedType token.TokenType
+		expectedPos  token.Position
+		expectedEnd  token.Position
+	}{
+		{token.VAR, token.Position{Offset: 0, Line: 1, Column: 1}, token.Position{Offset: 3, Line: 1, Column: 4}},
+		{token.IDENT, token.Position{Offset: 4, Line: 1, Column: 5}, token.Position{Offset: 5, Line: 1, Column: 6}},
+		{token.ASSIGN, token.Position{Offset: 6, Line: 1, Column: 7}, token.Position{Offset: 7, Line: 1, Column: 8}},
+		{token.INT, token.Position{Offset: 8, Line: 1, Column: 9}, token.Position{Offset: 10, Line: 1, Column: 11}},
+		{token.SEMICOLON, token.Position{Offset: 10, Line: 1, Column: 11}, token.Position{Offset: 11, Line: 1, Column: 12}},
+		{token.FUN, token.Position{Offset: 12, Line: 2, Column: 1}, token.Position{Offset: 15, Line: 2, Column: 4}},
+		{token.IDENT, token.Position{Offset: 16, Line: 2, Column: 5}, token.Position{Offset: 17, Line: 2, Column: 6}},
+		{token.LPAREN, token.Position{Offset: 17, Line: 2, Column: 6}, token.Position{Offset: 18, Line: 2, Column: 7}},
+		{token.IDENT, token.Position{Offset: 18, Line: 2, Column: 7}, token.Position{Offset: 19, Line: 2, Column: 8}},
+		{token.RPAREN, token.Position{Offset: 19, Line: 2, Column: 8}, token.Position{Offset: 20, Line: 2, Column: 9}},
+		{token.LBRACE, token.Position{Offset: 21, Line: 2, Column: 10}, token.Position{Offset: 22, Line: 2, Column: 11}},
+		{token.STRING, token.Position{Offset: 24, Line: 3, Column: 2}, token.Position{Offset: 30, Line: 3, Column: 8}},
+		{token.EQ, token.Position{Offset: 31, Line: 3, Column: 9}, token.Position{Offset: 33, Line: 3, Column: 11}},
+		{token.IDENT, token.Position{Offset: 34, Line: 3, Column: 12}, token.Position{Offset: 35, Line: 3, Column: 13}},
+		{token.RBRACE, token.Position{Offset: 36, Line: 4, Column: 1}, token.Position{Offset: 37, Line: 4, Column: 2}},
+		{token.EOF, token.Position{Offset: 37, Line: 4, Column: 2}, token.Position{Offset: 37, Line: 4, Column: 2}},
+	}
+
+	l := New(input)
+
+	for i, tt := range tests {
+		tok := l.NextToken()
+
+		if tok.Type != tt.expectedType {
+			t.Fatalf("tests[%d] - tokentype wrong. expected=%q, got=%q",
+				i, tt.expectedType, tok.Type)
+		}
+
+		if tok.Pos != tt.expectedPos {
+			t.Fatalf("tests[%d] - pos wrong. expected=%+v, got=%+v",
+				i, tt.expectedPos, tok.Pos)
+		}
+
+		if tok.End != tt.expectedEnd {
+			t.Fatalf("tests[%d] - end wrong. expected=%+v, got=%+v",
+				i, tt.expectedEnd, tok.End)
+		}
+	}
+}
+
+func TestLexerErrors(t *testing.T) {
+	input := `var a = 1 @ 2;
+a ? b $ c;
+"ok" + "unterminated
+é`
+
+	tests := []struct {
+		expectedType    token.TokenType
+		expectedLiteral string
+	}{
+		{token.VAR, "var"},
+		{token.IDENT, "a"},
+		{token.ASSIGN, "="},
+		{token.INT, "1"},
+		{token.UNKNOWN, "@"},
+		{token.INT, "2"},
+		{token.SEMICOLON, ";"},
+		{token.IDENT, "a"},
+		{token.UNKNOWN, "?"},
+		{token.IDENT, "b"},
+		{token.UNKNOWN, "$"},
+		{token.IDENT, "c"},
+		{token.SEMICOLON, ";"},
+		{token.STRING, "ok"},
+		{token.PLUS, "+"},
+		{token.UNKNOWN, ""},
+		{token.UNKNOWN, "é"},
+		{token.EOF, ""},
+	}
+
+	l := New(input)
+
+	for i, tt := range tests {
+		tok := l.NextToken()
+
+		if tok.Type != tt.expectedType {
+			t.Fatalf("tests[%d] - tokentype wrong. expected=%q, got=%q",
+				i, tt.expectedType, tok.Type)
+		}
+
+		if tok.Literal != tt.expectedLiteral {
+			t.Fatalf("tests[%d] - literal wrong. expected=%q, got=%q",
+				i, tt.expectedLiteral, tok.Literal)
+		}
+	}
+
+	expectedErrors := []string{
+		"1:11: unexpected character '@'",
+		"2:3: unexpected character '?'",
+		"2:7: unexpected character '$'",
+		"3:8: unterminated string starting at 3:8",
+		"4:1: unexpected character 'é'",
+	}
+	errors := l.Errors()
+	if len(errors) != len(expectedErrors) {
+		t.Fatalf("wrong number of errors. expected=%d, got=%d", len(expectedErrors), len(errors))
+	}
+	for i, expected := range expectedErrors {
+		if errors[i].Error() != expected {
+			t.Errorf("errors[%d] wrong. expected=%q, got=%q", i, expected, errors[i].Error())
+		}
+	}
+}
+
+func TestStringEscapes(t *testing.T) {
+	input := `"a\nb" "\t\r\\\"" "nul\0" "\x41\x7a" "\u{3042}\u{1F600}" ` + "`raw\\n\n\"line\"`"
+
+	tests := []struct {
+		expectedType    token.TokenType
+		expectedLiteral string
+	}{
+		{token.STRING, "a\nb"},
+		{token.STRING, "\t\r\\\""},
+		{token.STRING, "nul\x00"},
+		{token.STRING, "Az"},
+		{token.STRING, "あ😀"},
+		{token.STRING, "raw\\n\n\"line\""},
+		{token.EOF, ""},
+	}
+
+	l := New(input)
+
+	for i, tt := range tests {
+		tok := l.NextToken()
+
+		if tok.Type != tt.expectedType {
+			t.Fatalf("tests[%d] - tokentype wrong. expected=%q, got=%q",
+				i, tt.expectedType, tok.Type)
+		}
+
+		if tok.Literal != tt.expectedLiteral {
+			t.Fatalf("tests[%d] - literal wrong. expected=%q, got=%q",
+				i, tt.expectedLiteral, tok.Literal)
+		}
+	}
+
+	if len(l.Errors()) != 0 {
+		t.Fatalf("unexpected errors: %v", l.Errors())
+	}
+}
+
+func TestInvalidStringEscapes(t *testing.T) {
+	tests := []struct {
+		input         string
+		expectedError string
+	}{
+		{`"\q"`, "1:2: unknown escape sequence \\q"},
+		{`"\x4"`, "1:2: \\x must be followed by 2 hex digits"},
+		{`"\u41"`, "1:2: \\u must be followed by {"},
+		{`"\u{}"`, "1:2: \\u{...} must contain 1 to 6 hex digits"},
+		{`"\u{D800}"`, "1:2: invalid unicode code point \\u{D800}"},
+		{"`never closed", "1:1: unterminated raw string starting at 1:1"},
+	}
+
+	for _, tt := range tests {
+		l := New(tt.input)
+		tok := l.NextToken()
+
+		if tok.Type != token.UNKNOWN {
+			t.Errorf("%s: tokentype wrong. expected=%q, got=%q", tt.input, token.UNKNOWN, tok.Type)
+		}
+
+		errors := l.Errors()
+		if len(errors) != 1 {
+			t.Fatalf("%s: wrong number of errors. expected=1, got=%d", tt.input, len(errors))
+		}
+		if errors[0].Error() != tt.expectedError {
+			t.Errorf("%s: wrong error. expected=%q, got=%q", tt.input, tt.expectedError, errors[0].Error())
+		}
+	}
+}
+
+func TestStringInterpolation(t *testing.T) {
+	input := `"expected ${x}, got ${f(#{"k": "${y}"}["k"])}!" "\${x}"`
+
+	tests := []struct {
+		expectedType    token.TokenType
+		expectedLiteral string
+	}{
+		{token.INTERP_START, "expected "},
+		{token.IDENT, "x"},
+		{token.INTERP_MID, ", got "},
+		{token.IDENT, "f"},
+		{token.LPAREN, "("},
+		{token.HASH, "#"},
+		{token.LBRACE, "{"},
+		{token.STRING, "k"},
+		{token.COLON, ":"},
+		{token.INTERP_START, ""},
+		{token.IDENT, "y"},
+		{token.INTERP_END, ""},
+		{token.RBRACE, "}"},
+		{token.LBRACKET, "["},
+		{token.STRING, "k"},
+		{token.RBRACKET, "]"},
+		{token.RPAREN, ")"},
+		{token.INTERP_END, "!"},
+		{token.STRING, "${x}"},
+		{token.EOF, ""},
+	}
+
+	l := New(input)
+
+	for i, tt := range tests {
+		tok := l.NextToken()
+
+		if tok.Type != tt.expectedType {
+			t.Fatalf("tests[%d] - tokentype wrong. expected=%q, got=%q",
+				i, tt.expectedType, tok.Type)
+		}
+
+		if tok.Literal != tt.expectedLiteral {
+			t.Fatalf("tests[%d] - literal wrong. expected=%q, got=%q",
+				i, tt.expectedLiteral, tok.Literal)
+		}
+	}
+
+	if len(l.Errors()) != 0 {
+		t.Fatalf("unexpected errors: %v", l.Errors())
+	}
+}
+
+func TestNumberLiterals(t *testing.T) {
+	input := `0xFF 0o755 0b1010 1_000_000 1e9 2.5E-3 1_0.0_1e+1_0 0x_FF 0 007.5`
+
+	tests := []struct {
+		expectedType    token.TokenType
+		expectedLiteral string
+	}{
+		{token.INT, "0xFF"},
+		{token.INT, "0o755"},
+		{token.INT, "0b1010"},
+		{token.INT, "1_000_000"},
+		{token.FLOAT, "1e9"},
+		{token.FLOAT, "2.5E-3"},
+		{token.FLOAT, "1_0.0_1e+1_0"},
+		{token.INT, "0x_FF"},
+		{token.INT, "0"},
+		{token.FLOAT, "007.5"},
+		{token.EOF, ""},
+	}
+
+	l := New(input)
+
+	for i, tt := range tests {
+		tok := l.NextToken()
+
+		if tok.Type != tt.expectedType {
+			t.Fatalf("tests[%d] - tokentype wrong. expected=%q, got=%q",
+				i, tt.expectedType, tok.Type)
+		}
+
+		if tok.Literal != tt.expectedLiteral {
+			t.Fatalf("tests[%d] - literal wrong. expected=%q, got=%q",
+				i, tt.expectedLiteral, tok.Literal)
+		}
+	}
+
+	if len(l.Errors()) != 0 {
+		t.Fatalf("unexpected errors: %v", l.Errors())
+	}
+}
+
+func TestMalformedNumberLiterals(t *testing.T) {
+	tests := []struct {
+		input         string
+		expectedError string
+	}{
+		{"0x", "1:1: invalid number literal 0x: hexadecimal literal has no digits"},
+		{"0b102", "1:1: invalid number literal 0b102: invalid digit '2' in binary literal"},
+		{"0o78", "1:1: invalid number literal 0o78: invalid digit '8' in octal literal"},
+		{"0xFG", "1:1: invalid number literal 0xFG: invalid digit 'G' in hexadecimal literal"},
+		{"1__0", "1:1: invalid number literal 1__0: '_' must separate successive digits"},
+		{"10_", "1:1: invalid number literal 10_: '_' must separate successive digits"},
+		{"0x__1", "1:1: invalid number literal 0x__1: '_' must separate successive digits"},
+		{"0b_", "1:1: invalid number literal 0b_: binary literal has no digits"},
+		{"0755", "1:1: invalid number literal 0755: leading zeros are not allowed, use 0o for octal"},
+		{"00", "1:1: invalid number literal 00: leading zeros are not allowed, use 0o for octal"},
+		{"1.5_", "1:1: invalid number literal 1.5_: '_' must separate successive digits"},
+		{"1e", "1:1: invalid number literal 1e: exponent has no digits"},
+		{"1e+", "1:1: invalid number literal 1e+: exponent has no digits"},
+	}
+
+	for _, tt := range tests {
+		l := New(tt.input)
+		tok := l.NextToken()
+
+		if tok.Type != token.UNKNOWN {
+			t.Errorf("%s: tokentype wrong. expected=%q, got=%q", tt.input, token.UNKNOWN, tok.Type)
+		}
+		if tok.Literal != tt.input {
+			t.Errorf("%s: literal wrong. expected=%q, got=%q", tt.input, tt.input, tok.Literal)
+		}
+
+		errors := l.Errors()
+		if len(errors) != 1 {
+			t.Fatalf("%s: wrong number of errors. expected=1, got=%d", tt.input, len(errors))
+		}
+		if errors[0].Error() != tt.expectedError {
+			t.Errorf("%s: wrong error. expected=%q, got=%q", tt.input, tt.expectedError, errors[0].Error())
+		}
+	}
+}
@@ -1,15 +1,37 @@
 package main
 
 import (
+	"flag"
+	"kaze/eval"
 	"kaze/repl"
 	"kaze/runner"
 	"os"
 )
 
 func main() {
-	if len(os.Args) > 1 {
-		runner.RunFile(os.Args[1])
+	jsonDiagnostics := flag.Bool("json", false, "print diagnostics as JSON")
+	maxCallDepth := flag.Int("max-depth", 0, "maximum depth of nested function calls (0 for the default)")
+	maxSteps := flag.Int("max-steps", 0, "maximum number of loop iterations and function calls (0 for no limit)")
+	timeout := flag.Duration("timeout", 0, "maximum running time, such as 5s (0 for no limit)")
+	useVM := flag.Bool("vm", false, "run on the bytecode VM")
+	flag.Parse()
+
+	if flag.NArg() > 0 {
+		eval.Args = scriptArgs(flag.CommandLine)
+		runner.RunFile(flag.Arg(0), runner.Options{
+			JSONDiagnostics: *jsonDiagnostics,
+			MaxCallDepth:    *maxCallDepth,
+			MaxSteps:        *maxSteps,
+			Timeout:         *timeout,
+			UseVM:           *useVM,
+		})
 		return
 	}
 	repl.Start(os.Stdin, os.Stdout)
 }
+
+// scriptArgs returns the arguments a script sees: the program name, the
+// script path and the arguments after it, without the interpreter's flags.
+func scriptArgs(flags *flag.FlagSet) []string {
+	return append([]string{os.Args[0]}, flags.Args()...)
+}
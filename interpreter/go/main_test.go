@@ -0,0 +1,22 @@
+package main
+
+import (
+	"flag"
+	"os"
+	"reflect"
+	"testing"
+)
+
+func TestScriptArgs(t *testing.T) {
+	flags := flag.NewFlagSet("kaze", flag.ContinueOnError)
+	flags.Bool("json", false, "")
+	flags.Int("max-depth", 0, "")
+	if err := flags.Parse([]string{"-json", "-max-depth", "10", "main.kz", "x", "-y"}); err != nil {
+		t.Fatalf("parse error: %s", err)
+	}
+
+	expected := []string{os.Args[0], "main.kz", "x", "-y"}
+	if got := scriptArgs(flags); !reflect.DeepEqual(got, expected) {
+		t.Errorf("wrong arguments. expected=%q, got=%q", expected, got)
+	}
+}
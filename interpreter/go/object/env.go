@@ -1,8 +1,92 @@
 package object
 
+import (
+	"context"
+	"fmt"
+	"kaze/token"
+	"time"
+)
+
+// Environment is a scope of variables. The top level of a program keeps its
+// globals by name; the other scopes keep their locals in the slots assigned
+// by the resolver.
 type Environment struct {
-	store map[string]Object
-	outer *Environment
+	store  map[string]Object
+	slots  []Object
+	outer  *Environment
+	frame  *Frame
+	limits *Limits
+}
+
+// Frame is an entry of the script call stack. Frames are linked through the
+// environments of the functions being executed.
+type Frame struct {
+	Function string         // name of the called function
+	Call     token.Position // position of the call expression
+	Caller   *Frame         // nil if called from the top level
+	Depth    int            // number of frames up to and including this one
+}
+
+// Limits bounds the resources a script may use. It is shared by all the
+// environments of one evaluation.
+type Limits struct {
+	MaxCallDepth int       // 0 means the evaluator's default
+	MaxSteps     int       // 0 means no limit; see Step
+	Deadline     time.Time // the zero time means no deadline
+
+	ctx        context.Context
+	steps      int
+	untilCheck int // steps until the deadline and context are checked again
+}
+
+// checkInterval is how many steps pass between checks of the clock and the
+// context, which are too slow to make on every step.
+const checkInterval = 1024
+
+// WithContext returns a copy of l, which may be nil, that also stops the
+// evaluation when ctx is done. The copy counts its steps from zero.
+func (l *Limits) WithContext(ctx context.Context) *Limits {
+	limits := &Limits{ctx: ctx}
+	if l != nil {
+		limits.MaxCallDepth = l.MaxCallDepth
+		limits.MaxSteps = l.MaxSteps
+		limits.Deadline = l.Deadline
+	}
+	return limits
+}
+
+// Step counts a step of the evaluation: an iteration of a loop or a function
+// call. It returns a fatal error once the evaluation has run out of steps or
+// time, or its context has been canceled. A nil *Limits never stops it.
+func (l *Limits) Step() *Error {
+	if l == nil {
+		return nil
+	}
+
+	l.steps++
+	if l.MaxSteps > 0 && l.steps > l.MaxSteps {
+		return &Error{Kind: STEP_LIMIT_ERROR, Message: fmt.Sprintf("step limit of %d reached", l.MaxSteps), Fatal: true}
+	}
+
+	if l.untilCheck > 0 {
+		l.untilCheck--
+		return nil
+	}
+	l.untilCheck = checkInterval - 1
+
+	if !l.Deadline.IsZero() && !time.Now().Before(l.Deadline) {
+		return &Error{Kind: TIMEOUT_ERROR, Message: "timed out", Fatal: true}
+	}
+	if l.ctx != nil {
+		switch l.ctx.Err() {
+		case nil:
+		case context.DeadlineExceeded:
+			return &Error{Kind: TIMEOUT_ERROR, Message: "timed out", Fatal: true}
+		default:
+			return &Error{Kind: CANCELED_ERROR, Message: "canceled", Fatal: true}
+		}
+	}
+	return nil
 }
 
 func NewEnvironment() *Environment {
@@ -10,12 +94,61 @@ func NewEnvironment() *Environment {
 	return &Environment{store: s}
 }
 
-func NewEnclosedEnvironment(outer *Environment) *Environment {
-	env := NewEnvironment()
-	env.outer = outer
+// NewEnclosedEnvironment returns a local scope of outer with the given
+// number of slots.
+func NewEnclosedEnvironment(outer *Environment, size int) *Environment {
+	return &Environment{
+		slots:  make([]Object, size),
+		outer:  outer,
+		frame:  outer.frame,
+		limits: outer.limits,
+	}
+}
+
+// NewFunctionEnvironment returns the scope of a function call. Its variables
+// are enclosed by the function's defining environment, but it runs in the
+// given call frame and under the limits of the caller.
+func NewFunctionEnvironment(outer *Environment, caller *Environment, frame *Frame, size int) *Environment {
+	env := NewEnclosedEnvironment(outer, size)
+	env.frame = frame
+	env.limits = caller.limits
 	return env
 }
 
+// Frame returns the innermost call frame, or nil at the top level.
+func (e *Environment) Frame() *Frame {
+	return e.frame
+}
+
+// Limits returns the limits set with SetLimits, or nil if there are none.
+func (e *Environment) Limits() *Limits {
+	return e.limits
+}
+
+// SetLimits sets the limits of evaluations in e. Environments created from e
+// afterwards share them.
+func (e *Environment) SetLimits(limits *Limits) {
+	e.limits = limits
+}
+
+// GetAt returns the local in slot of the scope depth scopes out from e, or
+// nil if it hasn't been set.
+func (e *Environment) GetAt(depth, slot int) Object {
+	for ; depth > 0; depth-- {
+		e = e.outer
+	}
+	return e.slots[slot]
+}
+
+func (e *Environment) SetAt(depth, slot int, val Object) Object {
+	for ; depth > 0; depth-- {
+		e = e.outer
+	}
+	e.slots[slot] = val
+	return val
+}
+
+// Get looks up a global by name.
 func (e *Environment) Get(name string) (Object, bool) {
 	obj, ok := e.store[name]
 	if !ok && e.outer != nil {
@@ -24,7 +157,12 @@ func (e *Environment) Get(name string) (Object, bool) {
 	return obj, ok
 }
 
+// Create defines name in e. Resolved programs only create globals, but code
+// that wasn't resolved also declares its locals by name.
 func (e *Environment) Create(name string, val Object) Object {
+	if e.store == nil {
+		e.store = make(map[string]Object)
+	}
 	e.store[name] = val
 	return val
 }
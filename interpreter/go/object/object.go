@@ -4,6 +4,12 @@ import (
 	"fmt"
 	"hash/fnv"
 	"kaze/ast"
+	"kaze/code"
+	"kaze/diag"
+	"kaze/token"
+	"math"
+	"math/big"
+	"sort"
 	"strconv"
 	"strings"
 )
@@ -21,31 +27,150 @@ type Printable interface {
 }
 
 const (
-	ERROR_OBJ    = "ERROR"
-	NULL_OBJ     = "NULL"
-	INTEGER_OBJ  = "INTEGER"
-	BOOLEAN_OBJ  = "BOOLEAN"
-	STRING_OBJ   = "STRING"
-	RETURN_OBJ   = "RETURN"
-	FUNCTION_OBJ = "FUNCTION"
-	BREAK_OBJ    = "BREAK"
-	CONTINUE_OBJ = "CONTINUE"
-	BUILTIN_OBJ  = "BUILTIN"
-	HASH_OBJ     = "HASH"
-	ARRAY_OBJ    = "ARRAY"
-	LVALUE_OBJ   = "LVALUE"
+	ERROR_OBJ     = "ERROR"
+	NULL_OBJ      = "NULL"
+	INTEGER_OBJ   = "INTEGER"
+	FLOAT_OBJ     = "FLOAT"
+	BOOLEAN_OBJ   = "BOOLEAN"
+	STRING_OBJ    = "STRING"
+	RETURN_OBJ    = "RETURN"
+	FUNCTION_OBJ  = "FUNCTION"
+	BREAK_OBJ     = "BREAK"
+	CONTINUE_OBJ  = "CONTINUE"
+	BUILTIN_OBJ   = "BUILTIN"
+	HASH_OBJ      = "HASH"
+	ARRAY_OBJ     = "ARRAY"
+	LVALUE_OBJ    = "LVALUE"
+	RANGE_OBJ     = "RANGE"
+	EXCEPTION_OBJ = "EXCEPTION"
+	TAIL_CALL_OBJ = "TAIL_CALL"
+
+	COMPILED_FUNCTION_OBJ = "COMPILED_FUNCTION"
+	UPVALUE_OBJ           = "UPVALUE"
 )
 
+// Kinds of runtime errors. A script can throw errors of any kind.
+const (
+	ERROR               = "Error"
+	RUNTIME_ERROR       = "RuntimeError"
+	TYPE_ERROR          = "TypeError"
+	NAME_ERROR          = "NameError"
+	INDEX_ERROR         = "IndexError"
+	ZERO_DIVISION_ERROR = "ZeroDivisionError"
+	ARGUMENT_ERROR      = "ArgumentError"
+	VALUE_ERROR         = "ValueError"
+	INTERNAL_ERROR      = "InternalError"
+	RECURSION_ERROR     = "RecursionError"
+
+	// The errors of an evaluation that ran out of its budget. They are
+	// fatal: a script can't catch them and carry on.
+	STEP_LIMIT_ERROR = "StepLimitError"
+	TIMEOUT_ERROR    = "TimeoutError"
+	CANCELED_ERROR   = "CanceledError"
+)
+
+// MAX_TRACEBACK_REPEATS is how many times a traceback shows the same entry
+// in a row before summarizing the rest.
+const MAX_TRACEBACK_REPEATS = 3
+
+// Error is a runtime error. It propagates up through the evaluator until it
+// is caught by a try expression or reaches the host.
 type Error struct {
 	Message string
+	Kind    string
+	Span    diag.Span    // where the error was raised, if known
+	Stack   []TraceEntry // the call stack when the error was raised, outermost first
+	Fatal   bool         // try expressions don't catch it
+}
+
+// TraceEntry is one line of a stack trace: the function that was executing
+// and the position it had reached.
+type TraceEntry struct {
+	Function string
+	Pos      token.Position
+}
+
+func (t TraceEntry) String() string {
+	return t.Function + " (" + t.Pos.String() + ")"
 }
 
 func (e *Error) Type() ObjectType { return ERROR_OBJ }
-func (e *Error) Inspect() string  { return "ERROR: " + e.Message }
+func (e *Error) Inspect() string {
+	if e.Span.Start.IsValid() {
+		return "ERROR: " + e.Span.Start.String() + ": " + e.Message
+	}
+	return "ERROR: " + e.Message
+}
+func (e *Error) Diagnostic() *diag.Diagnostic {
+	var notes []string
+	for _, entry := range e.Stack {
+		notes = append(notes, "in "+entry.Function+" at "+entry.Pos.String())
+	}
+	return &diag.Diagnostic{
+		Severity: diag.ERROR,
+		Code:     diag.RUNTIME_ERROR,
+		Message:  e.Message,
+		Span:     e.Span,
+		Notes:    notes,
+	}
+}
+
+// Traceback renders the error like a Python traceback, quoting the source
+// line of each entry of the stack trace. Like Python, runs of identical
+// entries left by deep recursion are cut after a few repetitions.
+func (e *Error) Traceback(filename string, source string) string {
+	stack := e.Stack
+	if len(stack) == 0 && e.Span.Start.IsValid() {
+		stack = []TraceEntry{{Function: "<main>", Pos: e.Span.Start}}
+	}
+
+	var out strings.Builder
+	lines := strings.Split(source, "\n")
+	out.WriteString("Traceback (most recent call last):\n")
+	repeated := 0
+	for i, entry := range stack {
+		if i > 0 && entry == stack[i-1] {
+			repeated++
+		} else {
+			repeated = 0
+		}
+		if repeated >= MAX_TRACEBACK_REPEATS {
+			if i+1 == len(stack) || stack[i+1] != entry {
+				fmt.Fprintf(&out, "  [Previous line repeated %d more times]\n", repeated-MAX_TRACEBACK_REPEATS+1)
+			}
+			continue
+		}
+		fmt.Fprintf(&out, "  File %q, line %d, in %s\n", filename, entry.Pos.Line, entry.Function)
+		if entry.Pos.Line >= 1 && entry.Pos.Line <= len(lines) {
+			out.WriteString("    " + strings.TrimSpace(lines[entry.Pos.Line-1]) + "\n")
+		}
+	}
+
+	kind := e.Kind
+	if kind == "" {
+		kind = RUNTIME_ERROR
+	}
+	out.WriteString(kind + ": " + e.Message + "\n")
+	return out.String()
+}
 func (e *Error) String() string {
 	return e.Inspect()
 }
 
+// Exception is a caught error as seen by a script. Unlike Error it is an
+// ordinary value, so it doesn't propagate.
+type Exception struct {
+	Error *Error
+}
+
+func (e *Exception) Type() ObjectType { return EXCEPTION_OBJ }
+func (e *Exception) Inspect() string {
+	return e.Error.Kind + ": " + e.Error.Message
+}
+func (e *Exception) String() string {
+	return e.Inspect()
+}
+
 type Null struct{}
 
 func (n *Null) Type() ObjectType { return NULL_OBJ }
@@ -69,6 +194,7 @@ type Hashable interface {
 type HashKey struct {
 	Type  ObjectType
 	Value uint64
+	Big   string // the digits of a BigInt, whose keys have no Value
 }
 
 type Integer struct {
@@ -84,6 +210,51 @@ func (i *Integer) String() string {
 	return i.Inspect()
 }
 
+// BigInt is an integer outside the int64 range. Integer arithmetic produces a
+// BigInt when a result overflows, and goes back to Integer when it fits again,
+// so a BigInt never holds a value an Integer could. It reports INTEGER_OBJ and
+// behaves like an Integer everywhere else.
+type BigInt struct {
+	Value *big.Int
+}
+
+// NewInteger returns value as an Integer if it fits in int64, or a BigInt.
+func NewInteger(value *big.Int) Object {
+	if value.IsInt64() {
+		return &Integer{Value: value.Int64()}
+	}
+	return &BigInt{Value: value}
+}
+
+func (bi *BigInt) Type() ObjectType { return INTEGER_OBJ }
+func (bi *BigInt) Inspect() string  { return bi.Value.String() }
+func (bi *BigInt) HashKey() HashKey {
+	return HashKey{Type: bi.Type(), Big: bi.Value.String()}
+}
+func (bi *BigInt) String() string {
+	return bi.Inspect()
+}
+
+type Float struct {
+	Value float64
+}
+
+func (f *Float) Type() ObjectType { return FLOAT_OBJ }
+func (f *Float) Inspect() string {
+	s := strconv.FormatFloat(f.Value, 'g', -1, 64)
+	// keep a decimal point so 3.0 doesn't print like the integer 3
+	if !strings.ContainsAny(s, ".eIN") {
+		s += ".0"
+	}
+	return s
+}
+func (f *Float) HashKey() HashKey {
+	return HashKey{Type: f.Type(), Value: math.Float64bits(f.Value)}
+}
+func (f *Float) String() string {
+	return f.Inspect()
+}
+
 type Boolean struct {
 	Value bool
 }
@@ -130,10 +301,23 @@ type ReturnValue struct {
 func (rv *ReturnValue) Type() ObjectType { return RETURN_OBJ }
 func (rv *ReturnValue) Inspect() string  { return rv.Value.Inspect() }
 
+// TailCall is a call in tail position that has yet to be made. It is
+// returned to the caller's applyFunction, which makes the call in place of
+// the finished one, so tail calls don't grow the stack.
+type TailCall struct {
+	Function  *Function
+	Arguments []Object
+}
+
+func (tc *TailCall) Type() ObjectType { return TAIL_CALL_OBJ }
+func (tc *TailCall) Inspect() string  { return "tail call to " + tc.Function.Inspect() }
+
 type Function struct {
-	Parameters []*ast.Identifier
+	Name       string // empty for anonymous functions
+	Parameters []*ast.Parameter
 	Body       ast.Expression
 	Env        *Environment
+	Slots      int // the size of the scope of a call
 }
 
 func (f *Function) Type() ObjectType { return FUNCTION_OBJ }
@@ -142,12 +326,67 @@ func (f *Function) Inspect() string {
 	for _, p := range f.Parameters {
 		params = append(params, p.String())
 	}
-	return fmt.Sprintf("fn(%s) {\n%s\n}", strings.Join(params, ", "), f.Body.String())
+	return fmt.Sprintf("fn %s(%s) {\n%s\n}", f.Name, strings.Join(params, ", "), f.Body.String())
 }
 func (f *Function) String() string {
 	return f.Inspect()
 }
 
+// CompiledFunction is a function lowered to bytecode by the compiler.
+// Parameters are stored in the first local slots.
+type CompiledFunction struct {
+	Name         string // empty for anonymous functions
+	Parameters   []*ast.Parameter
+	Body         ast.Expression // kept to print the function like a Function
+	Instructions code.Instructions
+	Constants    []Object // the constant pool of the program the function is part of
+	Spans        []code.SourceSpan
+	NumLocals    int
+	MinArguments int  // the number of parameters up to the last one without a default
+	Rest         bool // the last parameter collects the remaining arguments
+}
+
+func (cf *CompiledFunction) Type() ObjectType { return COMPILED_FUNCTION_OBJ }
+func (cf *CompiledFunction) Inspect() string {
+	return fmt.Sprintf("CompiledFunction[%p]", cf)
+}
+
+// Closure is a compiled function value together with the variables it
+// captured. To scripts it's a FUNCTION like Function.
+type Closure struct {
+	Fn   *CompiledFunction
+	Free []*Upvalue
+}
+
+func (c *Closure) Type() ObjectType { return FUNCTION_OBJ }
+func (c *Closure) Inspect() string {
+	var params []string
+	for _, p := range c.Fn.Parameters {
+		params = append(params, p.String())
+	}
+	return fmt.Sprintf("fn %s(%s) {\n%s\n}", c.Fn.Name, strings.Join(params, ", "), c.Fn.Body.String())
+}
+func (c *Closure) String() string {
+	return c.Inspect()
+}
+
+// Upvalue is a variable captured by a closure. While the scope that declared
+// the variable runs, Location points at the variable's slot; once the scope
+// ends, the upvalue is closed and holds the value itself.
+type Upvalue struct {
+	Location *Object
+	closed   Object
+}
+
+func (u *Upvalue) Type() ObjectType { return UPVALUE_OBJ }
+func (u *Upvalue) Inspect() string  { return "upvalue" }
+
+// Close detaches u from the slot it points at.
+func (u *Upvalue) Close() {
+	u.closed = *u.Location
+	u.Location = &u.closed
+}
+
 type Break struct{}
 
 func (b *Break) Type() ObjectType { return BREAK_OBJ }
@@ -203,6 +442,42 @@ func (h *Hash) String() string {
 	return "{ " + strings.Join(pairs, ", ") + " }"
 }
 
+// SortedPairs returns the pairs ordered by their keys, so iteration over a
+// hash is deterministic. Keys are grouped by type, and numbers are in
+// numeric order.
+func (h *Hash) SortedPairs() []HashPair {
+	pairs := make([]HashPair, 0, len(h.Pairs))
+	for _, pair := range h.Pairs {
+		pairs = append(pairs, pair)
+	}
+	sort.Slice(pairs, func(i, j int) bool {
+		return lessKey(pairs[i].Key, pairs[j].Key)
+	})
+	return pairs
+}
+
+func lessKey(a, b Object) bool {
+	if a.Type() != b.Type() {
+		return a.Type() < b.Type()
+	}
+	switch a := a.(type) {
+	case *Integer, *BigInt:
+		return bigValue(a).Cmp(bigValue(b)) < 0
+	case *Float:
+		return a.Value < b.(*Float).Value
+	case *String:
+		return a.Value < b.(*String).Value
+	}
+	return a.Inspect() < b.Inspect()
+}
+
+func bigValue(obj Object) *big.Int {
+	if i, ok := obj.(*Integer); ok {
+		return big.NewInt(i.Value)
+	}
+	return obj.(*BigInt).Value
+}
+
 type Array struct {
 	Elements []Object
 }
@@ -229,6 +504,25 @@ func (a *Array) String() string {
 	return "[ " + strings.Join(elements, ", ") + " ]"
 }
 
+// Range is the integer interval Start..End, or Start..=End if Inclusive.
+// It is lazy: the elements are never materialized.
+type Range struct {
+	Start     int64
+	End       int64
+	Inclusive bool
+}
+
+func (r *Range) Type() ObjectType { return RANGE_OBJ }
+func (r *Range) Inspect() string {
+	if r.Inclusive {
+		return fmt.Sprintf("%d..=%d", r.Start, r.End)
+	}
+	return fmt.Sprintf("%d..%d", r.Start, r.End)
+}
+func (r *Range) String() string {
+	return r.Inspect()
+}
+
 type LValue interface {
 	Object
 	Get() (Object, bool)
@@ -253,6 +547,28 @@ func (v *Variable) Update(val Object) (Object, bool) {
 	return v.Env.Update(v.Name, val)
 }
 
+// Local is a variable in a slot of a local scope, as assigned by the
+// resolver.
+type Local struct {
+	Env   *Environment
+	Depth int
+	Slot  int
+}
+
+func (l *Local) Type() ObjectType {
+	return LVALUE_OBJ
+}
+func (l *Local) Inspect() string {
+	return LVALUE_OBJ
+}
+func (l *Local) Get() (Object, bool) {
+	val := l.Env.GetAt(l.Depth, l.Slot)
+	return val, val != nil
+}
+func (l *Local) Update(val Object) (Object, bool) {
+	return l.Env.SetAt(l.Depth, l.Slot, val), true
+}
+
 type IndexRef struct {
 	Left  LValue
 	Index Object
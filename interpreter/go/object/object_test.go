@@ -1,6 +1,12 @@
 package object
 
-import "testing"
+import (
+	"context"
+	"kaze/diag"
+	"kaze/token"
+	"math/big"
+	"testing"
+)
 
 func TestStringHashKey(t *testing.T) {
 	hello1 := &String{Value: "Hello World"}
@@ -19,6 +25,35 @@ func TestStringHashKey(t *testing.T) {
 	}
 }
 
+func TestBigIntHashKey(t *testing.T) {
+	big1, _ := new(big.Int).SetString("123456789012345678901234567890", 10)
+	big2, _ := new(big.Int).SetString("123456789012345678901234567890", 10)
+	diff, _ := new(big.Int).SetString("-123456789012345678901234567890", 10)
+
+	if (&BigInt{Value: big1}).HashKey() != (&BigInt{Value: big2}).HashKey() {
+		t.Fatalf("big integers with same value have different hash keys")
+	}
+	if (&BigInt{Value: big1}).HashKey() == (&BigInt{Value: diff}).HashKey() {
+		t.Fatalf("big integers with different values have same hash keys")
+	}
+
+	// The hash of the digits of 1 << 63 used to be this Integer's key.
+	huge := new(big.Int).Lsh(big.NewInt(1), 63)
+	if (&BigInt{Value: huge}).HashKey() == (&Integer{Value: -590260884831411150}).HashKey() {
+		t.Fatalf("big integer has the hash key of an integer")
+	}
+}
+
+func TestNewInteger(t *testing.T) {
+	if _, ok := NewInteger(big.NewInt(42)).(*Integer); !ok {
+		t.Fatalf("NewInteger(42) is not Integer")
+	}
+	huge := new(big.Int).Lsh(big.NewInt(1), 64)
+	if _, ok := NewInteger(huge).(*BigInt); !ok {
+		t.Fatalf("NewInteger(1 << 64) is not BigInt")
+	}
+}
+
 func TestLValueGet(t *testing.T) {
 	env := NewEnvironment()
 
@@ -208,3 +243,99 @@ func TestUpdateStringIndexRef(t *testing.T) {
 		t.Fatalf("stringRef.Update() did not update the string")
 	}
 }
+
+func TestErrorTraceback(t *testing.T) {
+	source := "fun f() {\n  1 / 0\n}\nf()"
+	err := &Error{
+		Message: "division by zero",
+		Kind:    ZERO_DIVISION_ERROR,
+		Stack: []TraceEntry{
+			{Function: "<main>", Pos: token.Position{Offset: 14, Line: 4, Column: 1}},
+			{Function: "f", Pos: token.Position{Offset: 12, Line: 2, Column: 3}},
+		},
+	}
+
+	expected := `Traceback (most recent call last):
+  File "test.kz", line 4, in <main>
+    f()
+  File "test.kz", line 2, in f
+    1 / 0
+ZeroDivisionError: division by zero
+`
+	if got := err.Traceback("test.kz", source); got != expected {
+		t.Errorf("wrong traceback.\nexpected=%q\ngot=%q", expected, got)
+	}
+
+	notes := err.Diagnostic().Notes
+	if len(notes) != 2 || notes[0] != "in <main> at 4:1" || notes[1] != "in f at 2:3" {
+		t.Errorf("wrong diagnostic notes: %q", notes)
+	}
+
+	err = &Error{Message: "oops", Span: diag.Span{Start: token.Position{Line: 1, Column: 1}}}
+	expected = "Traceback (most recent call last):\n  File \"test.kz\", line 1, in <main>\n    fun f() {\nRuntimeError: oops\n"
+	if got := err.Traceback("test.kz", source); got != expected {
+		t.Errorf("wrong traceback.\nexpected=%q\ngot=%q", expected, got)
+	}
+
+	recursive := TraceEntry{Function: "f", Pos: token.Position{Offset: 12, Line: 2, Column: 3}}
+	err = &Error{
+		Message: "maximum recursion depth exceeded",
+		Kind:    RECURSION_ERROR,
+		Stack:   []TraceEntry{{Function: "<main>", Pos: token.Position{Offset: 14, Line: 4, Column: 1}}},
+	}
+	for i := 0; i < 10; i++ {
+		err.Stack = append(err.Stack, recursive)
+	}
+	expected = `Traceback (most recent call last):
+  File "test.kz", line 4, in <main>
+    f()
+  File "test.kz", line 2, in f
+    1 / 0
+  File "test.kz", line 2, in f
+    1 / 0
+  File "test.kz", line 2, in f
+    1 / 0
+  [Previous line repeated 7 more times]
+RecursionError: maximum recursion depth exceeded
+`
+	if got := err.Traceback("test.kz", source); got != expected {
+		t.Errorf("wrong traceback.\nexpected=%q\ngot=%q", expected, got)
+	}
+}
+
+func TestLimitsStep(t *testing.T) {
+	var unlimited *Limits
+	if err := unlimited.Step(); err != nil {
+		t.Fatalf("nil limits stopped the evaluation: %s", err.Message)
+	}
+
+	limits := &Limits{MaxSteps: 3}
+	for i := 0; i < 3; i++ {
+		if err := limits.Step(); err != nil {
+			t.Fatalf("step %d failed: %s", i+1, err.Message)
+		}
+	}
+	if err := limits.Step(); err == nil || err.Kind != STEP_LIMIT_ERROR || !err.Fatal {
+		t.Errorf("step limit not enforced. got=%+v", err)
+	}
+
+	copied := limits.WithContext(context.Background())
+	if copied.MaxSteps != 3 {
+		t.Errorf("WithContext lost the step limit. got=%d", copied.MaxSteps)
+	}
+	if err := copied.Step(); err != nil {
+		t.Errorf("WithContext kept the step count: %s", err.Message)
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancelable := unlimited.WithContext(ctx)
+	cancelable.Step()
+	cancel()
+	var err *Error
+	for i := 0; i < checkInterval && err == nil; i++ {
+		err = cancelable.Step()
+	}
+	if err == nil || err.Kind != CANCELED_ERROR {
+		t.Errorf("cancellation not noticed within %d steps. got=%+v", checkInterval, err)
+	}
+}
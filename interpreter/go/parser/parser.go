@@ -1,11 +1,15 @@
 package parser
 
 import (
-	"fmt"
+	"errors"
 	"kaze/ast"
+	"kaze/diag"
 	"kaze/lexer"
 	"kaze/token"
+	"math/big"
+	"sort"
 	"strconv"
+	"strings"
 )
 
 type prefixParseFn func() ast.Expression
@@ -16,29 +20,49 @@ const (
 	LOWEST
 	ASSIGN      // =
 	ANDOR       // && or ||
+	BITOR       // |
+	BITXOR      // ^
+	BITAND      // &
 	EQUALS      // ==
 	LESSGREATER // > or <
+	RANGE       // .. or ..=
+	SHIFT       // << or >>
 	SUM         // +
-	PRODUCT     // *
-	PREFIX      // -X or !X
+	PRODUCT     // * or %
+	PREFIX      // -X or !X or ~X
 	INDEX       // array[X]
 	CALL        // myFunction(X)
 )
 
 var precedences = map[token.TokenType]int{
-	token.EQ:       EQUALS,
-	token.NOT_EQ:   EQUALS,
-	token.AND:      ANDOR,
-	token.OR:       ANDOR,
-	token.LT:       LESSGREATER,
-	token.GT:       LESSGREATER,
-	token.LE:       LESSGREATER,
-	token.GE:       LESSGREATER,
-	token.PLUS:     SUM,
-	token.MINUS:    SUM,
-	token.ASTERISK: PRODUCT,
-	token.SLASH:    PRODUCT,
-	token.ASSIGN:   ASSIGN,
+	token.EQ:        EQUALS,
+	token.NOT_EQ:    EQUALS,
+	token.AND:       ANDOR,
+	token.OR:        ANDOR,
+	token.LT:        LESSGREATER,
+	token.GT:        LESSGREATER,
+	token.LE:        LESSGREATER,
+	token.GE:        LESSGREATER,
+	token.PLUS:      SUM,
+	token.MINUS:     SUM,
+	token.ASTERISK:  PRODUCT,
+	token.SLASH:     PRODUCT,
+	token.PERCENT:   PRODUCT,
+	token.PIPE:      BITOR,
+	token.CARET:     BITXOR,
+	token.AMPERSAND: BITAND,
+	token.SHL:       SHIFT,
+	token.SHR:       SHIFT,
+	token.DOTDOT:    RANGE,
+	token.DOTDOT_EQ: RANGE,
+	token.ASSIGN:    ASSIGN,
+
+	token.PLUS_ASSIGN:     ASSIGN,
+	token.MINUS_ASSIGN:    ASSIGN,
+	token.ASTERISK_ASSIGN: ASSIGN,
+	token.SLASH_ASSIGN:    ASSIGN,
+	token.PERCENT_ASSIGN:  ASSIGN,
+
 	token.LPAREN:   CALL,
 	token.LBRACKET: INDEX,
 }
@@ -47,34 +71,52 @@ type Parser struct {
 	lexer     *lexer.Lexer
 	curToken  token.Token
 	peekToken token.Token
-	errors    []string
+	errors    []*diag.Diagnostic
+	recovered int // number of errors the parser has already resynchronized after
+	depth     int // number of braces open up to and including curToken
+
+	reportedLexerErrors map[*diag.Diagnostic]bool
 
 	prefixParseFns map[token.TokenType]prefixParseFn
 	infixParseFns  map[token.TokenType]infixParseFn
 }
 
 func New(l *lexer.Lexer) *Parser {
-	p := &Parser{lexer: l}
+	p := &Parser{lexer: l, reportedLexerErrors: make(map[*diag.Diagnostic]bool)}
 	p.prefixParseFns = make(map[token.TokenType]prefixParseFn)
 	p.registerPrefix(token.IDENT, p.parseIdentifier)
 	p.registerPrefix(token.INT, p.parseIntegerLiteral)
+	p.registerPrefix(token.FLOAT, p.parseFloatLiteral)
 	p.registerPrefix(token.TRUE, p.parseBoolean)
 	p.registerPrefix(token.FALSE, p.parseBoolean)
 	p.registerPrefix(token.MINUS, p.parsePrefixExpression)
 	p.registerPrefix(token.BANG, p.parsePrefixExpression)
+	p.registerPrefix(token.TILDE, p.parsePrefixExpression)
 	p.registerPrefix(token.LPAREN, p.parseGroupedExpression)
 	p.registerPrefix(token.LBRACE, p.parseBlockExpression)
 	p.registerPrefix(token.IF, p.parseIfExpression)
+	p.registerPrefix(token.TRY, p.parseTryExpression)
 	p.registerPrefix(token.STRING, p.parseStringLiteral)
+	p.registerPrefix(token.INTERP_START, p.parseInterpolatedString)
 	p.registerPrefix(token.HASH, p.parseHashLiteral)
 	p.registerPrefix(token.LBRACKET, p.parseArrayLiteral)
 	p.registerPrefix(token.NULL, p.parseNullLiteral)
+	p.registerPrefix(token.FUN, p.parseFunctionLiteral)
+	p.registerPrefix(token.ELLIPSIS, p.parseSpreadExpression)
 
 	p.infixParseFns = make(map[token.TokenType]infixParseFn)
 	p.registerInfix(token.PLUS, p.parseInfixExpression)
 	p.registerInfix(token.MINUS, p.parseInfixExpression)
 	p.registerInfix(token.ASTERISK, p.parseInfixExpression)
 	p.registerInfix(token.SLASH, p.parseInfixExpression)
+	p.registerInfix(token.PERCENT, p.parseInfixExpression)
+	p.registerInfix(token.AMPERSAND, p.parseInfixExpression)
+	p.registerInfix(token.PIPE, p.parseInfixExpression)
+	p.registerInfix(token.CARET, p.parseInfixExpression)
+	p.registerInfix(token.SHL, p.parseInfixExpression)
+	p.registerInfix(token.SHR, p.parseInfixExpression)
+	p.registerInfix(token.DOTDOT, p.parseInfixExpression)
+	p.registerInfix(token.DOTDOT_EQ, p.parseInfixExpression)
 	p.registerInfix(token.EQ, p.parseInfixExpression)
 	p.registerInfix(token.NOT_EQ, p.parseInfixExpression)
 	p.registerInfix(token.AND, p.parseInfixExpression)
@@ -84,6 +126,11 @@ func New(l *lexer.Lexer) *Parser {
 	p.registerInfix(token.LE, p.parseInfixExpression)
 	p.registerInfix(token.GE, p.parseInfixExpression)
 	p.registerInfix(token.ASSIGN, p.parseAssignExpression)
+	p.registerInfix(token.PLUS_ASSIGN, p.parseAssignExpression)
+	p.registerInfix(token.MINUS_ASSIGN, p.parseAssignExpression)
+	p.registerInfix(token.ASTERISK_ASSIGN, p.parseAssignExpression)
+	p.registerInfix(token.SLASH_ASSIGN, p.parseAssignExpression)
+	p.registerInfix(token.PERCENT_ASSIGN, p.parseAssignExpression)
 	p.registerInfix(token.LPAREN, p.parseCallExpression)
 	p.registerInfix(token.LBRACKET, p.parseIndexExpression)
 
@@ -103,6 +150,38 @@ func (p *Parser) registerInfix(tokenType token.TokenType, fn infixParseFn) {
 func (p *Parser) nextToken() {
 	p.curToken = p.peekToken
 	p.peekToken = p.lexer.NextToken()
+	switch p.curToken.Type {
+	case token.LBRACE:
+		p.depth++
+	case token.RBRACE:
+		p.depth--
+	}
+}
+
+// unknownTokenError reports the lexer errors for an UNKNOWN token in place of
+// a parser error, so they count against the statement containing the token.
+func (p *Parser) unknownTokenError(tok token.Token) {
+	for _, err := range p.lexer.Errors() {
+		offset := err.Span.Start.Offset
+		if tok.Pos.Offset <= offset && offset < tok.End.Offset && !p.reportedLexerErrors[err] {
+			p.reportedLexerErrors[err] = true
+			p.errors = append(p.errors, err)
+		}
+	}
+}
+
+// collectLexerErrors adds the lexer errors the parser skipped over while
+// resynchronizing, and orders all errors by position.
+func (p *Parser) collectLexerErrors() {
+	for _, err := range p.lexer.Errors() {
+		if !p.reportedLexerErrors[err] {
+			p.reportedLexerErrors[err] = true
+			p.errors = append(p.errors, err)
+		}
+	}
+	sort.SliceStable(p.errors, func(i, j int) bool {
+		return p.errors[i].Span.Start.Offset < p.errors[j].Span.Start.Offset
+	})
 }
 
 func (p *Parser) curTokenIs(t token.TokenType) bool {
@@ -123,11 +202,26 @@ func (p *Parser) expectPeek(t token.TokenType) bool {
 }
 
 func (p *Parser) peekError(t token.TokenType) {
-	msg := fmt.Sprintf("expected next token to be %s, got %s instead", t, p.peekToken.Type)
-	p.errors = append(p.errors, msg)
+	if p.peekTokenIs(token.UNKNOWN) {
+		p.unknownTokenError(p.peekToken)
+		return
+	}
+	p.errorf(diag.UNEXPECTED_TOKEN, tokenSpan(p.peekToken), "expected next token to be %s, got %s instead", t, p.peekToken.Type)
+}
+
+func (p *Parser) errorf(code string, span diag.Span, format string, a ...interface{}) {
+	p.errors = append(p.errors, diag.Errorf(code, span, format, a...))
 }
 
-func (p *Parser) Errors() []string {
+func tokenSpan(tok token.Token) diag.Span {
+	return diag.Span{Start: tok.Pos, End: tok.End}
+}
+
+func nodeSpan(node ast.Node) diag.Span {
+	return diag.Span{Start: node.Pos(), End: node.End()}
+}
+
+func (p *Parser) Errors() []*diag.Diagnostic {
 	return p.errors
 }
 
@@ -150,17 +244,55 @@ func (p *Parser) ParseProgram() *ast.Program {
 	program.Statements = []ast.Statement{}
 
 	for !p.curTokenIs(token.EOF) {
-		stmt := p.parseStatement()
-		if stmt == nil {
-			return nil
+		if stmt := p.parseStatementOrRecover(); stmt != nil {
+			program.Statements = append(program.Statements, stmt)
 		}
-		program.Statements = append(program.Statements, stmt)
 		p.nextToken()
 	}
+	p.collectLexerErrors()
 
 	return program
 }
 
+// parseStatementOrRecover parses a statement and returns nil if it contained
+// syntax errors. In that case the parser is resynchronized so the caller can
+// carry on with the next statement and report further errors. Errors that a
+// nested block has already recovered from do not discard the statement.
+func (p *Parser) parseStatementOrRecover() ast.Statement {
+	depth := p.depth
+	if p.curTokenIs(token.LBRACE) {
+		depth--
+	}
+	errors, recovered := len(p.errors), p.recovered
+	stmt := p.parseStatement()
+	if unrecovered := (len(p.errors) - errors) - (p.recovered - recovered); unrecovered > 0 {
+		p.synchronize(depth)
+		p.recovered += unrecovered
+		return nil
+	}
+	return stmt
+}
+
+// synchronize skips the rest of a statement that started at brace depth
+// depth, until the current token ends it (; or the } of a brace it opened)
+// or the next token starts another statement or closes the enclosing block.
+// If the error was at the } of the enclosing block, the parser stays on it
+// so the block can end there.
+func (p *Parser) synchronize(depth int) {
+	for !p.curTokenIs(token.EOF) && p.depth >= depth {
+		if p.depth == depth {
+			if p.curTokenIs(token.SEMICOLON) || p.curTokenIs(token.RBRACE) {
+				return
+			}
+			switch p.peekToken.Type {
+			case token.VAR, token.FUN, token.WHILE, token.FOR, token.RETURN, token.THROW, token.RBRACE, token.EOF:
+				return
+			}
+		}
+		p.nextToken()
+	}
+}
+
 func (p *Parser) parseStatement() ast.Statement {
 	switch p.curToken.Type {
 	case token.VAR:
@@ -168,9 +300,16 @@ func (p *Parser) parseStatement() ast.Statement {
 	case token.RETURN:
 		return p.parseReturnStatement()
 	case token.FUN:
+		if p.peekTokenIs(token.LPAREN) {
+			return p.parseExpressionStatement()
+		}
 		return p.parseFunctionDefinitionStatement()
 	case token.WHILE:
 		return p.parseWhileStatement()
+	case token.FOR:
+		return p.parseForStatement()
+	case token.THROW:
+		return p.parseThrowStatement()
 	case token.BREAK:
 		stmt := &ast.BreakStatement{Token: p.curToken}
 		if p.peekTokenIs(token.SEMICOLON) {
@@ -222,6 +361,19 @@ func (p *Parser) parseReturnStatement() ast.Statement {
 	return stmt
 }
 
+func (p *Parser) parseThrowStatement() ast.Statement {
+	stmt := &ast.ThrowStatement{Token: p.curToken}
+	p.nextToken()
+	stmt.Value = p.parseExpression(LOWEST)
+	if stmt.Value == nil {
+		return nil
+	}
+	if p.peekTokenIs(token.SEMICOLON) {
+		p.nextToken()
+	}
+	return stmt
+}
+
 func (p *Parser) parseFunctionDefinitionStatement() ast.Statement {
 	stmt := &ast.FunctionDefinitionStatement{Token: p.curToken}
 	if !p.expectPeek(token.IDENT) {
@@ -239,26 +391,49 @@ func (p *Parser) parseFunctionDefinitionStatement() ast.Statement {
 		return nil
 	}
 	stmt.Body = p.parseBlockExpression()
+	markTailCalls(stmt.Body)
 	return stmt
 }
 
-func (p *Parser) parseFunctionParameters() []*ast.Identifier {
-	var params []*ast.Identifier
+func (p *Parser) parseFunctionLiteral() ast.Expression {
+	lit := &ast.FunctionLiteral{Token: p.curToken}
+	if !p.expectPeek(token.LPAREN) {
+		return nil
+	}
+	p.nextToken()
+	lit.Parameters = p.parseFunctionParameters()
+
+	if !p.expectPeek(token.LBRACE) {
+		return nil
+	}
+	lit.Body = p.parseBlockExpression()
+	markTailCalls(lit.Body)
+	return lit
+}
+
+func (p *Parser) parseFunctionParameters() []*ast.Parameter {
+	var params []*ast.Parameter
 
 	if p.curTokenIs(token.RPAREN) {
 		return params
 	}
 
-	param := &ast.Identifier{Token: p.curToken, Value: p.curToken.Literal}
-	params = append(params, param)
-
-	for p.peekTokenIs(token.COMMA) {
-		p.nextToken()
-		if !p.expectPeek(token.IDENT) {
+	for {
+		param := p.parseFunctionParameter()
+		if param == nil {
 			return nil
 		}
-		param = &ast.Identifier{Token: p.curToken, Value: p.curToken.Literal}
 		params = append(params, param)
+
+		if !p.peekTokenIs(token.COMMA) {
+			break
+		}
+		if param.Rest {
+			p.errorf(diag.INVALID_PARAMETER, nodeSpan(param), "rest parameter must be the last parameter")
+			return nil
+		}
+		p.nextToken()
+		p.nextToken()
 	}
 
 	if !p.expectPeek(token.RPAREN) {
@@ -267,6 +442,32 @@ func (p *Parser) parseFunctionParameters() []*ast.Identifier {
 	return params
 }
 
+func (p *Parser) parseFunctionParameter() *ast.Parameter {
+	param := &ast.Parameter{Token: p.curToken}
+
+	if p.curTokenIs(token.ELLIPSIS) {
+		param.Rest = true
+		if !p.expectPeek(token.IDENT) {
+			return nil
+		}
+	} else if !p.curTokenIs(token.IDENT) {
+		p.errorf(diag.INVALID_PARAMETER, tokenSpan(p.curToken), "expected parameter name, got %s", p.curToken.Type)
+		return nil
+	}
+	param.Name = &ast.Identifier{Token: p.curToken, Value: p.curToken.Literal}
+
+	if !param.Rest && p.peekTokenIs(token.ASSIGN) {
+		p.nextToken()
+		p.nextToken()
+		param.Default = p.parseExpression(LOWEST)
+		if param.Default == nil {
+			return nil
+		}
+	}
+
+	return param
+}
+
 func (p *Parser) parseWhileStatement() ast.Statement {
 	stmt := &ast.WhileStatement{Token: p.curToken}
 	p.nextToken()
@@ -278,19 +479,68 @@ func (p *Parser) parseWhileStatement() ast.Statement {
 	return stmt
 }
 
+func (p *Parser) parseForStatement() ast.Statement {
+	stmt := &ast.ForStatement{Token: p.curToken}
+	if !p.expectPeek(token.IDENT) {
+		return nil
+	}
+	stmt.Variables = append(stmt.Variables, &ast.Identifier{Token: p.curToken, Value: p.curToken.Literal})
+
+	if p.peekTokenIs(token.COMMA) {
+		p.nextToken()
+		if !p.expectPeek(token.IDENT) {
+			return nil
+		}
+		stmt.Variables = append(stmt.Variables, &ast.Identifier{Token: p.curToken, Value: p.curToken.Literal})
+	}
+
+	if !p.expectPeek(token.IN) {
+		return nil
+	}
+	p.nextToken()
+	stmt.Iterable = p.parseExpression(LOWEST)
+
+	if !p.expectPeek(token.LBRACE) {
+		return nil
+	}
+	stmt.Body = p.parseBlockExpression()
+	return stmt
+}
+
 func (p *Parser) parseExpressionStatement() *ast.ExpressionStatement {
 	stmt := &ast.ExpressionStatement{Token: p.curToken}
 	stmt.Expression = p.parseExpression(LOWEST)
+	if p.peekTokenIs(token.INCREMENT) || p.peekTokenIs(token.DECREMENT) {
+		p.nextToken()
+		stmt.Expression = p.parseIncrement(stmt.Expression)
+	}
 	if p.peekTokenIs(token.SEMICOLON) {
 		p.nextToken()
 	}
 	return stmt
 }
 
+// parseIncrement parses x++ or x--. Like in Go, they are statements, so
+// they can't be used inside another expression.
+func (p *Parser) parseIncrement(expression ast.Expression) ast.Expression {
+	switch expression.(type) {
+	case *ast.Identifier, *ast.IndexExpression:
+		return &ast.AssignExpression{Token: p.curToken, Operator: p.curToken.Literal, Left: expression}
+	case nil:
+		return nil
+	}
+	p.errorf(diag.INVALID_ASSIGN_LEFT, nodeSpan(expression), "unexpected expression on left side of %s: %T", p.curToken.Literal, expression)
+	return nil
+}
+
 func (p *Parser) parseExpression(precedence int) ast.Expression {
 	prefix := p.prefixParseFns[p.curToken.Type]
+	if prefix == nil && p.curTokenIs(token.UNKNOWN) {
+		p.unknownTokenError(p.curToken)
+		return nil
+	}
 	if prefix == nil {
-		p.errors = append(p.errors, fmt.Sprintf("no prefix parse function for %s found", p.curToken.Type))
+		p.errorf(diag.NO_PREFIX_PARSE_FN, tokenSpan(p.curToken), "no prefix parse function for %s found", p.curToken.Type)
 		return nil
 	}
 	leftExp := prefix()
@@ -313,10 +563,47 @@ func (p *Parser) parseIdentifier() ast.Expression {
 
 func (p *Parser) parseIntegerLiteral() ast.Expression {
 	lit := &ast.IntegerLiteral{Token: p.curToken}
-	value, err := strconv.ParseInt(p.curToken.Literal, 10, 64)
+	digits, base := integerDigits(p.curToken.Literal)
+	value, err := strconv.ParseInt(digits, base, 64)
+	if errors.Is(err, strconv.ErrRange) {
+		if big, ok := new(big.Int).SetString(digits, base); ok {
+			lit.Big = big
+			return lit
+		}
+	}
+	if err != nil {
+		p.errorf(diag.INVALID_INTEGER, tokenSpan(p.curToken), "could not parse %q as integer", p.curToken.Literal)
+		return nil
+	}
+	lit.Value = value
+	return lit
+}
+
+// integerDigits strips the base prefix and digit separators from an integer
+// literal and returns the digits with their base.
+func integerDigits(literal string) (string, int) {
+	base := 10
+	if len(literal) > 2 && literal[0] == '0' {
+		switch literal[1] {
+		case 'x', 'X':
+			base = 16
+		case 'o', 'O':
+			base = 8
+		case 'b', 'B':
+			base = 2
+		}
+	}
+	if base != 10 {
+		literal = literal[2:]
+	}
+	return strings.ReplaceAll(literal, "_", ""), base
+}
+
+func (p *Parser) parseFloatLiteral() ast.Expression {
+	lit := &ast.FloatLiteral{Token: p.curToken}
+	value, err := strconv.ParseFloat(strings.ReplaceAll(p.curToken.Literal, "_", ""), 64)
 	if err != nil {
-		msg := fmt.Sprintf("could not parse %q as integer", p.curToken.Literal)
-		p.errors = append(p.errors, msg)
+		p.errorf(diag.INVALID_FLOAT, tokenSpan(p.curToken), "could not parse %q as float", p.curToken.Literal)
 		return nil
 	}
 	lit.Value = value
@@ -356,20 +643,20 @@ func (p *Parser) parseAssignExpression(expression ast.Expression) ast.Expression
 	case *ast.IndexExpression:
 		return p.parseAssignToIndex(expression)
 	}
-	p.errors = append(p.errors, fmt.Sprintf("unexpected expression on left side of =: %T", expression))
+	p.errorf(diag.INVALID_ASSIGN_LEFT, nodeSpan(expression), "unexpected expression on left side of %s: %T", p.curToken.Literal, expression)
 	return nil
 }
 
 func (p *Parser) parseAssignToIndex(expression ast.Expression) ast.Expression {
 	indexExp, ok := expression.(*ast.IndexExpression)
 	if !ok {
-		msg := fmt.Sprintf("expected index expression on left side of =, got %T", expression)
-		p.errors = append(p.errors, msg)
+		p.errorf(diag.INVALID_ASSIGN_LEFT, nodeSpan(expression), "expected index expression on left side of %s, got %T", p.curToken.Literal, expression)
 		return nil
 	}
 	exp := &ast.AssignExpression{
-		Token: p.curToken,
-		Left:  indexExp,
+		Token:    p.curToken,
+		Operator: p.curToken.Literal,
+		Left:     indexExp,
 	}
 	precedence := p.curPrecedence()
 	p.nextToken()
@@ -380,13 +667,13 @@ func (p *Parser) parseAssignToIndex(expression ast.Expression) ast.Expression {
 func (p *Parser) parseAssignToVariable(expression ast.Expression) ast.Expression {
 	ident, ok := expression.(*ast.Identifier)
 	if !ok {
-		msg := fmt.Sprintf("expected identifier on left side of =, got %T", expression)
-		p.errors = append(p.errors, msg)
+		p.errorf(diag.INVALID_ASSIGN_LEFT, nodeSpan(expression), "expected identifier on left side of %s, got %T", p.curToken.Literal, expression)
 		return nil
 	}
 	exp := &ast.AssignExpression{
-		Token: p.curToken,
-		Left:  ident,
+		Token:    p.curToken,
+		Operator: p.curToken.Literal,
+		Left:     ident,
 	}
 	precedence := p.curPrecedence()
 	p.nextToken()
@@ -394,27 +681,154 @@ func (p *Parser) parseAssignToVariable(expression ast.Expression) ast.Expression
 	return exp
 }
 
+// parseGroupedExpression parses a parenthesized expression. A parenthesized
+// list followed by => is the parameter list of an arrow function instead.
 func (p *Parser) parseGroupedExpression() ast.Expression {
+	lparen := p.curToken
+	if p.peekTokenIs(token.RPAREN) {
+		p.nextToken()
+		return p.parseArrowFunction(lparen, nil)
+	}
+
 	p.nextToken()
-	exp := p.parseExpression(LOWEST)
+	exps := []ast.Expression{p.parseExpression(LOWEST)}
+	for p.peekTokenIs(token.COMMA) {
+		p.nextToken()
+		p.nextToken()
+		exps = append(exps, p.parseExpression(LOWEST))
+	}
+
 	if !p.expectPeek(token.RPAREN) {
 		return nil
 	}
+	if len(exps) == 1 && !p.peekTokenIs(token.ARROW) {
+		return exps[0]
+	}
+	return p.parseArrowFunction(lparen, exps)
+}
+
+func (p *Parser) parseArrowFunction(lparen token.Token, params []ast.Expression) ast.Expression {
+	lit := &ast.FunctionLiteral{Token: lparen}
+	if !p.expectPeek(token.ARROW) {
+		return nil
+	}
+
+	for i, exp := range params {
+		param := expressionToParameter(exp)
+		if param == nil {
+			if exp != nil {
+				p.errorf(diag.INVALID_PARAMETER, nodeSpan(exp), "expected identifier as parameter, got %s", exp.String())
+			}
+			return nil
+		}
+		if param.Rest && i < len(params)-1 {
+			p.errorf(diag.INVALID_PARAMETER, nodeSpan(param), "rest parameter must be the last parameter")
+			return nil
+		}
+		lit.Parameters = append(lit.Parameters, param)
+	}
+
+	p.nextToken()
+	lit.Body = p.parseExpression(LOWEST)
+	if lit.Body == nil {
+		return nil
+	}
+	markTailCalls(lit.Body)
+	return lit
+}
+
+// markTailCalls marks the calls in tail position of a function body: the
+// value of a return statement and the final expression of the body, through
+// blocks and if branches. Nothing inside a try expression is in tail
+// position, since catch and finally still have to run after the call.
+// Nested functions are marked when they are parsed.
+func markTailCalls(node ast.Expression) {
+	switch node := node.(type) {
+	case *ast.CallExpression:
+		node.Tail = true
+	case *ast.BlockExpression:
+		for i, stmt := range node.Statements {
+			if exp, ok := stmt.(*ast.ExpressionStatement); ok && i == len(node.Statements)-1 {
+				markTailCalls(exp.Expression)
+			} else {
+				markReturnTailCalls(stmt)
+			}
+		}
+	case *ast.IfExpression:
+		markTailCalls(node.Consequence)
+		markTailCalls(node.Alternative)
+	}
+}
+
+// markReturnTailCalls marks the tail calls of the return statements in a
+// statement that is not itself in tail position.
+func markReturnTailCalls(node ast.Node) {
+	switch node := node.(type) {
+	case *ast.ReturnStatement:
+		markTailCalls(node.ReturnValue)
+	case *ast.ExpressionStatement:
+		markReturnTailCalls(node.Expression)
+	case *ast.WhileStatement:
+		markReturnTailCalls(node.Body)
+	case *ast.ForStatement:
+		markReturnTailCalls(node.Body)
+	case *ast.BlockExpression:
+		for _, stmt := range node.Statements {
+			markReturnTailCalls(stmt)
+		}
+	case *ast.IfExpression:
+		markReturnTailCalls(node.Consequence)
+		markReturnTailCalls(node.Alternative)
+	}
+}
+
+// expressionToParameter reinterprets an expression parsed inside the
+// parentheses of an arrow function as a parameter. It returns nil if the
+// expression can't be a parameter.
+func expressionToParameter(exp ast.Expression) *ast.Parameter {
+	switch exp := exp.(type) {
+	case *ast.Identifier:
+		return &ast.Parameter{Token: exp.Token, Name: exp}
+	case *ast.AssignExpression:
+		// (a += 1) => a is not a default value.
+		if ident, ok := exp.Left.(*ast.Identifier); ok && exp.Operator == "=" {
+			return &ast.Parameter{Token: ident.Token, Name: ident, Default: exp.Value}
+		}
+	case *ast.SpreadExpression:
+		if ident, ok := exp.Value.(*ast.Identifier); ok {
+			return &ast.Parameter{Token: exp.Token, Name: ident, Rest: true}
+		}
+	}
+	return nil
+}
+
+func (p *Parser) parseSpreadExpression() ast.Expression {
+	exp := &ast.SpreadExpression{Token: p.curToken}
+	p.nextToken()
+	exp.Value = p.parseExpression(LOWEST)
+	if exp.Value == nil {
+		return nil
+	}
 	return exp
 }
 
 func (p *Parser) parseBlockExpression() ast.Expression {
 	block := &ast.BlockExpression{Token: p.curToken}
 	block.Statements = []ast.Statement{}
+	depth := p.depth
 
 	p.nextToken()
 	for !p.curTokenIs(token.RBRACE) && !p.curTokenIs(token.EOF) {
-		stmt := p.parseStatement()
-		if stmt != nil {
+		if stmt := p.parseStatementOrRecover(); stmt != nil {
 			block.Statements = append(block.Statements, stmt)
 		}
+		if p.depth < depth {
+			// The statement's error was at the } of this block.
+			break
+		}
 		p.nextToken()
 	}
+	block.RBrace = p.curToken
 
 	return block
 }
@@ -424,6 +838,7 @@ func (p *Parser) parseCallExpression(function ast.Expression) ast.Expression {
 
 	p.nextToken()
 	exp.Arguments = p.parseEnclosedExpressions(token.RPAREN)
+	exp.RParen = p.curToken
 
 	return exp
 }
@@ -503,20 +918,103 @@ func (p *Parser) parseIfExpression() ast.Expression {
 	return exp
 }
 
+func (p *Parser) parseTryExpression() ast.Expression {
+	exp := &ast.TryExpression{Token: p.curToken}
+
+	if !p.expectPeek(token.LBRACE) {
+		return nil
+	}
+	exp.Block = p.parseBlockExpression()
+
+	if p.peekTokenIs(token.CATCH) {
+		p.nextToken()
+		if p.peekTokenIs(token.LPAREN) {
+			p.nextToken()
+			if !p.expectPeek(token.IDENT) {
+				return nil
+			}
+			exp.CatchParam = &ast.Identifier{Token: p.curToken, Value: p.curToken.Literal}
+			if !p.expectPeek(token.RPAREN) {
+				return nil
+			}
+		}
+		if !p.expectPeek(token.LBRACE) {
+			return nil
+		}
+		exp.Catch = p.parseBlockExpression()
+	}
+
+	if p.peekTokenIs(token.FINALLY) {
+		p.nextToken()
+		if !p.expectPeek(token.LBRACE) {
+			return nil
+		}
+		exp.Finally = p.parseBlockExpression()
+	}
+
+	if exp.Catch == nil && exp.Finally == nil {
+		p.errorf(diag.UNEXPECTED_TOKEN, tokenSpan(p.peekToken), "expected catch or finally after try block, got %s instead", p.peekToken.Type)
+		return nil
+	}
+
+	return exp
+}
+
 func (p *Parser) parseStringLiteral() ast.Expression {
 	return &ast.StringLiteral{Token: p.curToken, Value: p.curToken.Literal}
 }
 
+func (p *Parser) parseInterpolatedString() ast.Expression {
+	exp := &ast.InterpolatedString{Token: p.curToken}
+	exp.Parts = append(exp.Parts, p.parseStringLiteral())
+
+	for {
+		p.nextToken()
+		exp.Parts = append(exp.Parts, p.parseExpression(LOWEST))
+
+		if p.peekTokenIs(token.INTERP_MID) {
+			p.nextToken()
+			exp.Parts = append(exp.Parts, p.parseStringLiteral())
+			continue
+		}
+		if !p.expectPeek(token.INTERP_END) {
+			return nil
+		}
+		exp.Parts = append(exp.Parts, p.parseStringLiteral())
+		return exp
+	}
+}
+
+// parseIndexExpression parses xs[i] as well as the slice forms xs[i:j],
+// xs[:j], xs[i:] and xs[:].
 func (p *Parser) parseIndexExpression(expression ast.Expression) ast.Expression {
-	exp := &ast.IndexExpression{
-		Token: p.curToken,
-		Left:  expression,
+	lbracket := p.curToken
+
+	var index ast.Expression
+	if !p.peekTokenIs(token.COLON) {
+		p.nextToken()
+		index = p.parseExpression(LOWEST)
+	}
+
+	if !p.peekTokenIs(token.COLON) {
+		exp := &ast.IndexExpression{Token: lbracket, Left: expression, Index: index}
+		if !p.expectPeek(token.RBRACKET) {
+			return nil
+		}
+		exp.RBracket = p.curToken
+		return exp
 	}
 	p.nextToken()
-	exp.Index = p.parseExpression(LOWEST)
+
+	exp := &ast.SliceExpression{Token: lbracket, Left: expression, Low: index}
+	if !p.peekTokenIs(token.RBRACKET) {
+		p.nextToken()
+		exp.High = p.parseExpression(LOWEST)
+	}
 	if !p.expectPeek(token.RBRACKET) {
 		return nil
 	}
+	exp.RBracket = p.curToken
 	return exp
 }
 
@@ -544,6 +1042,7 @@ func (p *Parser) parseHashLiteral() ast.Expression {
 	if !p.expectPeek(token.RBRACE) {
 		return nil
 	}
+	hash.RBrace = p.curToken
 
 	return hash
 }
@@ -552,6 +1051,7 @@ func (p *Parser) parseArrayLiteral() ast.Expression {
 	array := &ast.ArrayLiteral{Token: p.curToken}
 	p.nextToken()
 	array.Elements = p.parseEnclosedExpressionsTrailingComma(token.RBRACKET)
+	array.RBracket = p.curToken
 	return array
 }
 
@@ -2,6 +2,7 @@ package parser
 
 import (
 	"kaze/ast"
+	"kaze/diag"
 	"kaze/lexer"
 	"strconv"
 	"testing"
@@ -55,6 +56,8 @@ func testLiteralExpression(t *testing.T, exp ast.Expression, expected interface{
 		return testIntegerLiteral(t, exp, int64(v))
 	case int64:
 		return testIntegerLiteral(t, exp, v)
+	case float64:
+		return testFloatLiteral(t, exp, v)
 	case string:
 		switch exp.(type) {
 		case *ast.StringLiteral:
@@ -70,6 +73,21 @@ func testLiteralExpression(t *testing.T, exp ast.Expression, expected interface{
 	return false
 }
 
+func testFloatLiteral(t *testing.T, exp ast.Expression, value float64) bool {
+	fl, ok := exp.(*ast.FloatLiteral)
+	if !ok {
+		t.Errorf("exp not *ast.FloatLiteral. got=%T", exp)
+		return false
+	}
+
+	if fl.Value != value {
+		t.Errorf("fl.Value not %g. got=%g", value, fl.Value)
+		return false
+	}
+
+	return true
+}
+
 func testStringLiteral(t *testing.T, exp ast.Expression, v string) bool {
 	str, ok := exp.(*ast.StringLiteral)
 	if !ok {
@@ -157,8 +175,8 @@ func checkParserErrors(t *testing.T, p *Parser) {
 	}
 
 	t.Errorf("parser has %d errors", len(errors))
-	for _, msg := range errors {
-		t.Errorf("parser error: %q", msg)
+	for _, err := range errors {
+		t.Errorf("parser error: %q", err.Error())
 	}
 	t.FailNow()
 }
@@ -170,6 +188,7 @@ func TestVarStatements(t *testing.T) {
 		expectedValue      interface{}
 	}{
 		{"var x = 5;", "x", 5},
+		{"var f = 2.5;", "f", 2.5},
 		{"var y = true;", "y", true},
 		{"var foobar = y;", "foobar", "y"},
 		{`var hoge = "hoge";`, "hoge", "hoge"},
@@ -215,6 +234,13 @@ func TestInfixExpressions(t *testing.T) {
 		{"5 <= 5;", 5, "<=", 5},
 		{"5 == 5;", 5, "==", 5},
 		{"5 != 5;", 5, "!=", 5},
+		{"1.5 * 2;", 1.5, "*", 2},
+		{"5 % 5;", 5, "%", 5},
+		{"5 & 5;", 5, "&", 5},
+		{"5 | 5;", 5, "|", 5},
+		{"5 ^ 5;", 5, "^", 5},
+		{"5 << 5;", 5, "<<", 5},
+		{"5 >> 5;", 5, ">>", 5},
 		{"true == true;", true, "==", true},
 		{"true != false;", true, "!=", false},
 		{"false == false;", false, "==", false},
@@ -253,6 +279,33 @@ func TestInfixExpressions(t *testing.T) {
 	}
 }
 
+func TestOperatorPrecedence(t *testing.T) {
+	tests := []struct {
+		input    string
+		expected string
+	}{
+		{"a + b * c % d", "(a + ((b * c) % d))"},
+		{"a << 1 + 2", "(a << (1 + 2))"},
+		{"a < b << c", "(a < (b << c))"},
+		{"a & b == c", "(a & (b == c))"},
+		{"a | b ^ c & d", "(a | (b ^ (c & d)))"},
+		{"a && b | c", "(a && (b | c))"},
+		{"~a & -b", "((~a) & (-b))"},
+		{"a >> b >> c", "((a >> b) >> c)"},
+	}
+
+	for _, tt := range tests {
+		l := lexer.New(tt.input)
+		p := New(l)
+		program := p.ParseProgram()
+		checkParserErrors(t, p)
+
+		if program.String() != tt.expected {
+			t.Errorf("expected=%q, got=%q", tt.expected, program.String())
+		}
+	}
+}
+
 func TestAssignExpression(t *testing.T) {
 	tests := []struct {
 		input string
@@ -300,6 +353,55 @@ func TestAssignExpression(t *testing.T) {
 	}
 }
 
+func TestCompoundAssignExpression(t *testing.T) {
+	tests := []struct {
+		input    string
+		expected string
+	}{
+		{"x += 1", "x += 1"},
+		{"x -= y * 2", "x -= (y * 2)"},
+		{"h[k] *= 2", "h[k] *= 2"},
+		{"xs[0][1] /= 3", "xs[0][1] /= 3"},
+		{"i %= n + 1", "i %= (n + 1)"},
+		{"i++", "i++"},
+		{"xs[i + 1]--; n", "xs[(i + 1)]--n"},
+	}
+
+	for _, tt := range tests {
+		l := lexer.New(tt.input)
+		p := New(l)
+		program := p.ParseProgram()
+		checkParserErrors(t, p)
+
+		if program.String() != tt.expected {
+			t.Errorf("expected=%q, got=%q", tt.expected, program.String())
+		}
+	}
+
+	l := lexer.New("1 += 2")
+	p := New(l)
+	p.ParseProgram()
+	if len(p.Errors()) != 1 || p.Errors()[0].Message != "unexpected expression on left side of +=: *ast.IntegerLiteral" {
+		t.Errorf("wrong errors: %v", p.Errors())
+	}
+
+	errors := []struct {
+		input    string
+		expected string
+	}{
+		{"a + b++", "1:1: unexpected expression on left side of ++: *ast.InfixExpression"},
+		{"f()--", "1:1: unexpected expression on left side of --: *ast.CallExpression"},
+		{"var y = x++", "1:10: no prefix parse function for ++ found"},
+	}
+	for _, tt := range errors {
+		p := New(lexer.New(tt.input))
+		p.ParseProgram()
+		if len(p.Errors()) != 1 || p.Errors()[0].Error() != tt.expected {
+			t.Errorf("%q: wrong errors. expected=%q, got=%v", tt.input, tt.expected, p.Errors())
+		}
+	}
+}
+
 func TestBlockExpression(t *testing.T) {
 	input := `
 {
@@ -363,6 +465,59 @@ func TestReturnStatements(t *testing.T) {
 
 }
 
+func TestThrowStatement(t *testing.T) {
+	l := lexer.New(`throw "oops"; throw #{"kind": "ValueError", "message": m}`)
+	p := New(l)
+	program := p.ParseProgram()
+	checkParserErrors(t, p)
+
+	if len(program.Statements) != 2 {
+		t.Fatalf("program.Statements does not contain 2 statements. got=%d", len(program.Statements))
+	}
+
+	for _, stmt := range program.Statements {
+		throwStmt, ok := stmt.(*ast.ThrowStatement)
+		if !ok {
+			t.Fatalf("stmt not *ast.ThrowStatement. got=%T", stmt)
+		}
+		if throwStmt.TokenLiteral() != "throw" {
+			t.Fatalf("throwStmt.TokenLiteral not 'throw', got %q", throwStmt.TokenLiteral())
+		}
+	}
+	testStringLiteral(t, program.Statements[0].(*ast.ThrowStatement).Value, "oops")
+}
+
+func TestTryExpression(t *testing.T) {
+	tests := []struct {
+		input    string
+		expected string
+	}{
+		{"try { f() } catch (e) { g(e) }", "try f() catch (e) g(e)"},
+		{"try { f() } catch { 0 }", "try f() catch 0"},
+		{"try { f() } finally { done() }", "try f() finally done()"},
+		{"try { f() } catch (e) { 1 } finally { 2 }", "try f() catch (e) 1 finally 2"},
+		{"var x = try { f() } catch (e) { 0 };", "var x = try f() catch (e) 0;"},
+	}
+
+	for _, tt := range tests {
+		l := lexer.New(tt.input)
+		p := New(l)
+		program := p.ParseProgram()
+		checkParserErrors(t, p)
+
+		if program.String() != tt.expected {
+			t.Errorf("expected=%q, got=%q", tt.expected, program.String())
+		}
+	}
+
+	l := lexer.New("try { f() }\nx")
+	p := New(l)
+	p.ParseProgram()
+	if len(p.Errors()) == 0 || p.Errors()[0].Error() != "2:1: expected catch or finally after try block, got IDENT instead" {
+		t.Errorf("wrong errors: %v", p.Errors())
+	}
+}
+
 func TestFunctionDefinitionStatement(t *testing.T) {
 	input := `
 	fun add(x, y) {
@@ -401,8 +556,8 @@ func TestFunctionDefinitionStatement(t *testing.T) {
 			t.Fatalf("len(fds.Parameters) not %d. got=%d", len(tt.expectedParameters), len(fds.Parameters))
 		}
 		for j, ident := range fds.Parameters {
-			if ident.Value != tt.expectedParameters[j] {
-				t.Fatalf("ident.Value not %s. got=%s", tt.expectedParameters[j], ident.Value)
+			if ident.Name.Value != tt.expectedParameters[j] {
+				t.Fatalf("ident.Name.Value not %s. got=%s", tt.expectedParameters[j], ident.Name.Value)
 			}
 		}
 		if fds.Body.String() != tt.expectedBody {
@@ -411,6 +566,160 @@ func TestFunctionDefinitionStatement(t *testing.T) {
 	}
 }
 
+func TestFunctionLiteral(t *testing.T) {
+	tests := []struct {
+		input              string
+		expectedParameters []string
+		expectedBody       string
+	}{
+		{"fun (x, y) { return x + y; }", []string{"x", "y"}, "return (x + y);"},
+		{"fun () {}", []string{}, ""},
+		{"(x, y) => x + y", []string{"x", "y"}, "(x + y)"},
+		{"(x) => { x * 2; }", []string{"x"}, "(x * 2)"},
+		{"() => 1", []string{}, "1"},
+	}
+
+	for _, tt := range tests {
+		l := lexer.New(tt.input)
+		p := New(l)
+		program := p.ParseProgram()
+		checkParserErrors(t, p)
+
+		if len(program.Statements) != 1 {
+			t.Fatalf("program.Statements does not contain 1 statement. got=%d", len(program.Statements))
+		}
+		stmt, ok := program.Statements[0].(*ast.ExpressionStatement)
+		if !ok {
+			t.Fatalf("stmt not *ast.ExpressionStatement. got=%T", program.Statements[0])
+		}
+		fl, ok := stmt.Expression.(*ast.FunctionLiteral)
+		if !ok {
+			t.Fatalf("stmt.Expression not *ast.FunctionLiteral. got=%T", stmt.Expression)
+		}
+		if len(fl.Parameters) != len(tt.expectedParameters) {
+			t.Fatalf("len(fl.Parameters) not %d. got=%d", len(tt.expectedParameters), len(fl.Parameters))
+		}
+		for j, ident := range fl.Parameters {
+			if ident.Name.Value != tt.expectedParameters[j] {
+				t.Fatalf("ident.Name.Value not %s. got=%s", tt.expectedParameters[j], ident.Name.Value)
+			}
+		}
+		if fl.Body.String() != tt.expectedBody {
+			t.Fatalf("fl.Body.String() not %s. got=%s", tt.expectedBody, fl.Body.String())
+		}
+	}
+}
+
+func TestFunctionLiteralInExpressions(t *testing.T) {
+	tests := []struct {
+		input    string
+		expected string
+	}{
+		{"var f = (a, b) => a + b;", "var f = (a, b) => (a + b);"},
+		{"map(xs, (x) => x * 2)", "map(xs, (x) => (x * 2))"},
+		{"((x) => x)(1)", "(x) => x(1)"},
+		{"(a + b) * c", "((a + b) * c)"},
+	}
+
+	for _, tt := range tests {
+		l := lexer.New(tt.input)
+		p := New(l)
+		program := p.ParseProgram()
+		checkParserErrors(t, p)
+
+		if program.String() != tt.expected {
+			t.Errorf("expected=%q, got=%q", tt.expected, program.String())
+		}
+	}
+}
+
+func TestDefaultAndRestParameters(t *testing.T) {
+	tests := []struct {
+		input    string
+		expected string
+	}{
+		{"fun f(a, b = 10, ...rest) { a }", "fun f(a, b = 10, ...rest) {\na\n}"},
+		{"fun (a = 1 + 2) { a }", "fun(a = (1 + 2)) {\na\n}"},
+		{"(a, b = 2, ...rest) => a", "(a, b = 2, ...rest) => a"},
+		{"f(...xs, 1)", "f(...xs, 1)"},
+		{"[0, ...xs, ...ys]", "[0, ...xs, ...ys]"},
+	}
+
+	for _, tt := range tests {
+		l := lexer.New(tt.input)
+		p := New(l)
+		program := p.ParseProgram()
+		checkParserErrors(t, p)
+
+		if program.String() != tt.expected {
+			t.Errorf("expected=%q, got=%q", tt.expected, program.String())
+		}
+	}
+
+	program := New(lexer.New("fun f(a, b = 10, ...rest) {}")).ParseProgram()
+	params := program.Statements[0].(*ast.FunctionDefinitionStatement).Parameters
+	if len(params) != 3 {
+		t.Fatalf("wrong number of parameters. got=%d", len(params))
+	}
+	if params[0].Default != nil || params[0].Rest {
+		t.Errorf("a should be a plain parameter. got=%s", params[0])
+	}
+	testIntegerLiteral(t, params[1].Default, 10)
+	if !params[2].Rest || params[2].Name.Value != "rest" {
+		t.Errorf("expected rest parameter. got=%s", params[2])
+	}
+}
+
+func TestInvalidParameters(t *testing.T) {
+	tests := []struct {
+		input         string
+		expectedError string
+	}{
+		{"fun f(...rest, a) {}", "1:7: rest parameter must be the last parameter"},
+		{"fun f(1) {}", "1:7: expected parameter name, got INT"},
+		{"(...rest, a) => a", "1:2: rest parameter must be the last parameter"},
+	}
+
+	for _, tt := range tests {
+		l := lexer.New(tt.input)
+		p := New(l)
+		p.ParseProgram()
+
+		errors := p.Errors()
+		if len(errors) == 0 {
+			t.Fatalf("%s: expected a parser error", tt.input)
+		}
+		if errors[0].Error() != tt.expectedError {
+			t.Errorf("wrong error. expected=%q, got=%q", tt.expectedError, errors[0].Error())
+		}
+	}
+}
+
+func TestInvalidArrowFunction(t *testing.T) {
+	tests := []struct {
+		input         string
+		expectedError string
+	}{
+		{"(x, 1) => x", "1:5: expected identifier as parameter, got 1"},
+		{"(a += 1) => a", "1:2: expected identifier as parameter, got a += 1"},
+		{"(x, y)", "1:7: expected next token to be =>, got EOF instead"},
+	}
+
+	for _, tt := range tests {
+		l := lexer.New(tt.input)
+		p := New(l)
+		p.ParseProgram()
+
+		errors := p.Errors()
+		if len(errors) == 0 {
+			t.Fatalf("%s: expected a parser error", tt.input)
+		}
+		if errors[0].Error() != tt.expectedError {
+			t.Errorf("wrong error. expected=%q, got=%q", tt.expectedError, errors[0].Error())
+		}
+	}
+}
+
 func TestCallExpression(t *testing.T) {
 	tests := []struct {
 		input    string
@@ -521,6 +830,46 @@ func TestWhileStatement(t *testing.T) {
 	}
 }
 
+func TestForStatement(t *testing.T) {
+	tests := []struct {
+		input     string
+		variables []string
+		iterable  string
+		body      string
+	}{
+		{"for x in xs { x }", []string{"x"}, "xs", "x"},
+		{"for k, v in h { k + v }", []string{"k", "v"}, "h", "(k + v)"},
+		{"for i in 0..n + 1 { break; }", []string{"i"}, "(0 .. (n + 1))", "break"},
+		{"for i in 1 << 2..3 * 4 {}", []string{"i"}, "((1 << 2) .. (3 * 4))", ""},
+	}
+
+	for _, tt := range tests {
+		l := lexer.New(tt.input)
+		p := New(l)
+		program := p.ParseProgram()
+		checkParserErrors(t, p)
+
+		stmt, ok := program.Statements[0].(*ast.ForStatement)
+		if !ok {
+			t.Fatalf("stmt not *ast.ForStatement. got=%T", program.Statements[0])
+		}
+		if len(stmt.Variables) != len(tt.variables) {
+			t.Fatalf("len(stmt.Variables) not %d. got=%d", len(tt.variables), len(stmt.Variables))
+		}
+		for i, v := range stmt.Variables {
+			if v.Value != tt.variables[i] {
+				t.Fatalf("stmt.Variables[%d] not %s. got=%s", i, tt.variables[i], v.Value)
+			}
+		}
+		if stmt.Iterable.String() != tt.iterable {
+			t.Fatalf("stmt.Iterable.String() not %s. got=%s", tt.iterable, stmt.Iterable.String())
+		}
+		if stmt.Body.String() != tt.body {
+			t.Fatalf("stmt.Body.String() not %s. got=%s", tt.body, stmt.Body.String())
+		}
+	}
+}
+
 func TestIndexExpression(t *testing.T) {
 	tests := []struct {
 		input    string
@@ -551,6 +900,32 @@ func TestIndexExpression(t *testing.T) {
 	}
 }
 
+func TestSliceExpression(t *testing.T) {
+	tests := []struct {
+		input    string
+		expected string
+	}{
+		{"xs[1:3]", "xs[1:3]"},
+		{"xs[:5]", "xs[:5]"},
+		{"s[-3:]", "s[(-3):]"},
+		{"s[:]", "s[:]"},
+		{"xs[i + 1:len(xs) - 1]", "xs[(i + 1):(len(xs) - 1)]"},
+		{"xs[1..=3]", "xs[(1 ..= 3)]"},
+		{"xs[1:2][0]", "xs[1:2][0]"},
+	}
+
+	for _, tt := range tests {
+		l := lexer.New(tt.input)
+		p := New(l)
+		program := p.ParseProgram()
+		checkParserErrors(t, p)
+
+		if program.String() != tt.expected {
+			t.Errorf("expected=%q, got=%q", tt.expected, program.String())
+		}
+	}
+}
+
 func TestHashLiteralsStringKeys(t *testing.T) {
 	input := `#{ "one": 1, "two": 2, "three": 3 }`
 
@@ -712,6 +1087,74 @@ func TestArrayLiteral(t *testing.T) {
 	}
 }
 
+func TestNumberLiterals(t *testing.T) {
+	tests := []struct {
+		input    string
+		expected interface{}
+	}{
+		{"0xFF", int64(255)},
+		{"0Xff", int64(255)},
+		{"0o755", int64(493)},
+		{"0b1010", int64(10)},
+		{"1_000_000", int64(1000000)},
+		{"0x_FF", int64(255)},
+		{"0b_1010_1010", int64(170)},
+		{"007.5", 7.5},
+		{"1e9", 1e9},
+		{"2.5E-3", 2.5e-3},
+		{"1_0.5", 10.5},
+	}
+
+	for _, tt := range tests {
+		l := lexer.New(tt.input)
+		p := New(l)
+		program := p.ParseProgram()
+		checkParserErrors(t, p)
+
+		stmt := program.Statements[0].(*ast.ExpressionStatement)
+		switch expected := tt.expected.(type) {
+		case int64:
+			lit, ok := stmt.Expression.(*ast.IntegerLiteral)
+			if !ok {
+				t.Fatalf("%s: exp not *ast.IntegerLiteral. got=%T", tt.input, stmt.Expression)
+			}
+			if lit.Value != expected {
+				t.Errorf("%s: lit.Value not %d. got=%d", tt.input, expected, lit.Value)
+			}
+		case float64:
+			testFloatLiteral(t, stmt.Expression, expected)
+		}
+	}
+}
+
+func TestBigIntegerLiteral(t *testing.T) {
+	input := `123_456_789_012_345_678_901_234_567_890`
+
+	l := lexer.New(input)
+	p := New(l)
+	program := p.ParseProgram()
+	checkParserErrors(t, p)
+
+	stmt := program.Statements[0].(*ast.ExpressionStatement)
+	lit, ok := stmt.Expression.(*ast.IntegerLiteral)
+	if !ok {
+		t.Fatalf("stmt.Expression is not ast.IntegerLiteral. got=%T", stmt.Expression)
+	}
+	if lit.Big == nil || lit.Big.String() != "123456789012345678901234567890" {
+		t.Fatalf("lit.Big not 123456789012345678901234567890. got=%v", lit.Big)
+	}
+
+	l = lexer.New("0xFFFF_FFFF_FFFF_FFFF_FF")
+	p = New(l)
+	program = p.ParseProgram()
+	checkParserErrors(t, p)
+
+	lit = program.Statements[0].(*ast.ExpressionStatement).Expression.(*ast.IntegerLiteral)
+	if lit.Big == nil || lit.Big.Text(16) != "ffffffffffffffffff" {
+		t.Fatalf("lit.Big not 0xffffffffffffffffff. got=%v", lit.Big)
+	}
+}
+
 func TestNullLiteral(t *testing.T) {
 	input := `null;`
 
@@ -730,3 +1173,217 @@ func TestNullLiteral(t *testing.T) {
 		t.Fatalf("stmt.Expression is not ast.NullLiteral. got=%T", stmt.Expression)
 	}
 }
+
+func TestNodePositions(t *testing.T) {
+	tests := []struct {
+		input       string
+		expectedPos string
+		expectedEnd string
+	}{
+		{"x + y", "1:1", "1:6"},
+		{"  add(1, 2);", "1:3", "1:12"},
+		{"var a = [1, 2];", "1:1", "1:15"},
+		{"a[0] = #{1: 2}", "1:1", "1:15"},
+		{"fun f(x) {\n  x\n}", "1:1", "3:2"},
+		{"if x { 1 } else { 2 }", "1:1", "1:22"},
+		{"return;", "1:1", "1:7"},
+	}
+
+	for _, tt := range tests {
+		l := lexer.New(tt.input)
+		p := New(l)
+		program := p.ParseProgram()
+		checkParserErrors(t, p)
+
+		stmt := program.Statements[0]
+		if stmt.Pos().String() != tt.expectedPos {
+			t.Errorf("%q: stmt.Pos() not %s. got=%s", tt.input, tt.expectedPos, stmt.Pos())
+		}
+		if stmt.End().String() != tt.expectedEnd {
+			t.Errorf("%q: stmt.End() not %s. got=%s", tt.input, tt.expectedEnd, stmt.End())
+		}
+	}
+}
+
+func TestErrorPositions(t *testing.T) {
+	input := `var x = 1;
+var = 2;`
+
+	l := lexer.New(input)
+	p := New(l)
+	p.ParseProgram()
+
+	errors := p.Errors()
+	if len(errors) == 0 {
+		t.Fatalf("expected parser errors")
+	}
+	expected := "2:5: expected next token to be IDENT, got = instead"
+	if errors[0].Error() != expected {
+		t.Fatalf("wrong error. expected=%q, got=%q", expected, errors[0].Error())
+	}
+	if errors[0].Code != diag.UNEXPECTED_TOKEN {
+		t.Fatalf("wrong error code. expected=%s, got=%s", diag.UNEXPECTED_TOKEN, errors[0].Code)
+	}
+}
+
+func TestErrorRecovery(t *testing.T) {
+	input := `var x = 1;
+var = 2;
+var y = x + 1;
+fun f(a {
+	a
+}
+fun g() {
+	var = 3;
+	return 4;
+}
+while x < ) { x = x + 1; }
+var z = 5;`
+
+	l := lexer.New(input)
+	p := New(l)
+	program := p.ParseProgram()
+
+	expectedErrors := []string{
+		"2:5: expected next token to be IDENT, got = instead",
+		"4:9: expected next token to be ), got { instead",
+		"8:6: expected next token to be IDENT, got = instead",
+		"11:11: no prefix parse function for ) found",
+	}
+	errors := p.Errors()
+	if len(errors) != len(expectedErrors) {
+		for _, err := range errors {
+			t.Errorf("parser error: %q", err.Error())
+		}
+		t.Fatalf("wrong number of errors. expected=%d, got=%d", len(expectedErrors), len(errors))
+	}
+	for i, expected := range expectedErrors {
+		if errors[i].Error() != expected {
+			t.Errorf("errors[%d] wrong. expected=%q, got=%q", i, expected, errors[i].Error())
+		}
+	}
+
+	if program == nil {
+		t.Fatalf("ParseProgram() returned nil")
+	}
+
+	expectedStatements := []string{
+		"var x = 1;",
+		"var y = (x + 1);",
+		"fun g() {\nreturn 4;\n}",
+		"var z = 5;",
+	}
+	if len(program.Statements) != len(expectedStatements) {
+		for _, stmt := range program.Statements {
+			t.Errorf("statement: %q", stmt.String())
+		}
+		t.Fatalf("wrong number of statements. expected=%d, got=%d", len(expectedStatements), len(program.Statements))
+	}
+	for i, expected := range expectedStatements {
+		if program.Statements[i].String() != expected {
+			t.Errorf("program.Statements[%d] wrong. expected=%q, got=%q", i, expected, program.Statements[i].String())
+		}
+	}
+}
+
+func TestErrorRecoveryAtBlockEnd(t *testing.T) {
+	tests := []struct {
+		input              string
+		expectedErrors     int
+		expectedStatements []string
+	}{
+		{"fun f() {\n var x = }\nvar y = 1;\nfun g() { 1 }", 1, []string{"fun f() {\n\n}", "var y = 1;", "fun g() {\n1\n}"}},
+		{"fun f() {\n var a = 1;\n a = }\nvar y = 1;", 1, []string{"fun f() {\nvar a = 1;\n}", "var y = 1;"}},
+		{"while true { { var x = } var y = 1 }\nvar z = 2", 1, []string{"while true var y = 1;", "var z = 2;"}},
+		{`var h = #{"a": 1 "b": 2}` + "\nvar y = 1;", 1, []string{"var y = 1;"}},
+	}
+
+	for _, tt := range tests {
+		p := New(lexer.New(tt.input))
+		program := p.ParseProgram()
+		if len(p.Errors()) != tt.expectedErrors {
+			t.Errorf("%q: wrong number of errors. expected=%d, got=%v", tt.input, tt.expectedErrors, p.Errors())
+		}
+		if len(program.Statements) != len(tt.expectedStatements) {
+			for _, stmt := range program.Statements {
+				t.Errorf("statement: %q", stmt.String())
+			}
+			t.Fatalf("%q: wrong number of statements. expected=%d, got=%d", tt.input, len(tt.expectedStatements), len(program.Statements))
+		}
+		for i, expected := range tt.expectedStatements {
+			if program.Statements[i].String() != expected {
+				t.Errorf("%q: program.Statements[%d] wrong. expected=%q, got=%q", tt.input, i, expected, program.Statements[i].String())
+			}
+		}
+	}
+}
+
+func TestLexerErrorsAreReported(t *testing.T) {
+	input := `var a = 1;
+var b = a @ 2;
+var c = "oops;
+var d = 4;`
+
+	l := lexer.New(input)
+	p := New(l)
+	program := p.ParseProgram()
+
+	expectedErrors := []string{
+		"2:11: unexpected character '@'",
+		"3:9: unterminated string starting at 3:9",
+	}
+	errors := p.Errors()
+	if len(errors) != len(expectedErrors) {
+		for _, err := range errors {
+			t.Errorf("parser error: %q", err.Error())
+		}
+		t.Fatalf("wrong number of errors. expected=%d, got=%d", len(expectedErrors), len(errors))
+	}
+	for i, expected := range expectedErrors {
+		if errors[i].Error() != expected {
+			t.Errorf("errors[%d] wrong. expected=%q, got=%q", i, expected, errors[i].Error())
+		}
+	}
+
+	if len(program.Statements) != 3 {
+		t.Fatalf("program.Statements does not contain 3 statements. got=%d", len(program.Statements))
+	}
+	testVarStatement(t, program.Statements[0], "a")
+	testVarStatement(t, program.Statements[1], "b")
+	testVarStatement(t, program.Statements[2], "d")
+}
+
+func TestInterpolatedString(t *testing.T) {
+	tests := []struct {
+		input         string
+		expected      string
+		expectedParts int
+	}{
+		{`"a ${x} b"`, `"a ${x} b"`, 3},
+		{`"${x + 1}${y}"`, `"${(x + 1)}${y}"`, 5},
+		{`"sum: ${add(1, 2)}\n"`, `"sum: ${add(1, 2)}\n"`, 3},
+		{`"\${x} ${"${y}"}"`, `"\${x} ${"${y}"}"`, 3},
+	}
+
+	for _, tt := range tests {
+		l := lexer.New(tt.input)
+		p := New(l)
+		program := p.ParseProgram()
+		checkParserErrors(t, p)
+
+		stmt := program.Statements[0].(*ast.ExpressionStatement)
+		str, ok := stmt.Expression.(*ast.InterpolatedString)
+		if !ok {
+			t.Fatalf("stmt.Expression is not *ast.InterpolatedString. got=%T", stmt.Expression)
+		}
+		if len(str.Parts) != tt.expectedParts {
+			t.Fatalf("len(str.Parts) not %d. got=%d", tt.expectedParts, len(str.Parts))
+		}
+		if str.String() != tt.expected {
+			t.Fatalf("str.String() not %s. got=%s", tt.expected, str.String())
+		}
+		if str.End().Offset != len(tt.input) {
+			t.Fatalf("str.End() not at end of input. got=%+v", str.End())
+		}
+	}
+}
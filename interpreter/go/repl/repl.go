@@ -4,19 +4,18 @@ import (
 	"bufio"
 	"fmt"
 	"io"
+	"kaze/diag"
 	"kaze/eval"
 	"kaze/lexer"
 	"kaze/object"
 	"kaze/parser"
+	"kaze/resolver"
 )
 
 const PROMPT = ">> "
 
-func printParserErrors(out io.Writer, errors []string) {
-	io.WriteString(out, " parser errors:\n")
-	for _, msg := range errors {
-		io.WriteString(out, "\t"+msg+"\n")
-	}
+func printParserErrors(out io.Writer, line string, errors []*diag.Diagnostic) {
+	diag.Fprint(out, "<repl>", line, errors)
 }
 
 func Start(in io.Reader, out io.Writer) {
@@ -37,11 +36,20 @@ func Start(in io.Reader, out io.Writer) {
 
 		program := p.ParseProgram()
 		if len(p.Errors()) != 0 {
-			printParserErrors(out, p.Errors())
+			printParserErrors(out, line, p.Errors())
 			continue
 		}
 
+		// Names defined on earlier lines are globals, so there is nothing to
+		// report as undefined here; they are looked up when the line runs.
+		resolver.Resolve(program, nil)
 		evaluated := eval.Eval(program, env)
+		if err, ok := evaluated.(*object.Error); ok {
+			// Earlier lines aren't kept, so don't quote source that may
+			// belong to a different line.
+			io.WriteString(out, err.Traceback("<repl>", ""))
+			continue
+		}
 		if evaluated != nil {
 			io.WriteString(out, evaluated.Inspect())
 			io.WriteString(out, "\n")
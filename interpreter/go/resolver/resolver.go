@@ -0,0 +1,315 @@
+// Package resolver works out before a program runs which declaration each
+// identifier refers to, so the evaluator can find variables by position
+// instead of by name.
+package resolver
+
+import (
+	"kaze/ast"
+	"kaze/diag"
+	"sort"
+)
+
+// Resolve annotates program for the evaluator. Each identifier gets the
+// depth and slot of the variable it refers to, and each scope the number of
+// slots it needs.
+//
+// Depth counts the scopes that exist at run time between a use and its
+// declaration. A block that declares nothing doesn't get one, and the body
+// of a function, for loop or catch clause shares the scope of its
+// parameters. Names declared at the top level of the program are globals.
+//
+// Within a function, a name is visible from its declaration on. A closure
+// sees all the names of the blocks around it, even those declared after it,
+// so local functions can call each other.
+//
+// If defined is not nil, uses of globals the program doesn't declare are
+// reported as errors unless defined(name) is true, as it is for builtins
+// and the names the host has defined.
+func Resolve(program *ast.Program, defined func(name string) bool) []*diag.Diagnostic {
+	r := &resolver{
+		defined:  defined,
+		globals:  make(map[string]bool),
+		declared: make(map[string]bool),
+	}
+	for _, stmt := range program.Statements {
+		switch stmt := stmt.(type) {
+		case *ast.VarStatement:
+			r.globals[stmt.Name.Value] = true
+		case *ast.FunctionDefinitionStatement:
+			r.globals[stmt.Name.Value] = true
+		}
+	}
+
+	r.resolveStatements(program.Statements)
+	return r.errors
+}
+
+type resolver struct {
+	scopes []*scope
+
+	defined   func(name string) bool
+	globals   map[string]bool // the names declared at the top level
+	declared  map[string]bool // the globals declared so far
+	functions int             // the number of enclosing function bodies
+
+	errors []*diag.Diagnostic
+}
+
+// scope is a block of declarations. The blocks merged into one scope at run
+// time share its env.
+type scope struct {
+	names    map[string]int // the names declared so far
+	hoisted  map[string]int // every name the block declares
+	function bool           // the parameters of a function
+	env      *env
+}
+
+type env struct {
+	size int
+}
+
+func (r *resolver) resolveStatements(stmts []ast.Statement) {
+	for _, stmt := range stmts {
+		r.resolveStatement(stmt)
+	}
+}
+
+func (r *resolver) resolveStatement(stmt ast.Statement) {
+	switch stmt := stmt.(type) {
+	case *ast.ExpressionStatement:
+		r.resolve(stmt.Expression)
+	case *ast.VarStatement:
+		r.resolve(stmt.Value)
+		r.declare(stmt.Name)
+	case *ast.FunctionDefinitionStatement:
+		// The name is declared first so the function can call itself.
+		r.declare(stmt.Name)
+		stmt.Slots = r.resolveFunction(stmt.Parameters, stmt.Body)
+	case *ast.ReturnStatement:
+		r.resolve(stmt.ReturnValue)
+	case *ast.ThrowStatement:
+		r.resolve(stmt.Value)
+	case *ast.WhileStatement:
+		r.resolve(stmt.Condition)
+		r.resolve(stmt.Body)
+	case *ast.ForStatement:
+		r.resolve(stmt.Iterable)
+		r.pushScope(false)
+		for _, variable := range stmt.Variables {
+			r.declare(variable)
+		}
+		r.resolveBody(stmt.Body)
+		stmt.Slots = r.popScope()
+	}
+}
+
+func (r *resolver) resolve(node ast.Expression) {
+	switch node := node.(type) {
+	case *ast.Identifier:
+		r.resolveName(node)
+	case *ast.PrefixExpression:
+		r.resolve(node.Right)
+	case *ast.InfixExpression:
+		r.resolve(node.Left)
+		r.resolve(node.Right)
+	case *ast.AssignExpression:
+		r.resolve(node.Left)
+		r.resolve(node.Value)
+	case *ast.BlockExpression:
+		r.resolveBlock(node, false)
+	case *ast.FunctionLiteral:
+		node.Slots = r.resolveFunction(node.Parameters, node.Body)
+	case *ast.SpreadExpression:
+		r.resolve(node.Value)
+	case *ast.CallExpression:
+		r.resolve(node.Function)
+		for _, arg := range node.Arguments {
+			r.resolve(arg)
+		}
+	case *ast.TryExpression:
+		r.resolve(node.Block)
+		if node.Catch != nil {
+			r.pushScope(false)
+			if node.CatchParam != nil {
+				r.declare(node.CatchParam)
+			}
+			r.resolveBody(node.Catch)
+			node.CatchSlots = r.popScope()
+		}
+		r.resolve(node.Finally)
+	case *ast.IfExpression:
+		r.resolve(node.Condition)
+		r.resolve(node.Consequence)
+		r.resolve(node.Alternative)
+	case *ast.IndexExpression:
+		r.resolve(node.Left)
+		r.resolve(node.Index)
+	case *ast.SliceExpression:
+		r.resolve(node.Left)
+		r.resolve(node.Low)
+		r.resolve(node.High)
+	case *ast.InterpolatedString:
+		for _, part := range node.Parts {
+			r.resolve(part)
+		}
+	case *ast.ArrayLiteral:
+		for _, element := range node.Elements {
+			r.resolve(element)
+		}
+	case *ast.HashLiteral:
+		keys := make([]ast.Expression, 0, len(node.Pairs))
+		for key := range node.Pairs {
+			keys = append(keys, key)
+		}
+		sort.Slice(keys, func(i, j int) bool {
+			return keys[i].Pos().Offset < keys[j].Pos().Offset
+		})
+		for _, key := range keys {
+			r.resolve(key)
+			r.resolve(node.Pairs[key])
+		}
+	}
+}
+
+// resolveFunction returns the size of the scope of a call. The parameters
+// take the first slots, in order.
+func (r *resolver) resolveFunction(params []*ast.Parameter, body ast.Expression) int {
+	r.functions++
+	s := r.pushScope(false)
+	s.function = true
+	s.env.size = len(params)
+	for i, param := range params {
+		// A default value can refer to the parameters before it.
+		r.resolve(param.Default)
+		s.names[param.Name.Value] = i
+		param.Name.Resolved, param.Name.Depth, param.Name.Slot = true, 0, i
+	}
+	r.resolveBody(body)
+	r.functions--
+	return r.popScope()
+}
+
+// resolveBody resolves the body of a function, for loop or catch clause,
+// which shares the scope the parameters were declared in.
+func (r *resolver) resolveBody(body ast.Expression) {
+	if block, ok := body.(*ast.BlockExpression); ok {
+		r.resolveBlock(block, true)
+		return
+	}
+	r.resolve(body)
+}
+
+func (r *resolver) resolveBlock(block *ast.BlockExpression, shared bool) {
+	block.Slots = 0
+	if !shared && !declares(block.Statements) {
+		r.resolveStatements(block.Statements)
+		return
+	}
+
+	s := r.pushScope(shared)
+	for _, stmt := range block.Statements {
+		switch stmt := stmt.(type) {
+		case *ast.VarStatement:
+			r.hoist(s, stmt.Name.Value)
+		case *ast.FunctionDefinitionStatement:
+			r.hoist(s, stmt.Name.Value)
+		}
+	}
+	r.resolveStatements(block.Statements)
+	size := r.popScope()
+	if !shared {
+		block.Slots = size
+	}
+}
+
+func declares(stmts []ast.Statement) bool {
+	for _, stmt := range stmts {
+		switch stmt.(type) {
+		case *ast.VarStatement, *ast.FunctionDefinitionStatement:
+			return true
+		}
+	}
+	return false
+}
+
+// hoist gives name a slot in s before the block declares it, so closures
+// created earlier in the block can refer to it.
+func (r *resolver) hoist(s *scope, name string) {
+	if _, ok := s.hoisted[name]; ok {
+		return
+	}
+	s.hoisted[name] = s.env.size
+	s.env.size++
+}
+
+func (r *resolver) pushScope(shared bool) *scope {
+	s := &scope{names: make(map[string]int), hoisted: make(map[string]int)}
+	if shared {
+		s.env = r.scopes[len(r.scopes)-1].env
+	} else {
+		s.env = &env{}
+	}
+	r.scopes = append(r.scopes, s)
+	return s
+}
+
+// popScope returns the number of slots of the scope's env.
+func (r *resolver) popScope() int {
+	s := r.scopes[len(r.scopes)-1]
+	r.scopes = r.scopes[:len(r.scopes)-1]
+	return s.env.size
+}
+
+// declare gives ident a slot in the innermost scope. Declaring a name again
+// in the same block reuses its slot.
+func (r *resolver) declare(ident *ast.Identifier) {
+	if len(r.scopes) == 0 {
+		ident.Resolved, ident.Depth, ident.Slot = true, -1, 0
+		r.declared[ident.Value] = true
+		return
+	}
+
+	s := r.scopes[len(r.scopes)-1]
+	slot, ok := s.names[ident.Value]
+	if !ok {
+		slot, ok = s.hoisted[ident.Value]
+		if !ok {
+			slot = s.env.size
+			s.env.size++
+		}
+		s.names[ident.Value] = slot
+	}
+	ident.Resolved, ident.Depth, ident.Slot = true, 0, slot
+}
+
+func (r *resolver) resolveName(ident *ast.Identifier) {
+	depth := 0
+	inClosure := false
+	for i := len(r.scopes) - 1; i >= 0; i-- {
+		s := r.scopes[i]
+		slot, ok := s.names[ident.Value]
+		if !ok && inClosure {
+			slot, ok = s.hoisted[ident.Value]
+		}
+		if ok {
+			ident.Resolved, ident.Depth, ident.Slot = true, depth, slot
+			return
+		}
+		inClosure = inClosure || s.function
+		if i > 0 && r.scopes[i-1].env != s.env {
+			depth++
+		}
+	}
+
+	ident.Resolved, ident.Depth, ident.Slot = true, -1, 0
+	if r.defined == nil || r.defined(ident.Value) {
+		return
+	}
+	// A function may run after globals declared later in the program, but
+	// code at the top level can only see those declared before it.
+	if r.declared[ident.Value] || r.functions > 0 && r.globals[ident.Value] {
+		return
+	}
+	span := diag.Span{Start: ident.Pos(), End: ident.End()}
+	r.errors = append(r.errors, diag.Errorf(diag.UNDEFINED_VARIABLE, span, "undefined variable: %s", ident.Value))
+}
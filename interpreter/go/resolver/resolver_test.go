@@ -0,0 +1,138 @@
+package resolver
+
+import (
+	"kaze/ast"
+	"kaze/lexer"
+	"kaze/parser"
+	"testing"
+)
+
+func parse(t *testing.T, input string) *ast.Program {
+	l := lexer.New(input)
+	p := parser.New(l)
+	program := p.ParseProgram()
+	if len(p.Errors()) != 0 {
+		t.Fatalf("%q: parser errors: %v", input, p.Errors())
+	}
+	return program
+}
+
+func testIdentifier(t *testing.T, node ast.Node, name string, depth, slot int) {
+	ident, ok := node.(*ast.Identifier)
+	if !ok {
+		t.Fatalf("node is not Identifier. got=%T", node)
+	}
+	if !ident.Resolved || ident.Value != name || ident.Depth != depth || ident.Slot != slot {
+		t.Errorf("wrong resolution. want=%s (%d, %d), got=%s (%d, %d)",
+			name, depth, slot, ident.Value, ident.Depth, ident.Slot)
+	}
+}
+
+func TestResolve(t *testing.T) {
+	program := parse(t, "fun f(a) { var b = a; (c) => { var d = c; a + b + d } }")
+	if errors := Resolve(program, nil); len(errors) != 0 {
+		t.Fatalf("unexpected errors: %v", errors)
+	}
+
+	fn := program.Statements[0].(*ast.FunctionDefinitionStatement)
+	testIdentifier(t, fn.Name, "f", -1, 0)
+	if fn.Slots != 2 {
+		t.Errorf("wrong number of slots of f. got=%d, want=2", fn.Slots)
+	}
+
+	body := fn.Body.(*ast.BlockExpression)
+	if body.Slots != 0 {
+		t.Errorf("function body has its own scope. got=%d slots", body.Slots)
+	}
+	stmt := body.Statements[0].(*ast.VarStatement)
+	testIdentifier(t, stmt.Name, "b", 0, 1)
+	testIdentifier(t, stmt.Value, "a", 0, 0)
+
+	inner := body.Statements[1].(*ast.ExpressionStatement).Expression.(*ast.FunctionLiteral)
+	if inner.Slots != 2 {
+		t.Errorf("wrong number of slots of the closure. got=%d, want=2", inner.Slots)
+	}
+	innerBody := inner.Body.(*ast.BlockExpression)
+	sum := innerBody.Statements[1].(*ast.ExpressionStatement).Expression.(*ast.InfixExpression)
+	left := sum.Left.(*ast.InfixExpression)
+	testIdentifier(t, left.Left, "a", 1, 0)
+	testIdentifier(t, left.Right, "b", 1, 1)
+	testIdentifier(t, sum.Right, "d", 0, 1)
+}
+
+func TestBlockScopes(t *testing.T) {
+	program := parse(t, "var x = 1; { x } { var x = x; { x } } for i in 0..3 { var j = i }")
+	Resolve(program, nil)
+
+	plain := program.Statements[1].(*ast.ExpressionStatement).Expression.(*ast.BlockExpression)
+	if plain.Slots != 0 {
+		t.Errorf("block without declarations has a scope. got=%d slots", plain.Slots)
+	}
+	testIdentifier(t, plain.Statements[0].(*ast.ExpressionStatement).Expression, "x", -1, 0)
+
+	block := program.Statements[2].(*ast.ExpressionStatement).Expression.(*ast.BlockExpression)
+	if block.Slots != 1 {
+		t.Errorf("wrong number of slots of the block. got=%d, want=1", block.Slots)
+	}
+	stmt := block.Statements[0].(*ast.VarStatement)
+	testIdentifier(t, stmt.Name, "x", 0, 0)
+	testIdentifier(t, stmt.Value, "x", -1, 0)
+	nested := block.Statements[1].(*ast.ExpressionStatement).Expression.(*ast.BlockExpression)
+	testIdentifier(t, nested.Statements[0].(*ast.ExpressionStatement).Expression, "x", 0, 0)
+
+	loop := program.Statements[3].(*ast.ForStatement)
+	if loop.Slots != 2 {
+		t.Errorf("wrong number of slots of the loop. got=%d, want=2", loop.Slots)
+	}
+	testIdentifier(t, loop.Body.(*ast.BlockExpression).Statements[0].(*ast.VarStatement).Name, "j", 0, 1)
+}
+
+func TestLaterDeclarations(t *testing.T) {
+	program := parse(t, "fun f() { var g = fun() { h() }; h; var h = 1; fun even() { odd() } fun odd() { even() } }")
+	errors := Resolve(program, func(string) bool { return false })
+	if len(errors) != 1 || errors[0].Error() != "1:34: undefined variable: h" {
+		t.Fatalf("wrong errors. want=[1:34: undefined variable: h], got=%v", errors)
+	}
+
+	body := program.Statements[0].(*ast.FunctionDefinitionStatement).Body.(*ast.BlockExpression)
+	g := body.Statements[0].(*ast.VarStatement).Value.(*ast.FunctionLiteral)
+	call := g.Body.(*ast.BlockExpression).Statements[0].(*ast.ExpressionStatement).Expression.(*ast.CallExpression)
+	testIdentifier(t, call.Function, "h", 1, 1)
+	// Outside a closure, h can't be used before its declaration.
+	testIdentifier(t, body.Statements[1].(*ast.ExpressionStatement).Expression, "h", -1, 0)
+	testIdentifier(t, body.Statements[2].(*ast.VarStatement).Name, "h", 0, 1)
+
+	even := body.Statements[3].(*ast.FunctionDefinitionStatement)
+	call = even.Body.(*ast.BlockExpression).Statements[0].(*ast.ExpressionStatement).Expression.(*ast.CallExpression)
+	testIdentifier(t, call.Function, "odd", 1, 3)
+	testIdentifier(t, body.Statements[4].(*ast.FunctionDefinitionStatement).Name, "odd", 0, 3)
+}
+
+func TestUndefinedVariables(t *testing.T) {
+	tests := []struct {
+		input    string
+		expected []string
+	}{
+		{"len(x)", []string{"1:5: undefined variable: x"}},
+		{"var x = x", []string{"1:9: undefined variable: x"}},
+		{"x = 1", []string{"1:1: undefined variable: x"}},
+		{"f(); fun f() { g() } fun g() { 1 }", []string{"1:1: undefined variable: f"}},
+		{"{ var a = 1 }\na", []string{"2:1: undefined variable: a"}},
+		{"try { 1 } catch (e) { e }; e + y", []string{"1:28: undefined variable: e", "1:32: undefined variable: y"}},
+		{"fun f(a, b = a) { var c = b; c + host }", nil},
+	}
+
+	defined := func(name string) bool { return name == "len" || name == "host" }
+	for _, tt := range tests {
+		errors := Resolve(parse(t, tt.input), defined)
+		if len(errors) != len(tt.expected) {
+			t.Errorf("%q: wrong number of errors. want=%v, got=%v", tt.input, tt.expected, errors)
+			continue
+		}
+		for i, err := range errors {
+			if err.Error() != tt.expected[i] {
+				t.Errorf("%q: wrong error. want=%q, got=%q", tt.input, tt.expected[i], err.Error())
+			}
+		}
+	}
+}
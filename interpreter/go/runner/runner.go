@@ -1,35 +1,101 @@
 package runner
 
 import (
+	"io"
+	"kaze/compiler"
+	"kaze/diag"
 	"kaze/eval"
 	"kaze/lexer"
 	"kaze/object"
 	"kaze/parser"
+	"kaze/resolver"
+	"kaze/vm"
 	"os"
+	"time"
 )
 
-func RunFile(path string) {
+type Options struct {
+	// JSONDiagnostics prints diagnostics as a JSON array instead of
+	// rendering them with source excerpts.
+	JSONDiagnostics bool
+
+	// MaxCallDepth limits the depth of nested function calls. 0 means
+	// eval.DefaultMaxCallDepth.
+	MaxCallDepth int
+
+	// MaxSteps limits the number of loop iterations and function calls. 0
+	// means no limit.
+	MaxSteps int
+
+	// Timeout limits the running time of the program. 0 means no limit.
+	Timeout time.Duration
+
+	// UseVM compiles the program to bytecode and runs it on the VM instead
+	// of the tree-walking evaluator.
+	UseVM bool
+}
+
+func RunFile(path string, opts Options) {
 	bytes, err := os.ReadFile(path)
 	if err != nil {
 		panic(err)
 	}
+	source := string(bytes)
 
-	l := lexer.New(string(bytes))
+	l := lexer.New(source)
 	p := parser.New(l)
 	program := p.ParseProgram()
 	if len(p.Errors()) != 0 {
-		println("PARSER ERROR: ")
-		for _, err := range p.Errors() {
-			println("  ", err)
-		}
+		reportDiagnostics(path, source, p.Errors(), opts)
 		os.Exit(1)
 	}
 
 	env := object.NewEnvironment()
-	evaluated := eval.Eval(program, env)
+	limits := &object.Limits{MaxCallDepth: opts.MaxCallDepth, MaxSteps: opts.MaxSteps}
+	if opts.Timeout > 0 {
+		limits.Deadline = time.Now().Add(opts.Timeout)
+	}
+	env.SetLimits(limits)
+
+	defined := func(name string) bool {
+		_, ok := eval.LookupBuiltin(name)
+		return ok
+	}
+	if errors := resolver.Resolve(program, defined); len(errors) != 0 {
+		reportDiagnostics(path, source, errors, opts)
+		os.Exit(1)
+	}
+
+	var evaluated object.Object
+	if opts.UseVM {
+		c := compiler.New()
+		if err := c.Compile(program); err != nil {
+			d, ok := err.(*diag.Diagnostic)
+			if !ok {
+				d = diag.Errorf("", diag.Span{}, "%s", err)
+			}
+			reportDiagnostics(path, source, []*diag.Diagnostic{d}, opts)
+			os.Exit(1)
+		}
+		evaluated = vm.New(c.Bytecode(), env).Run()
+	} else {
+		evaluated = eval.Eval(program, env)
+	}
 	switch e := evaluated.(type) {
 	case *object.Error:
-		println(e.Message)
+		if opts.JSONDiagnostics {
+			reportDiagnostics(path, source, []*diag.Diagnostic{e.Diagnostic()}, opts)
+		} else {
+			io.WriteString(os.Stderr, e.Traceback(path, source))
+		}
 		os.Exit(1)
 	}
 }
+
+func reportDiagnostics(path string, source string, diags []*diag.Diagnostic, opts Options) {
+	if opts.JSONDiagnostics {
+		diag.WriteJSON(os.Stderr, diags)
+		return
+	}
+	diag.Fprint(os.Stderr, path, source, diags)
+}
@@ -1,10 +1,32 @@
 package token
 
+import "fmt"
+
 type TokenType string
 
+// Position is a location in the source text. Line and Column are 1-based and
+// Column is counted in bytes; Offset is the 0-based byte offset.
+// The zero value is an invalid position.
+type Position struct {
+	Offset int `json:"offset"`
+	Line   int `json:"line"`
+	Column int `json:"column"`
+}
+
+func (p Position) IsValid() bool { return p.Line > 0 }
+
+func (p Position) String() string {
+	if !p.IsValid() {
+		return "-"
+	}
+	return fmt.Sprintf("%d:%d", p.Line, p.Column)
+}
+
 type Token struct {
 	Type    TokenType
 	Literal string
+	Pos     Position // position of the first character of the token
+	End     Position // position immediately after the token
 }
 
 const (
@@ -13,15 +35,41 @@ const (
 
 	IDENT  = "IDENT"
 	INT    = "INT"
+	FLOAT  = "FLOAT"
 	STRING = "STRING"
 
+	// A string with interpolations such as "a ${x} b ${y} c" is split into
+	// INTERP_START ("a "), the tokens of x, INTERP_MID (" b "), the tokens
+	// of y and INTERP_END (" c").
+	INTERP_START = "INTERP_START"
+	INTERP_MID   = "INTERP_MID"
+	INTERP_END   = "INTERP_END"
+
 	ASSIGN   = "="
+	ARROW    = "=>"
 	PLUS     = "+"
 	MINUS    = "-"
 	ASTERISK = "*"
 	SLASH    = "/"
+	PERCENT  = "%"
 	BANG     = "!"
 
+	PLUS_ASSIGN     = "+="
+	MINUS_ASSIGN    = "-="
+	ASTERISK_ASSIGN = "*="
+	SLASH_ASSIGN    = "/="
+	PERCENT_ASSIGN  = "%="
+
+	INCREMENT = "++"
+	DECREMENT = "--"
+
+	AMPERSAND = "&"
+	PIPE      = "|"
+	CARET     = "^"
+	TILDE     = "~"
+	SHL       = "<<"
+	SHR       = ">>"
+
 	EQ     = "=="
 	NOT_EQ = "!="
 	AND    = "&&"
@@ -41,6 +89,9 @@ const (
 	COLON     = ":"
 	SEMICOLON = ";"
 	COMMA     = ","
+	DOTDOT    = ".."
+	DOTDOT_EQ = "..="
+	ELLIPSIS  = "..."
 	HASH      = "#"
 
 	VAR      = "VAR"
@@ -51,9 +102,15 @@ const (
 	TRUE     = "TRUE"
 	FALSE    = "FALSE"
 	WHILE    = "WHILE"
+	FOR      = "FOR"
+	IN       = "IN"
 	BREAK    = "BREAK"
 	CONTINUE = "CONTINUE"
 	NULL     = "NULL"
+	TRY      = "TRY"
+	CATCH    = "CATCH"
+	FINALLY  = "FINALLY"
+	THROW    = "THROW"
 )
 
 var keywords = map[string]TokenType{
@@ -65,9 +122,15 @@ var keywords = map[string]TokenType{
 	"true":     TRUE,
 	"false":    FALSE,
 	"while":    WHILE,
+	"for":      FOR,
+	"in":       IN,
 	"break":    BREAK,
 	"continue": CONTINUE,
 	"null":     NULL,
+	"try":      TRY,
+	"catch":    CATCH,
+	"finally":  FINALLY,
+	"throw":    THROW,
 }
 
 func LookupIdent(ident string) TokenType {
@@ -0,0 +1,59 @@
+package vm
+
+import (
+	"kaze/code"
+	"kaze/object"
+)
+
+type Frame struct {
+	cl     *object.Closure
+	ip     int // the offset of the next instruction
+	locals []object.Object
+	open   []openUpvalue // upvalues still pointing at locals
+	base   int           // the stack pointer when the function was called
+}
+
+type openUpvalue struct {
+	slot    int
+	upvalue *object.Upvalue
+}
+
+func NewFrame(cl *object.Closure, base int) *Frame {
+	return &Frame{
+		cl:     cl,
+		locals: make([]object.Object, cl.Fn.NumLocals),
+		base:   base,
+	}
+}
+
+func (f *Frame) Instructions() code.Instructions {
+	return f.cl.Fn.Instructions
+}
+
+// upvalue returns the upvalue of the local in slot, shared by all the
+// closures capturing it.
+func (f *Frame) upvalue(slot int) *object.Upvalue {
+	for _, open := range f.open {
+		if open.slot == slot {
+			return open.upvalue
+		}
+	}
+
+	upvalue := &object.Upvalue{Location: &f.locals[slot]}
+	f.open = append(f.open, openUpvalue{slot: slot, upvalue: upvalue})
+	return upvalue
+}
+
+// closeUpvalues closes the upvalues of the locals from slot on, whose scope
+// is ending.
+func (f *Frame) closeUpvalues(slot int) {
+	open := f.open[:0]
+	for _, o := range f.open {
+		if o.slot >= slot {
+			o.upvalue.Close()
+		} else {
+			open = append(open, o)
+		}
+	}
+	f.open = open
+}
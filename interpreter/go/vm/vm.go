@@ -0,0 +1,698 @@
+package vm
+
+import (
+	"context"
+	"fmt"
+	"kaze/code"
+	"kaze/compiler"
+	"kaze/eval"
+	"kaze/object"
+	"strings"
+)
+
+// StackSize is the initial size of the operand stack, which grows as
+// needed.
+const StackSize = 2048
+
+// Small integers are preallocated so arithmetic on them doesn't allocate.
+const (
+	minCachedInteger = -128
+	maxCachedInteger = 1024
+)
+
+var integers [maxCachedInteger - minCachedInteger + 1]*object.Integer
+
+func init() {
+	for i := range integers {
+		integers[i] = &object.Integer{Value: int64(i + minCachedInteger)}
+	}
+}
+
+var operators = map[code.Opcode]string{
+	code.OpAdd:            "+",
+	code.OpSub:            "-",
+	code.OpMul:            "*",
+	code.OpDiv:            "/",
+	code.OpMod:            "%",
+	code.OpBitAnd:         "&",
+	code.OpBitOr:          "|",
+	code.OpBitXor:         "^",
+	code.OpShl:            "<<",
+	code.OpShr:            ">>",
+	code.OpEqual:          "==",
+	code.OpNotEqual:       "!=",
+	code.OpLess:           "<",
+	code.OpGreater:        ">",
+	code.OpLessEqual:      "<=",
+	code.OpGreaterEqual:   ">=",
+	code.OpRange:          "..",
+	code.OpRangeInclusive: "..=",
+}
+
+// VM runs compiled programs with the semantics of eval.Eval. Global
+// variables live in an object.Environment, so the two can share one.
+type VM struct {
+	env *object.Environment
+
+	stack []object.Object
+	sp    int // the next free slot; the top of the stack is stack[sp-1]
+
+	frames   []*Frame
+	handlers []handler
+
+	limits   *object.Limits
+	maxDepth int
+}
+
+// handler is an active try block.
+type handler struct {
+	frame int // the index of the frame running the try block
+	ip    int // the start of the catch code
+	sp    int
+}
+
+func New(bytecode *compiler.Bytecode, env *object.Environment) *VM {
+	mainFn := &object.CompiledFunction{
+		Instructions: bytecode.Instructions,
+		Spans:        bytecode.Spans,
+		NumLocals:    bytecode.NumLocals,
+		Constants:    bytecode.Constants,
+	}
+
+	limits := env.Limits()
+	maxDepth := eval.DefaultMaxCallDepth
+	if limits != nil && limits.MaxCallDepth > 0 {
+		maxDepth = limits.MaxCallDepth
+	}
+
+	return &VM{
+		env:      env,
+		stack:    make([]object.Object, StackSize),
+		frames:   []*Frame{NewFrame(&object.Closure{Fn: mainFn}, 0)},
+		limits:   limits,
+		maxDepth: maxDepth,
+	}
+}
+
+// RunContext is Run for hosts that need to stop the program: once ctx is
+// done, it fails with a TimeoutError or a CanceledError.
+func (vm *VM) RunContext(ctx context.Context) object.Object {
+	saved := vm.limits
+	vm.limits = saved.WithContext(ctx)
+	defer func() { vm.limits = saved }()
+
+	return vm.Run()
+}
+
+// Run executes the program and returns its value, or the *object.Error it
+// raised and didn't catch.
+func (vm *VM) Run() object.Object {
+	for {
+		if result, done := vm.run(); done {
+			return result
+		}
+	}
+}
+
+// run executes instructions until the program ends. A Go panic in the VM
+// or a builtin is a bug, but it must not take down the host process: it is
+// raised as an InternalError, and run returns so Run can resume.
+func (vm *VM) run() (result object.Object, done bool) {
+	defer func() {
+		if r := recover(); r != nil {
+			err := &object.Error{Kind: object.INTERNAL_ERROR, Message: fmt.Sprintf("internal error: %v", r)}
+			if !vm.raise(err) {
+				result, done = err, true
+			}
+		}
+	}()
+
+	frame := vm.currentFrame()
+	ins, constants := frame.Instructions(), frame.cl.Fn.Constants
+
+	for {
+		var err *object.Error
+		ip := frame.ip
+		op := code.Opcode(ins[ip])
+
+		switch op {
+		case code.OpConstant:
+			frame.ip = ip + 3
+			vm.push(constants[code.ReadUint16(ins[ip+1:])])
+		case code.OpString:
+			frame.ip = ip + 3
+			str := constants[code.ReadUint16(ins[ip+1:])].(*object.String)
+			vm.push(&object.String{Value: str.Value})
+		case code.OpNull:
+			frame.ip = ip + 1
+			vm.push(eval.NULL)
+		case code.OpTrue:
+			frame.ip = ip + 1
+			vm.push(eval.TRUE)
+		case code.OpFalse:
+			frame.ip = ip + 1
+			vm.push(eval.FALSE)
+		case code.OpPop:
+			frame.ip = ip + 1
+			vm.sp--
+		case code.OpDup2:
+			frame.ip = ip + 1
+			vm.push(vm.stack[vm.sp-2])
+			vm.push(vm.stack[vm.sp-2])
+
+		case code.OpAdd, code.OpSub, code.OpMul, code.OpDiv, code.OpMod,
+			code.OpBitAnd, code.OpBitOr, code.OpBitXor, code.OpShl, code.OpShr,
+			code.OpEqual, code.OpNotEqual, code.OpLess, code.OpGreater,
+			code.OpLessEqual, code.OpGreaterEqual, code.OpRange, code.OpRangeInclusive:
+			frame.ip = ip + 1
+			right := vm.pop()
+			left := vm.pop()
+			err = vm.pushResult(binaryOperation(op, left, right))
+
+		case code.OpMinus:
+			frame.ip = ip + 1
+			right := vm.pop()
+			if integer, ok := right.(*object.Integer); ok && integer.Value != -integer.Value {
+				vm.push(newInteger(-integer.Value))
+			} else {
+				err = vm.pushResult(eval.PrefixOperation("-", right))
+			}
+		case code.OpBang:
+			frame.ip = ip + 1
+			vm.push(nativeBoolToBooleanObject(!eval.IsTruthy(vm.pop())))
+		case code.OpBitNot:
+			frame.ip = ip + 1
+			err = vm.pushResult(eval.PrefixOperation("~", vm.pop()))
+
+		case code.OpJump:
+			target := int(code.ReadUint16(ins[ip+1:]))
+			// A jump back starts the next iteration of a loop.
+			if target < ip {
+				frame.ip = ip + 3
+				if err = vm.limits.Step(); err != nil {
+					break
+				}
+			}
+			frame.ip = target
+		case code.OpJumpNotTruthy:
+			if eval.IsTruthy(vm.pop()) {
+				frame.ip = ip + 3
+			} else {
+				frame.ip = int(code.ReadUint16(ins[ip+1:]))
+			}
+		case code.OpJumpIfFalsyOrPop, code.OpJumpIfTruthyOrPop:
+			if eval.IsTruthy(vm.stack[vm.sp-1]) == (op == code.OpJumpIfTruthyOrPop) {
+				frame.ip = int(code.ReadUint16(ins[ip+1:]))
+			} else {
+				frame.ip = ip + 3
+				vm.sp--
+			}
+		case code.OpJumpIfSet:
+			if frame.locals[code.ReadUint16(ins[ip+1:])] != nil {
+				frame.ip = int(code.ReadUint16(ins[ip+3:]))
+			} else {
+				frame.ip = ip + 5
+			}
+
+		case code.OpGetGlobal:
+			frame.ip = ip + 3
+			name := constantString(constants, ins[ip+1:])
+			if value, ok := vm.env.Get(name); ok {
+				vm.push(value)
+			} else if builtin, ok := eval.LookupBuiltin(name); ok {
+				vm.push(builtin)
+			} else {
+				err = &object.Error{Kind: object.NAME_ERROR, Message: "identifier not found: " + name}
+			}
+		case code.OpLookupGlobal:
+			frame.ip = ip + 3
+			value, _ := vm.env.Get(constantString(constants, ins[ip+1:]))
+			vm.push(value)
+		case code.OpDefineGlobal:
+			frame.ip = ip + 3
+			vm.env.Create(constantString(constants, ins[ip+1:]), vm.pop())
+		case code.OpSetGlobal:
+			frame.ip = ip + 3
+			if _, ok := vm.env.Update(constantString(constants, ins[ip+1:]), vm.stack[vm.sp-1]); !ok {
+				err = &object.Error{Message: "assignment failed"}
+			}
+		case code.OpGetLocal:
+			frame.ip = ip + 3
+			vm.push(frame.locals[code.ReadUint16(ins[ip+1:])])
+		case code.OpSetLocal:
+			frame.ip = ip + 3
+			frame.locals[code.ReadUint16(ins[ip+1:])] = vm.stack[vm.sp-1]
+		case code.OpGetFree:
+			frame.ip = ip + 2
+			vm.push(*frame.cl.Free[ins[ip+1]].Location)
+		case code.OpSetFree:
+			frame.ip = ip + 2
+			*frame.cl.Free[ins[ip+1]].Location = vm.stack[vm.sp-1]
+
+		case code.OpLocalRef:
+			frame.ip = ip + 3
+			vm.push(frame.upvalue(int(code.ReadUint16(ins[ip+1:]))))
+		case code.OpFreeRef:
+			frame.ip = ip + 2
+			vm.push(frame.cl.Free[ins[ip+1]])
+		case code.OpClosure:
+			frame.ip = ip + 4
+			fn := constants[code.ReadUint16(ins[ip+1:])].(*object.CompiledFunction)
+			free := make([]*object.Upvalue, ins[ip+3])
+			for i := range free {
+				free[i] = vm.stack[vm.sp-len(free)+i].(*object.Upvalue)
+			}
+			vm.sp -= len(free)
+			vm.push(&object.Closure{Fn: fn, Free: free})
+		case code.OpCloseUpvalues:
+			frame.ip = ip + 3
+			frame.closeUpvalues(int(code.ReadUint16(ins[ip+1:])))
+
+		case code.OpCall:
+			frame.ip = ip + 3
+			argc, flags := int(ins[ip+1]), int(ins[ip+2])
+			var args []object.Object
+			if flags&code.CALL_SPREAD != 0 {
+				args = vm.pop().(*object.Array).Elements
+			} else {
+				args = vm.stack[vm.sp-argc : vm.sp]
+				vm.sp -= argc
+			}
+			fn := vm.pop()
+			if err = vm.limits.Step(); err == nil {
+				err = vm.call(fn, args, flags&code.CALL_TAIL != 0)
+			}
+			frame = vm.currentFrame()
+			ins, constants = frame.Instructions(), frame.cl.Fn.Constants
+		case code.OpReturnValue:
+			value := vm.pop()
+			if len(vm.frames) == 1 {
+				return value, true
+			}
+			vm.returnValue(value)
+			frame = vm.currentFrame()
+			ins, constants = frame.Instructions(), frame.cl.Fn.Constants
+
+		case code.OpArray:
+			frame.ip = ip + 3
+			elements := make([]object.Object, code.ReadUint16(ins[ip+1:]))
+			copy(elements, vm.stack[vm.sp-len(elements):vm.sp])
+			vm.sp -= len(elements)
+			vm.push(&object.Array{Elements: elements})
+		case code.OpAppend:
+			frame.ip = ip + 1
+			element := vm.pop()
+			array := vm.stack[vm.sp-1].(*object.Array)
+			array.Elements = append(array.Elements, element)
+		case code.OpAppendSpread:
+			frame.ip = ip + 1
+			elements, spreadErr := eval.SpreadElements(vm.pop())
+			if spreadErr != nil {
+				err = spreadErr
+				break
+			}
+			array := vm.stack[vm.sp-1].(*object.Array)
+			array.Elements = append(array.Elements, elements...)
+		case code.OpHash:
+			frame.ip = ip + 3
+			err = vm.buildHash(int(code.ReadUint16(ins[ip+1:])))
+		case code.OpIndex:
+			frame.ip = ip + 1
+			index := vm.pop()
+			left := vm.pop()
+			if array, ok := left.(*object.Array); ok {
+				if i, ok := index.(*object.Integer); ok && i.Value >= 0 && i.Value < int64(len(array.Elements)) {
+					vm.push(array.Elements[i.Value])
+					break
+				}
+			}
+			err = vm.pushResult(eval.IndexOperation(left, index))
+		case code.OpIndexRef:
+			frame.ip = ip + 1
+			index := vm.pop()
+			container := vm.pop()
+			var value object.Object
+			if container != nil {
+				value, _ = (&object.IndexRef{Left: &valueRef{container}, Index: index}).Get()
+			}
+			vm.push(value)
+		case code.OpSetIndex:
+			frame.ip = ip + 1
+			value := vm.pop()
+			index := vm.pop()
+			container := vm.pop()
+			if container != nil {
+				if result, ok := (&object.IndexRef{Left: &valueRef{container}, Index: index}).Update(value); ok {
+					vm.push(result)
+					break
+				}
+			}
+			err = &object.Error{Message: "assignment failed"}
+		case code.OpAssertCurrent:
+			frame.ip = ip + 3
+			if vm.stack[vm.sp-1] == nil {
+				err = &object.Error{Message: constantString(constants, ins[ip+1:])}
+			}
+		case code.OpAssertDefined:
+			frame.ip = ip + 3
+			if vm.stack[vm.sp-1] == nil {
+				err = &object.Error{Kind: object.NAME_ERROR, Message: "identifier not found: " + constantString(constants, ins[ip+1:])}
+			}
+		case code.OpSlice:
+			frame.ip = ip + 2
+			var low, high object.Object
+			if ins[ip+1]&code.SLICE_HIGH != 0 {
+				high = vm.pop()
+			}
+			if ins[ip+1]&code.SLICE_LOW != 0 {
+				low = vm.pop()
+			}
+			err = vm.pushResult(eval.SliceOperation(vm.pop(), low, high))
+		case code.OpInterpolate:
+			frame.ip = ip + 3
+			err = vm.interpolate(int(code.ReadUint16(ins[ip+1:])))
+
+		case code.OpIterInit:
+			frame.ip = ip + 2
+			it, iterErr := eval.NewIterator(vm.pop(), ins[ip+1] != 0)
+			if iterErr != nil {
+				err = iterErr
+				break
+			}
+			vm.push(&iterator{Iterator: it, pairs: ins[ip+1] != 0})
+		case code.OpIterNext:
+			it := frame.locals[code.ReadUint16(ins[ip+1:])].(*iterator)
+			first, second, ok := it.Next()
+			if !ok {
+				frame.ip = int(code.ReadUint16(ins[ip+3:]))
+				break
+			}
+			frame.ip = ip + 5
+			vm.push(first)
+			if it.pairs {
+				vm.push(second)
+			}
+		case code.OpMark:
+			frame.ip = ip + 3
+			frame.locals[code.ReadUint16(ins[ip+1:])] = stackMark(vm.sp)
+		case code.OpUnwind:
+			frame.ip = ip + 3
+			vm.sp = int(frame.locals[code.ReadUint16(ins[ip+1:])].(stackMark))
+
+		case code.OpSetupTry:
+			frame.ip = ip + 3
+			vm.handlers = append(vm.handlers, handler{
+				frame: len(vm.frames) - 1,
+				ip:    int(code.ReadUint16(ins[ip+1:])),
+				sp:    vm.sp,
+			})
+		case code.OpPopTry:
+			frame.ip = ip + 1
+			vm.handlers = vm.handlers[:len(vm.handlers)-1]
+		case code.OpThrow:
+			frame.ip = ip + 1
+			err = eval.ThrownError(vm.pop())
+		case code.OpError:
+			frame.ip = ip + 3
+			err = &object.Error{Message: constantString(constants, ins[ip+1:])}
+
+		default:
+			panic(fmt.Sprintf("unknown opcode %d", op))
+		}
+
+		if err != nil {
+			if !vm.raise(err) {
+				return err, true
+			}
+			frame = vm.currentFrame()
+			ins, constants = frame.Instructions(), frame.cl.Fn.Constants
+		}
+	}
+}
+
+// call calls fn with args. A tail call replaces the calling frame, so its
+// depth doesn't grow.
+func (vm *VM) call(fn object.Object, args []object.Object, tail bool) *object.Error {
+	switch fn := fn.(type) {
+	case *object.Builtin:
+		result := fn.Fn(args...)
+		if err, ok := result.(*object.Error); ok {
+			return err
+		}
+		if result == nil {
+			result = eval.NULL
+		}
+		if tail {
+			vm.returnValue(result)
+		} else {
+			vm.push(result)
+		}
+		return nil
+	case *object.Closure:
+		max := len(fn.Fn.Parameters)
+		if fn.Fn.Rest {
+			max = -1
+		}
+		if err := eval.CheckArity(fn.Fn.Name, len(args), fn.Fn.MinArguments, max); err != nil {
+			return err
+		}
+
+		var frame *Frame
+		if tail {
+			frame = vm.currentFrame()
+			vm.sp = frame.base
+			*frame = *NewFrame(fn, frame.base)
+		} else {
+			if len(vm.frames) > vm.maxDepth {
+				return &object.Error{Kind: object.RECURSION_ERROR, Message: "maximum recursion depth exceeded"}
+			}
+			frame = NewFrame(fn, vm.sp)
+			vm.frames = append(vm.frames, frame)
+		}
+
+		n := len(fn.Fn.Parameters)
+		if fn.Fn.Rest {
+			n--
+			rest := []object.Object{}
+			if n < len(args) {
+				rest = append(rest, args[n:]...)
+			}
+			frame.locals[n] = &object.Array{Elements: rest}
+		}
+		copy(frame.locals[:n], args)
+		return nil
+	default:
+		return &object.Error{Kind: object.TYPE_ERROR, Message: fmt.Sprintf("not a function: %s", fn.Type())}
+	}
+}
+
+// returnValue leaves the current function, passing value to its caller.
+func (vm *VM) returnValue(value object.Object) {
+	frame := vm.currentFrame()
+	vm.frames = vm.frames[:len(vm.frames)-1]
+	vm.sp = frame.base
+	vm.push(value)
+}
+
+// raise passes err to the innermost handler. It reports false if there is
+// none or err is fatal, in which case the program ends with err.
+func (vm *VM) raise(err *object.Error) bool {
+	// The instruction that produced an error determines its position and
+	// stack trace.
+	if !err.Span.Start.IsValid() {
+		frame := vm.currentFrame()
+		err.Span = code.SpanAt(frame.cl.Fn.Spans, frame.ip-1)
+		err.Stack = vm.stackTrace()
+		if err.Kind == "" {
+			err.Kind = object.RUNTIME_ERROR
+		}
+	}
+
+	if len(vm.handlers) == 0 || err.Fatal {
+		return false
+	}
+	h := vm.handlers[len(vm.handlers)-1]
+	vm.handlers = vm.handlers[:len(vm.handlers)-1]
+
+	vm.frames = vm.frames[:h.frame+1]
+	vm.currentFrame().ip = h.ip
+	vm.sp = h.sp
+	vm.push(&object.Exception{Error: err})
+	return true
+}
+
+// stackTrace lists the active calls, outermost first. The ip of a frame has
+// moved past the instruction it's executing, hence the ip-1.
+func (vm *VM) stackTrace() []object.TraceEntry {
+	trace := make([]object.TraceEntry, len(vm.frames))
+	for i, frame := range vm.frames {
+		name := "<main>"
+		if i > 0 {
+			name = frame.cl.Fn.Name
+			if name == "" {
+				name = "<anonymous>"
+			}
+		}
+		trace[i] = object.TraceEntry{Function: name, Pos: code.SpanAt(frame.cl.Fn.Spans, frame.ip-1).Start}
+	}
+	return trace
+}
+
+func (vm *VM) buildHash(n int) *object.Error {
+	pairs := make(map[object.HashKey]object.HashPair)
+	for i := vm.sp - 2*n; i < vm.sp; i += 2 {
+		key, value := vm.stack[i], vm.stack[i+1]
+		hashKey, ok := key.(object.Hashable)
+		if !ok {
+			return &object.Error{Kind: object.TYPE_ERROR, Message: fmt.Sprintf("unusable as hash key: %s", key.Type())}
+		}
+		pairs[hashKey.HashKey()] = object.HashPair{Key: key, Value: value}
+	}
+	vm.sp -= 2 * n
+	vm.push(&object.Hash{Pairs: pairs})
+	return nil
+}
+
+func (vm *VM) interpolate(n int) *object.Error {
+	var out strings.Builder
+	for _, part := range vm.stack[vm.sp-n : vm.sp] {
+		printable, ok := part.(object.Printable)
+		if !ok {
+			return &object.Error{Kind: object.TYPE_ERROR, Message: fmt.Sprintf("cannot interpolate type: %s", part.Type())}
+		}
+		out.WriteString(printable.String())
+	}
+	vm.sp -= n
+	vm.push(&object.String{Value: out.String()})
+	return nil
+}
+
+// pushResult pushes the result of an operation, or returns it if it's an
+// error.
+func (vm *VM) pushResult(result object.Object) *object.Error {
+	if err, ok := result.(*object.Error); ok {
+		return err
+	}
+	vm.push(result)
+	return nil
+}
+
+func constantString(constants []object.Object, operand []byte) string {
+	return constants[code.ReadUint16(operand)].(*object.String).Value
+}
+
+func (vm *VM) currentFrame() *Frame {
+	return vm.frames[len(vm.frames)-1]
+}
+
+func (vm *VM) push(o object.Object) {
+	if vm.sp == len(vm.stack) {
+		vm.stack = append(vm.stack, make([]object.Object, len(vm.stack))...)
+	}
+	vm.stack[vm.sp] = o
+	vm.sp++
+}
+
+func (vm *VM) pop() object.Object {
+	o := vm.stack[vm.sp-1]
+	vm.sp--
+	return o
+}
+
+// binaryOperation applies the operator of op, computing the common integer
+// cases directly and leaving the rest to the evaluator.
+func binaryOperation(op code.Opcode, left, right object.Object) object.Object {
+	if l, ok := left.(*object.Integer); ok {
+		if r, ok := right.(*object.Integer); ok {
+			if result := integerOperation(op, l.Value, r.Value); result != nil {
+				return result
+			}
+		}
+	}
+	return eval.InfixOperation(operators[op], left, right)
+}
+
+// integerOperation returns nil for the cases it doesn't handle, like
+// overflow and division by zero.
+func integerOperation(op code.Opcode, left, right int64) object.Object {
+	switch op {
+	case code.OpAdd:
+		result := left + right
+		if (left >= 0) == (right >= 0) && (result >= 0) != (left >= 0) {
+			return nil
+		}
+		return newInteger(result)
+	case code.OpSub:
+		result := left - right
+		if (left >= 0) != (right >= 0) && (result >= 0) != (left >= 0) {
+			return nil
+		}
+		return newInteger(result)
+	case code.OpMul:
+		if left > -1<<31 && left < 1<<31 && right > -1<<31 && right < 1<<31 {
+			return newInteger(left * right)
+		}
+		return nil
+	case code.OpBitAnd:
+		return newInteger(left & right)
+	case code.OpBitOr:
+		return newInteger(left | right)
+	case code.OpBitXor:
+		return newInteger(left ^ right)
+	case code.OpEqual:
+		return nativeBoolToBooleanObject(left == right)
+	case code.OpNotEqual:
+		return nativeBoolToBooleanObject(left != right)
+	case code.OpLess:
+		return nativeBoolToBooleanObject(left < right)
+	case code.OpGreater:
+		return nativeBoolToBooleanObject(left > right)
+	case code.OpLessEqual:
+		return nativeBoolToBooleanObject(left <= right)
+	case code.OpGreaterEqual:
+		return nativeBoolToBooleanObject(left >= right)
+	}
+	return nil
+}
+
+func newInteger(value int64) *object.Integer {
+	if value >= minCachedInteger && value <= maxCachedInteger {
+		return integers[value-minCachedInteger]
+	}
+	return &object.Integer{Value: value}
+}
+
+func nativeBoolToBooleanObject(input bool) *object.Boolean {
+	if input {
+		return eval.TRUE
+	}
+	return eval.FALSE
+}
+
+// valueRef lets an object.IndexRef index a value that isn't a variable.
+type valueRef struct {
+	value object.Object
+}
+
+func (r *valueRef) Type() object.ObjectType    { return object.LVALUE_OBJ }
+func (r *valueRef) Inspect() string            { return r.value.Inspect() }
+func (r *valueRef) Get() (object.Object, bool) { return r.value, true }
+func (r *valueRef) Update(object.Object) (object.Object, bool) {
+	return nil, false
+}
+
+// iterator is the state of a for loop, kept in a hidden local.
+type iterator struct {
+	*eval.Iterator
+	pairs bool
+}
+
+func (it *iterator) Type() object.ObjectType { return "ITERATOR" }
+func (it *iterator) Inspect() string         { return "iterator" }
+
+// stackMark is the stack pointer at the start of a loop, kept in a hidden
+// local so break and continue can drop what the loop body pushed.
+type stackMark int
+
+func (m stackMark) Type() object.ObjectType { return "STACK_MARK" }
+func (m stackMark) Inspect() string         { return "stack mark" }
@@ -0,0 +1,120 @@
+package vm
+
+import (
+	"context"
+	"kaze/compiler"
+	"kaze/lexer"
+	"kaze/object"
+	"kaze/parser"
+	"strings"
+	"testing"
+)
+
+func run(t *testing.T, input string, env *object.Environment) object.Object {
+	l := lexer.New(input)
+	p := parser.New(l)
+	program := p.ParseProgram()
+
+	c := compiler.New()
+	if err := c.Compile(program); err != nil {
+		t.Fatalf("%q: compiler error: %s", input, err)
+	}
+	return New(c.Bytecode(), env).Run()
+}
+
+func testIntegerObject(t *testing.T, obj object.Object, expected int64) {
+	result, ok := obj.(*object.Integer)
+	if !ok {
+		t.Fatalf("object is not Integer. got=%T (%+v)", obj, obj)
+	}
+	if result.Value != expected {
+		t.Errorf("object has wrong value. got=%d, want=%d", result.Value, expected)
+	}
+}
+
+func TestStackGrows(t *testing.T) {
+	elements := strings.Repeat("1, ", StackSize*2)
+	result := run(t, "len(["+elements+"])", object.NewEnvironment())
+	testIntegerObject(t, result, StackSize*2)
+
+	input := "fun f(n) { if n == 0 { 0 } else { 1 + f(n - 1) } } f(3000)"
+	testIntegerObject(t, run(t, input, object.NewEnvironment()), 3000)
+}
+
+func TestGlobalsLiveInEnvironment(t *testing.T) {
+	env := object.NewEnvironment()
+	env.Create("base", &object.Integer{Value: 40})
+
+	testIntegerObject(t, run(t, "var answer = base + 2; fun get() { answer } get()", env), 42)
+
+	answer, ok := env.Get("answer")
+	if !ok {
+		t.Fatalf("answer is not defined in the environment")
+	}
+	testIntegerObject(t, answer, 42)
+
+	// A later program sees the definitions of an earlier one, as in the REPL.
+	testIntegerObject(t, run(t, "answer += 1; get()", env), 43)
+}
+
+func TestClosuresSeeLaterDeclarations(t *testing.T) {
+	input := "fun f() { var g = fun() { h() }; var h = fun() { 7 }; g() } f()"
+	testIntegerObject(t, run(t, input, object.NewEnvironment()), 7)
+
+	input = `fun f(n) {
+		fun isEven(n) { if n == 0 { true } else { isOdd(n - 1) } }
+		fun isOdd(n) { if n == 0 { false } else { isEven(n - 1) } }
+		if isEven(n) { 1 } else { 0 }
+	}
+	f(10)`
+	testIntegerObject(t, run(t, input, object.NewEnvironment()), 1)
+
+	input = "fun f() { var g = fun() { h }; g(); var h = 1 } f()"
+	err, ok := run(t, input, object.NewEnvironment()).(*object.Error)
+	if !ok || err.Kind != object.NAME_ERROR || err.Message != "identifier not found: h" {
+		t.Errorf("wrong result for a name used before its declaration. got=%+v", err)
+	}
+}
+
+func TestRecoversFromPanics(t *testing.T) {
+	env := object.NewEnvironment()
+	env.Create("boom", &object.Builtin{Fn: func(args ...object.Object) object.Object {
+		panic("boom")
+	}})
+
+	result := run(t, "var n = 0; for i in 0..3 { try { boom() } catch (e) { n += 1 } } n", env)
+	testIntegerObject(t, result, 3)
+
+	result = run(t, "1 +\nboom()", env)
+	err, ok := result.(*object.Error)
+	if !ok {
+		t.Fatalf("object is not Error. got=%T (%+v)", result, result)
+	}
+	if err.Kind != object.INTERNAL_ERROR || err.Message != "internal error: boom" {
+		t.Errorf("wrong error. got=%s: %s", err.Kind, err.Message)
+	}
+	if err.Span.Start.String() != "2:1" {
+		t.Errorf("wrong error position. expected=2:1, got=%s", err.Span.Start)
+	}
+}
+
+func TestRunContext(t *testing.T) {
+	program := parser.New(lexer.New("var n = 0; while true { try { n += 1 } catch (e) { } }")).ParseProgram()
+	c := compiler.New()
+	if err := c.Compile(program); err != nil {
+		t.Fatalf("compiler error: %s", err)
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	env := object.NewEnvironment()
+	result := New(c.Bytecode(), env).RunContext(ctx)
+
+	err, ok := result.(*object.Error)
+	if !ok {
+		t.Fatalf("object is not Error. got=%T (%+v)", result, result)
+	}
+	if err.Kind != object.CANCELED_ERROR || !err.Fatal {
+		t.Errorf("wrong error. got=%s: %s", err.Kind, err.Message)
+	}
+}